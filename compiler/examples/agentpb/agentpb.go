@@ -0,0 +1,661 @@
+// Package agentpb is the wire format of the gRPC agent service described
+// in agent.proto: protobuf encoding for its messages, gRPC's
+// length-prefixed framing, and status codes. It implements just enough
+// of both to talk to generated stubs without depending on them.
+package agentpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service is the fully qualified service name from agent.proto
+const Service = "fifth.agent.v1.Agent"
+
+// Method paths, as gRPC puts them in the request URL
+const (
+	MethodValidate = "/" + Service + "/Validate"
+	MethodGenerate = "/" + Service + "/Generate"
+	MethodVerify   = "/" + Service + "/Verify"
+	MethodProcess  = "/" + Service + "/Process"
+)
+
+// ContentType marks gRPC requests and responses
+const ContentType = "application/grpc"
+
+// DefaultMaxMessageSize bounds a received message, as in grpc-go
+const DefaultMaxMessageSize = 4 << 20
+
+// Code is a gRPC status code
+type Code uint32
+
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+var codeNames = [...]string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "CODE(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Status is a non-OK gRPC status, returned as an error by either side
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: %s: %s", s.Code, s.Message)
+}
+
+// Errorf returns a Status with a formatted message
+func Errorf(code Code, format string, args ...any) *Status {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// EncodeStatusMessage percent-encodes s for the grpc-message trailer
+func EncodeStatusMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// DecodeStatusMessage reverses EncodeStatusMessage, keeping malformed
+// escapes as they are
+func DecodeStatusMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// timeoutUnits are grpc-timeout units, finest first
+var timeoutUnits = []struct {
+	unit   time.Duration
+	suffix string
+}{
+	{time.Nanosecond, "n"}, {time.Microsecond, "u"}, {time.Millisecond, "m"},
+	{time.Second, "S"}, {time.Minute, "M"}, {time.Hour, "H"},
+}
+
+// EncodeTimeout renders d as a grpc-timeout value: at most eight digits
+// in the finest unit that fits, rounded up
+func EncodeTimeout(d time.Duration) string {
+	d = max(d, 1)
+	for _, u := range timeoutUnits {
+		n := d / u.unit
+		if d%u.unit != 0 {
+			n++
+		}
+		if n < 1e8 {
+			return strconv.FormatInt(int64(n), 10) + u.suffix
+		}
+	}
+	return "99999999H"
+}
+
+// ParseTimeout reads a grpc-timeout value, clamping ones too long for a
+// time.Duration
+func ParseTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	for _, u := range timeoutUnits {
+		if u.suffix == s[len(s)-1:] {
+			if n > math.MaxInt64/int64(u.unit) {
+				return math.MaxInt64, true
+			}
+			return time.Duration(n) * u.unit, true
+		}
+	}
+	return 0, false
+}
+
+// Frame prefixes msg with gRPC's uncompressed-flag and length header
+func Frame(msg []byte) []byte {
+	out := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(msg)))
+	return append(out, msg...)
+}
+
+// ReadFrame reads one length-prefixed message of at most maxSize bytes.
+// It returns io.EOF when the stream ends before a message starts.
+func ReadFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if int64(n) > int64(maxSize) {
+		return nil, Errorf(ResourceExhausted, "message of %d bytes exceeds limit of %d", n, maxSize)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return msg, nil
+}
+
+// Message is implemented by every message in agent.proto
+type Message interface {
+	Marshal() []byte
+	Unmarshal(b []byte) error
+}
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// buffer appends protobuf fields, omitting proto3 zero values
+type buffer []byte
+
+func (b *buffer) key(num, wire int) {
+	*b = binary.AppendUvarint(*b, uint64(num)<<3|uint64(wire))
+}
+
+func (b *buffer) bytes(num int, p []byte) {
+	b.key(num, wireBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(p)))
+	*b = append(*b, p...)
+}
+
+func (b *buffer) string(num int, s string) {
+	if s != "" {
+		b.bytes(num, []byte(s))
+	}
+}
+
+func (b *buffer) strings(num int, ss []string) {
+	for _, s := range ss {
+		b.bytes(num, []byte(s))
+	}
+}
+
+func (b *buffer) bool(num int, v bool) {
+	if v {
+		b.key(num, wireVarint)
+		*b = append(*b, 1)
+	}
+}
+
+// int32 encodes v as an int32; negatives take ten bytes, as protobuf
+// specifies
+func (b *buffer) int32(num int, v int) {
+	if v != 0 {
+		b.key(num, wireVarint)
+		*b = binary.AppendUvarint(*b, uint64(int64(int32(v))))
+	}
+}
+
+func (b *buffer) double(num int, f float64) {
+	if f != 0 {
+		b.key(num, wireFixed64)
+		*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(f))
+	}
+}
+
+// sint64s encodes xs packed and zigzagged
+func (b *buffer) sint64s(num int, xs []int) {
+	if len(xs) == 0 {
+		return
+	}
+	var packed []byte
+	for _, x := range xs {
+		packed = binary.AppendUvarint(packed, uint64(int64(x)<<1)^uint64(int64(x)>>63))
+	}
+	b.bytes(num, packed)
+}
+
+func (b *buffer) message(num int, m Message) {
+	b.bytes(num, m.Marshal())
+}
+
+// field is one decoded field: v holds varint and fixed-width values,
+// data length-delimited ones
+type field struct {
+	num, wire int
+	v         uint64
+	data      []byte
+}
+
+var errTruncated = errors.New("agentpb: truncated message")
+
+// fields calls fn for each field of b in order
+func fields(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		f := field{num: int(key >> 3), wire: int(key & 7)}
+		if f.num == 0 {
+			return errors.New("agentpb: field number 0")
+		}
+		switch f.wire {
+		case wireVarint:
+			if f.v, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			f.v, n = binary.LittleEndian.Uint64(b), 8
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			f.v, n = uint64(binary.LittleEndian.Uint32(b)), 4
+		case wireBytes:
+			l, m := binary.Uvarint(b)
+			if m <= 0 || l > uint64(len(b)-m) {
+				return errTruncated
+			}
+			f.data, n = b[m:m+int(l)], m+int(l)
+		default:
+			return fmt.Errorf("agentpb: field %d has unsupported wire type %d", f.num, f.wire)
+		}
+		b = b[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f field) want(wire int) error {
+	if f.wire != wire {
+		return fmt.Errorf("agentpb: field %d has wire type %d, want %d", f.num, f.wire, wire)
+	}
+	return nil
+}
+
+func (f field) string(dst *string) error {
+	if err := f.want(wireBytes); err != nil {
+		return err
+	}
+	*dst = string(f.data)
+	return nil
+}
+
+func (f field) strings(dst *[]string) error {
+	var s string
+	if err := f.string(&s); err != nil {
+		return err
+	}
+	*dst = append(*dst, s)
+	return nil
+}
+
+func (f field) bool(dst *bool) error {
+	if err := f.want(wireVarint); err != nil {
+		return err
+	}
+	*dst = f.v != 0
+	return nil
+}
+
+func (f field) int32(dst *int) error {
+	if err := f.want(wireVarint); err != nil {
+		return err
+	}
+	*dst = int(int32(f.v))
+	return nil
+}
+
+func (f field) double(dst *float64) error {
+	if err := f.want(wireFixed64); err != nil {
+		return err
+	}
+	*dst = math.Float64frombits(f.v)
+	return nil
+}
+
+// sint64s accepts packed and unpacked encodings, as protobuf requires
+func (f field) sint64s(dst *[]int) error {
+	unzig := func(v uint64) int { return int(int64(v>>1) ^ -int64(v&1)) }
+	switch f.wire {
+	case wireVarint:
+		*dst = append(*dst, unzig(f.v))
+		return nil
+	case wireBytes:
+		for b := f.data; len(b) > 0; {
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errTruncated
+			}
+			*dst = append(*dst, unzig(v))
+			b = b[n:]
+		}
+		return nil
+	}
+	return f.want(wireBytes)
+}
+
+func (f field) message(m Message) error {
+	if err := f.want(wireBytes); err != nil {
+		return err
+	}
+	return m.Unmarshal(f.data)
+}
+
+// TestCase is one input/output stack pair
+type TestCase struct {
+	Input  []int
+	Output []int
+}
+
+func (m *TestCase) Marshal() []byte {
+	var b buffer
+	b.sint64s(1, m.Input)
+	b.sint64s(2, m.Output)
+	return b
+}
+
+func (m *TestCase) Unmarshal(p []byte) error {
+	*m = TestCase{}
+	return fields(p, func(f field) error {
+		switch f.num {
+		case 1:
+			return f.sint64s(&m.Input)
+		case 2:
+			return f.sint64s(&m.Output)
+		}
+		return nil
+	})
+}
+
+// Spec is orchestrator.Specification's wire form
+type Spec struct {
+	ID          string
+	Word        string
+	StackEffect string
+	PatternID   string
+	TestCases   []TestCase
+	Definitions []string
+	RequestID   string
+}
+
+func (m *Spec) Marshal() []byte {
+	var b buffer
+	b.string(1, m.ID)
+	b.string(2, m.Word)
+	b.string(3, m.StackEffect)
+	b.string(4, m.PatternID)
+	for i := range m.TestCases {
+		b.message(5, &m.TestCases[i])
+	}
+	b.strings(6, m.Definitions)
+	b.string(7, m.RequestID)
+	return b
+}
+
+func (m *Spec) Unmarshal(p []byte) error {
+	*m = Spec{}
+	return fields(p, func(f field) error {
+		switch f.num {
+		case 1:
+			return f.string(&m.ID)
+		case 2:
+			return f.string(&m.Word)
+		case 3:
+			return f.string(&m.StackEffect)
+		case 4:
+			return f.string(&m.PatternID)
+		case 5:
+			var tc TestCase
+			if err := f.message(&tc); err != nil {
+				return err
+			}
+			m.TestCases = append(m.TestCases, tc)
+		case 6:
+			return f.strings(&m.Definitions)
+		case 7:
+			return f.string(&m.RequestID)
+		}
+		return nil
+	})
+}
+
+// ValidateReply answers Validate
+type ValidateReply struct {
+	Valid  bool
+	Reason string // Why the spec is invalid
+}
+
+func (m *ValidateReply) Marshal() []byte {
+	var b buffer
+	b.bool(1, m.Valid)
+	b.string(2, m.Reason)
+	return b
+}
+
+func (m *ValidateReply) Unmarshal(p []byte) error {
+	*m = ValidateReply{}
+	return fields(p, func(f field) error {
+		switch f.num {
+		case 1:
+			return f.bool(&m.Valid)
+		case 2:
+			return f.string(&m.Reason)
+		}
+		return nil
+	})
+}
+
+// GenerateReply answers Generate
+type GenerateReply struct {
+	Code  string
+	Tests []string
+}
+
+func (m *GenerateReply) Marshal() []byte {
+	var b buffer
+	b.string(1, m.Code)
+	b.strings(2, m.Tests)
+	return b
+}
+
+func (m *GenerateReply) Unmarshal(p []byte) error {
+	*m = GenerateReply{}
+	return fields(p, func(f field) error {
+		switch f.num {
+		case 1:
+			return f.string(&m.Code)
+		case 2:
+			return f.strings(&m.Tests)
+		}
+		return nil
+	})
+}
+
+// VerifyRequest asks whether Code has stack effect Effect
+type VerifyRequest struct {
+	Code   string
+	Effect string
+}
+
+func (m *VerifyRequest) Marshal() []byte {
+	var b buffer
+	b.string(1, m.Code)
+	b.string(2, m.Effect)
+	return b
+}
+
+func (m *VerifyRequest) Unmarshal(p []byte) error {
+	*m = VerifyRequest{}
+	return fields(p, func(f field) error {
+		switch f.num {
+		case 1:
+			return f.string(&m.Code)
+		case 2:
+			return f.string(&m.Effect)
+		}
+		return nil
+	})
+}
+
+// Diagnostic is verify.Diagnostic's wire form
+type Diagnostic struct {
+	Word    string
+	Index   int
+	Message string
+}
+
+func (m *Diagnostic) Marshal() []byte {
+	var b buffer
+	b.string(1, m.Word)
+	b.int32(2, m.Index)
+	b.string(3, m.Message)
+	return b
+}
+
+func (m *Diagnostic) Unmarshal(p []byte) error {
+	*m = Diagnostic{}
+	return fields(p, func(f field) error {
+		switch f.num {
+		case 1:
+			return f.string(&m.Word)
+		case 2:
+			return f.int32(&m.Index)
+		case 3:
+			return f.string(&m.Message)
+		}
+		return nil
+	})
+}
+
+// VerifyReply answers Verify
+type VerifyReply struct {
+	Valid       bool
+	Diagnostics []Diagnostic
+}
+
+func (m *VerifyReply) Marshal() []byte {
+	var b buffer
+	b.bool(1, m.Valid)
+	for i := range m.Diagnostics {
+		b.message(2, &m.Diagnostics[i])
+	}
+	return b
+}
+
+func (m *VerifyReply) Unmarshal(p []byte) error {
+	*m = VerifyReply{}
+	return fields(p, func(f field) error {
+		switch f.num {
+		case 1:
+			return f.bool(&m.Valid)
+		case 2:
+			var d Diagnostic
+			if err := f.message(&d); err != nil {
+				return err
+			}
+			m.Diagnostics = append(m.Diagnostics, d)
+		}
+		return nil
+	})
+}
+
+// Result answers Process
+type Result struct {
+	SpecID    string
+	Success   bool
+	Code      string
+	Tests     []string
+	Error     string
+	LatencyMS float64
+	Category  string // orchestrator.FailureCategory; empty on success
+}
+
+func (m *Result) Marshal() []byte {
+	var b buffer
+	b.string(1, m.SpecID)
+	b.bool(2, m.Success)
+	b.string(3, m.Code)
+	b.strings(4, m.Tests)
+	b.string(5, m.Error)
+	b.double(6, m.LatencyMS)
+	b.string(7, m.Category)
+	return b
+}
+
+func (m *Result) Unmarshal(p []byte) error {
+	*m = Result{}
+	return fields(p, func(f field) error {
+		switch f.num {
+		case 1:
+			return f.string(&m.SpecID)
+		case 2:
+			return f.bool(&m.Success)
+		case 3:
+			return f.string(&m.Code)
+		case 4:
+			return f.strings(&m.Tests)
+		case 5:
+			return f.string(&m.Error)
+		case 6:
+			return f.double(&m.LatencyMS)
+		case 7:
+			return f.string(&m.Category)
+		}
+		return nil
+	})
+}
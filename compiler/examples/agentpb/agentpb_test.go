@@ -0,0 +1,186 @@
+package agentpb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		in, out Message
+	}{
+		{&TestCase{Input: []int{0, 1, -1, math.MaxInt64, math.MinInt64}, Output: []int{-300}}, new(TestCase)},
+		{&Spec{
+			ID:          "sq",
+			Word:        "square",
+			StackEffect: "( n -- n² )",
+			PatternID:   "DUP_TRANSFORM_001",
+			TestCases:   []TestCase{{Input: []int{-4}, Output: []int{16}}, {Output: []int{0}}},
+			Definitions: []string{": helper ;", ""},
+			RequestID:   "req-1",
+		}, new(Spec)},
+		{&ValidateReply{Valid: false, Reason: "spec has no word"}, new(ValidateReply)},
+		{&GenerateReply{Code: ": square dup * ;", Tests: []string{"T{ 4 square -> 16 }T"}}, new(GenerateReply)},
+		{&VerifyRequest{Code: "dup *", Effect: "( n -- n )"}, new(VerifyRequest)},
+		{&VerifyReply{Valid: true, Diagnostics: []Diagnostic{
+			{Word: "+", Index: -1, Message: "underflow"},
+			{Word: "dup", Index: math.MaxInt32},
+		}}, new(VerifyReply)},
+		{&Result{
+			SpecID: "sq", Success: true, Code: "dup *", Tests: []string{"a", "b"},
+			Error: "none", LatencyMS: -0.25, Category: "generation",
+		}, new(Result)},
+	}
+	for _, tc := range tests {
+		if err := tc.out.Unmarshal(tc.in.Marshal()); err != nil {
+			t.Errorf("%T: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.in, tc.out) {
+			t.Errorf("%T round trip = %+v, want %+v", tc.in, tc.out, tc.in)
+		}
+	}
+}
+
+func TestSint64Encoding(t *testing.T) {
+	// Packed and zigzagged: -1 -> 1, 1 -> 2, -2 -> 3
+	tc := &TestCase{Input: []int{-1, 1, -2}}
+	if got, want := tc.Marshal(), []byte{0x0a, 3, 1, 2, 3}; !bytes.Equal(got, want) {
+		t.Errorf("Marshal = % x, want % x", got, want)
+	}
+
+	// Decoders must accept the unpacked form too
+	var got TestCase
+	if err := got.Unmarshal([]byte{0x08, 1, 0x08, 2, 0x10, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (TestCase{Input: []int{-1, 1}, Output: []int{-2}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("unpacked = %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	want := &Spec{ID: "sq", Word: "square", TestCases: []TestCase{{Input: []int{-3}}}}
+	var b buffer
+	b.key(99, wireVarint)
+	b = binary.AppendUvarint(b, 1<<40)
+	b.key(100, wireFixed64)
+	b = binary.LittleEndian.AppendUint64(b, 7)
+	b.key(101, wireFixed32)
+	b = binary.LittleEndian.AppendUint32(b, 7)
+	b.bytes(102, []byte("from a newer agent"))
+	msg := append(append(b, want.Marshal()...), b...)
+
+	var got Spec
+	if err := got.Unmarshal(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("Unmarshal = %+v, want %+v", got, *want)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	full := (&Spec{ID: "sq", Word: "square"}).Marshal()
+	tests := map[string][]byte{
+		"truncated string": full[:len(full)-1],
+		"truncated varint": {0x08, 0x80},
+		"truncated fixed":  {0x31, 1, 2, 3},
+		"field zero":       {0x00, 1},
+		"group wire type":  {0x0b},
+		"wrong wire type":  {0x08, 1}, // id is a string
+	}
+	for name, msg := range tests {
+		var s Spec
+		if err := s.Unmarshal(msg); err == nil {
+			t.Errorf("%s: Unmarshal(% x) = %+v, want an error", name, msg, s)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	for _, d := range []time.Duration{1, time.Microsecond, 1500 * time.Millisecond, time.Hour, math.MaxInt64} {
+		got, ok := ParseTimeout(EncodeTimeout(d))
+		if !ok || got < d {
+			t.Errorf("ParseTimeout(EncodeTimeout(%v)) = %v, %v", d, got, ok)
+		}
+	}
+
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"100m", 100 * time.Millisecond, true},
+		{"5S", 5 * time.Second, true},
+		{"99999999H", math.MaxInt64, true},
+		{"2562047H", 2562047 * time.Hour, true},
+		{"2562048H", math.MaxInt64, true},
+		{"", 0, false},
+		{"5", 0, false},
+		{"5s", 0, false},
+		{"-5S", 0, false},
+		{"123456789S", 0, false},
+	}
+	for _, tc := range tests {
+		got, ok := ParseTimeout(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("ParseTimeout(%q) = %v, %v; want %v, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	stream := append(Frame([]byte("hello")), Frame(nil)...)
+	r := bytes.NewReader(stream)
+	for _, want := range []string{"hello", ""} {
+		msg, err := ReadFrame(r, 16)
+		if err != nil || string(msg) != want {
+			t.Errorf("ReadFrame = %q, %v; want %q", msg, err, want)
+		}
+	}
+	if _, err := ReadFrame(r, 16); err != io.EOF {
+		t.Errorf("ReadFrame at end = %v, want io.EOF", err)
+	}
+
+	tests := []struct {
+		frame []byte
+		code  Code
+	}{
+		{Frame(make([]byte, 17)), ResourceExhausted},
+		{append([]byte{1}, Frame(nil)[1:]...), Unimplemented},
+	}
+	for _, tc := range tests {
+		var st *Status
+		if _, err := ReadFrame(bytes.NewReader(tc.frame), 16); !errors.As(err, &st) || st.Code != tc.code {
+			t.Errorf("ReadFrame(% x) = %v, want %s", tc.frame[:5], err, tc.code)
+		}
+	}
+	if _, err := ReadFrame(bytes.NewReader(Frame([]byte("hello"))[:7]), 16); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadFrame of a cut message = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestStatusMessage(t *testing.T) {
+	for _, s := range []string{"", "plain", "100% sure", "line\nbreak", "n² ≠ n"} {
+		enc := EncodeStatusMessage(s)
+		for _, c := range []byte(enc) {
+			if c < 0x20 || c > 0x7e {
+				t.Errorf("EncodeStatusMessage(%q) = %q, not printable ASCII", s, enc)
+				break
+			}
+		}
+		if got := DecodeStatusMessage(enc); got != s {
+			t.Errorf("DecodeStatusMessage(%q) = %q, want %q", enc, got, s)
+		}
+	}
+	if got := DecodeStatusMessage("50%zz"); got != "50%zz" {
+		t.Errorf("malformed escape decoded to %q", got)
+	}
+}
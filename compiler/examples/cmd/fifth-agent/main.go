@@ -0,0 +1,57 @@
+// Command fifth-agent serves the Fast Forth agent API from a single Go
+// binary; `fifth serve` runs it
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/quivent/fifth/compiler/examples/server"
+)
+
+func main() {
+	host := flag.String("host", "127.0.0.1", "interface to listen on")
+	port := flag.Int("port", 8080, "port to listen on")
+	depth := flag.Int("search-depth", server.DefaultSearchDepth, "longest word sequence tried for unknown patterns (0 = off)")
+	verbose := flag.Bool("v", false, "log every request")
+	tlsCert := flag.String("tls-cert", "", "serve HTTPS with this PEM certificate")
+	tlsKey := flag.String("tls-key", "", "private key for -tls-cert")
+	clientCA := flag.String("client-ca", "", "with -tls-cert, require client certificates signed by this CA bundle (mutual TLS)")
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	var tlsConfig *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		cfg, err := server.LoadTLSConfig(*tlsCert, *tlsKey, *clientCA)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		tlsConfig = cfg
+	} else if *clientCA != "" {
+		fmt.Fprintln(os.Stderr, "-client-ca needs -tls-cert and -tls-key")
+		os.Exit(2)
+	}
+
+	// Ctrl-C drains in-flight requests before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := server.ListenAndServe(ctx, net.JoinHostPort(*host, strconv.Itoa(*port)), tlsConfig,
+		server.WithLogger(logger), server.WithSearchDepth(*depth))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,669 @@
+// Command fifth drives Fast Forth agents from the shell:
+//
+//	fifth run      [flags] SPECS...  process spec files across the agent pool
+//	fifth validate [flags] SPECS...  check specs locally, then with an agent
+//	fifth generate [flags] SPECS...  print the code an agent writes for each spec
+//	fifth verify   [flags] [FILE]    check Forth code against a stack effect
+//	fifth serve    [flags]           run an agent (as fifth-agent)
+//	fifth bench    [flags]           measure throughput on synthetic specs
+//	fifth schema   [spec|result]     print the JSON Schema for specs or results
+//
+// SPECS are spec files, directories or globs (see spec.Load); "-" reads
+// a JSON array from stdin. Agents
+// come from -agents, -config, $FIFTH_AGENT_URLS, or $FIFTH_CONFIG, in
+// that order, defaulting to http://localhost:8080. $FIFTH_WORKERS,
+// $FIFTH_TIMEOUT, $FIFTH_STAGE_TIMEOUTS and $FIFTH_TLS_* apply where no
+// flag overrides them, with the same conflicts as the flags (see
+// orchestrator.NewCoordinatorFromEnv).
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+	"github.com/quivent/fifth/compiler/examples/report"
+	"github.com/quivent/fifth/compiler/examples/server"
+	"github.com/quivent/fifth/compiler/examples/spec"
+	"github.com/quivent/fifth/compiler/examples/verify"
+)
+
+// command is one fifth subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"run", "process spec files across the agent pool", cmdRun},
+	{"validate", "check specs locally, then with an agent", cmdValidate},
+	{"generate", "print the code an agent writes for each spec", cmdGenerate},
+	{"verify", "check Forth code against a stack effect", cmdVerify},
+	{"serve", "run an agent", cmdServe},
+	{"bench", "measure throughput on synthetic specs", cmdBench},
+	{"schema", "print the JSON Schema for specs or results", cmdSchema},
+}
+
+// errUsage exits with status 2; the flag set has already said why
+var errUsage = errors.New("usage")
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: fifth <command> [flags] [args]\n\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'fifth <command> -h' for a command's flags.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+	i := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "fifth: unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	// Ctrl-C stops the command; run keeps partial results
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := commands[i].run(ctx, os.Args[2:]); err != nil {
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "fifth %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns a flag set whose usage line names the command
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet("fifth "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: fifth %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// poolFlags select and tune the agents a command talks to
+type poolFlags struct {
+	agents   string
+	config   string
+	selector string
+	workers  int
+	timeout  time.Duration
+	grpc     bool
+	verbose  bool
+
+	tlsCA, tlsCert, tlsKey string
+}
+
+func (p *poolFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.agents, "agents", "", "comma-separated agent URLs (default $"+orchestrator.EnvAgentURLs+", else http://localhost:8080)")
+	fs.StringVar(&p.config, "config", "", "JSON fleet file listing the agents, instead of -agents (default $"+orchestrator.EnvConfig+")")
+	fs.StringVar(&p.selector, "select", "", "with a fleet file, only agents with these labels, e.g. zone=eu (default $"+orchestrator.EnvSelector+")")
+	fs.IntVar(&p.workers, "workers", 0, "max concurrent specs (0 = $"+orchestrator.EnvWorkers+", else 8 per agent)")
+	fs.DurationVar(&p.timeout, "timeout", 0, "per-request timeout (0 = $"+orchestrator.EnvTimeout+", else 30s)")
+	fs.BoolVar(&p.grpc, "grpc", false, "talk to agents over gRPC (HTTP/2) instead of JSON over HTTP")
+	fs.BoolVar(&p.verbose, "v", false, "log each spec to stderr")
+	fs.StringVar(&p.tlsCA, "tls-ca", "", "CA bundle that signed the https:// agents' certificates (default $"+orchestrator.EnvTLSCA+", else system roots)")
+	fs.StringVar(&p.tlsCert, "tls-cert", "", "client certificate for agents that require mutual TLS (default $"+orchestrator.EnvTLSCert+")")
+	fs.StringVar(&p.tlsKey, "tls-key", "", "private key for -tls-cert (default $"+orchestrator.EnvTLSKey+")")
+}
+
+// urls lists the agent base URLs from -agents or the environment
+func (p *poolFlags) urls() []string {
+	raw := p.agents
+	if raw == "" {
+		raw = os.Getenv(orchestrator.EnvAgentURLs)
+	}
+	var urls []string
+	for u := range strings.SplitSeq(raw, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		urls = []string{"http://localhost:8080"}
+	}
+	return urls
+}
+
+// coordinator builds the agent pool the flags describe
+func (p *poolFlags) coordinator(opts ...orchestrator.CoordinatorOption) (*orchestrator.Coordinator, error) {
+	level := slog.LevelWarn
+	if p.verbose {
+		level = slog.LevelInfo
+	}
+	opts = append([]orchestrator.CoordinatorOption{
+		orchestrator.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))),
+		orchestrator.WithProgressInterval(0),
+	}, opts...)
+	workers := p.workers
+	if workers == 0 {
+		var err error
+		if workers, err = orchestrator.WorkersFromEnv(); err != nil {
+			return nil, err
+		}
+	}
+	if workers > 0 {
+		opts = append(opts, orchestrator.WithWorkers(workers))
+	}
+	if p.config != "" && p.agents != "" {
+		return nil, errors.New("-agents and -config are exclusive")
+	}
+	config, selectorFlag := p.config, p.selector
+	if config == "" && p.agents == "" && os.Getenv(orchestrator.EnvAgentURLs) == "" {
+		config = os.Getenv(orchestrator.EnvConfig)
+	}
+	if config != "" {
+		if err := p.fleetConflict(); err != nil {
+			return nil, err
+		}
+		if selectorFlag == "" {
+			selectorFlag = os.Getenv(orchestrator.EnvSelector)
+		}
+		selector, err := orchestrator.ParseSelector(selectorFlag)
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := orchestrator.LoadFleetConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		c, err := cfg.Select(selector).NewCoordinator(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", config, err)
+		}
+		return c, nil
+	}
+	switch {
+	case p.selector != "":
+		return nil, fmt.Errorf("-select %w", orchestrator.ErrSelectorNeedsFleet)
+	case os.Getenv(orchestrator.EnvSelector) != "":
+		return nil, fmt.Errorf("%s %w", orchestrator.EnvSelector, orchestrator.ErrSelectorNeedsFleet)
+	}
+
+	// The environment's settings first, so the flags' override them
+	agentOpts, tlsConfig, err := orchestrator.AgentOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if p.timeout > 0 {
+		agentOpts = append(agentOpts, orchestrator.WithTimeout(p.timeout))
+	}
+	if p.tlsCA != "" || p.tlsCert != "" || p.tlsKey != "" {
+		if tlsConfig, err = orchestrator.LoadClientTLSConfig(p.tlsCert, p.tlsKey, p.tlsCA); err != nil {
+			return nil, err
+		}
+		agentOpts = append(agentOpts, orchestrator.WithTLSConfig(tlsConfig))
+	}
+	var agents []*orchestrator.FastForthAgent
+	for _, u := range p.urls() {
+		agent, err := p.agent(u, tlsConfig, agentOpts)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+	return orchestrator.NewCoordinatorWithAgents(agents, opts...), nil
+}
+
+// fleetConflict reports a set flag or variable that a fleet file would
+// silently override: the file sets each agent's transport
+func (p *poolFlags) fleetConflict() error {
+	return orchestrator.FleetConflict(append([]orchestrator.FleetSetting{
+		{Name: "-grpc", Set: p.grpc},
+		{Name: "-timeout", Field: "timeout", Set: p.timeout != 0},
+		{Name: "-tls-*", Field: "tls", Set: p.tlsCA != "" || p.tlsCert != "" || p.tlsKey != ""},
+	}, orchestrator.FleetSettingsFromEnv()...)...)
+}
+
+func (p *poolFlags) agent(rawURL string, tlsConfig *tls.Config, opts []orchestrator.AgentOption) (*orchestrator.FastForthAgent, error) {
+	if !p.grpc {
+		return orchestrator.NewFastForthAgentURL(rawURL, opts...)
+	}
+	g, err := orchestrator.NewGRPCAgentTLS(rawURL, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return orchestrator.NewAgent(g.URL, g, opts...), nil
+}
+
+const strictHelp = "reject unknown fields and mistyped values in specs (see 'fifth schema')"
+
+// loadSpecs reads spec files, directories and globs (see spec.Load);
+// specs from stdin come first
+func loadSpecs(paths []string, strict bool) ([]orchestrator.Specification, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no spec files given")
+	}
+	var stdin []orchestrator.Specification
+	if slices.Contains(paths, "-") {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		if strict {
+			if err := spec.ValidateJSON("stdin", data); err != nil {
+				return nil, err
+			}
+		}
+		if err := json.Unmarshal(data, &stdin); err != nil {
+			return nil, fmt.Errorf("stdin: %w", err)
+		}
+		paths = slices.DeleteFunc(paths, func(p string) bool { return p == "-" })
+		if len(paths) == 0 {
+			return stdin, nil
+		}
+	}
+	specs, err := spec.Loader{Strict: strict}.Load(paths...)
+	if err != nil {
+		return nil, err
+	}
+	return append(stdin, specs...), nil
+}
+
+// output opens -o, or stdout when it is empty or "-"
+func output(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// reporter returns the Reporter for -format
+func reporter(format string, w io.Writer, agents int) (orchestrator.Reporter, error) {
+	switch format {
+	case "text":
+		return orchestrator.TextReporter{W: w, Agents: agents}, nil
+	case "json":
+		return orchestrator.JSONReporter{W: w, Results: true}, nil
+	}
+	return report.New(format, w)
+}
+
+const formatHelp = "output format: text, json, jsonl, csv, or junit"
+
+func cmdRun(ctx context.Context, args []string) error {
+	fs := newFlagSet("run", "SPECS...")
+	var pool poolFlags
+	pool.register(fs)
+	format := fs.String("format", "text", formatHelp)
+	out := fs.String("o", "", "write the report to this file (default stdout)")
+	checkpoint := fs.String("checkpoint", "", "append results to this NDJSON file and skip specs it already has succeeding")
+	strict := fs.Bool("strict", false, strictHelp)
+	fs.Parse(args)
+
+	specs, err := loadSpecs(fs.Args(), *strict)
+	if err != nil {
+		return err
+	}
+	var opts []orchestrator.CoordinatorOption
+	if *checkpoint != "" {
+		opts = append(opts, orchestrator.WithCheckpoint(*checkpoint))
+	}
+	c, err := pool.coordinator(opts...)
+	if err != nil {
+		return err
+	}
+	w, err := output(*out)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	rep, err := reporter(*format, w, len(c.Agents()))
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	run := c.Run
+	if *checkpoint != "" {
+		run = c.Resume
+	}
+	results, runErr := run(ctx, specs)
+	if runErr != nil && results == nil {
+		return runErr
+	}
+	stats := orchestrator.ComputeStats(results, time.Since(start))
+	if err := rep.Report(stats, results); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("%d of %d specs failed", stats.Failed, stats.Total)
+	}
+	return nil
+}
+
+func cmdValidate(ctx context.Context, args []string) error {
+	fs := newFlagSet("validate", "SPECS...")
+	var pool poolFlags
+	pool.register(fs)
+	local := fs.Bool("local", false, "only run the local checks; contact no agent")
+	batch := fs.Int("batch", 100, "specs per /spec/validate/batch request")
+	strict := fs.Bool("strict", false, strictHelp)
+	fs.Parse(args)
+
+	specs, err := loadSpecs(fs.Args(), *strict)
+	if err != nil {
+		return err
+	}
+
+	// 1. Local checks: files were checked as they loaded, stdin was not
+	problems := make([]string, len(specs))
+	for i, s := range specs {
+		if err := spec.Check(s); err != nil {
+			problems[i] = err.Error()
+		}
+	}
+
+	// 2. The agent's opinion on the specs that passed
+	if !*local {
+		var (
+			pending []orchestrator.Specification
+			index   []int
+		)
+		for i, spec := range specs {
+			if problems[i] == "" {
+				pending = append(pending, spec)
+				index = append(index, i)
+			}
+		}
+		c, err := pool.coordinator()
+		if err != nil {
+			return err
+		}
+		valid, err := c.ValidateAll(ctx, pending, *batch)
+		if err != nil {
+			return err
+		}
+		for j, ok := range valid {
+			if !ok {
+				problems[index[j]] = "rejected by agent"
+			}
+		}
+	}
+
+	invalid := 0
+	for i, spec := range specs {
+		if problems[i] == "" {
+			fmt.Printf("%s: ok\n", specName(spec, i))
+			continue
+		}
+		invalid++
+		fmt.Printf("%s: %s\n", specName(spec, i), problems[i])
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d specs invalid", invalid, len(specs))
+	}
+	return nil
+}
+
+// specName identifies a spec in output: its ID, else word, else position
+func specName(spec orchestrator.Specification, i int) string {
+	switch {
+	case spec.ID != "":
+		return spec.ID
+	case spec.Word != "":
+		return spec.Word
+	}
+	return "#" + strconv.Itoa(i)
+}
+
+func cmdGenerate(ctx context.Context, args []string) error {
+	fs := newFlagSet("generate", "SPECS...")
+	var pool poolFlags
+	pool.register(fs)
+	format := fs.String("format", "text", "text (the code of each spec) or jsonl (spec_id, code, tests)")
+	out := fs.String("o", "", "write to this file (default stdout)")
+	strict := fs.Bool("strict", false, strictHelp)
+	fs.Parse(args)
+
+	if *format != "text" && *format != "jsonl" {
+		fmt.Fprintf(os.Stderr, "-format must be text or jsonl, got %q\n", *format)
+		return errUsage
+	}
+	specs, err := loadSpecs(fs.Args(), *strict)
+	if err != nil {
+		return err
+	}
+	c, err := pool.coordinator()
+	if err != nil {
+		return err
+	}
+	agents := c.Agents()
+	w, err := output(*out)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	enc := json.NewEncoder(w)
+	failed := 0
+	for i, spec := range specs {
+		// Spread specs over the pool; generation is sequential so the
+		// output keeps the input order
+		code, tests, err := agents[i%len(agents)].GenerateCode(ctx, spec)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %v\n", specName(spec, i), err)
+			continue
+		}
+		if *format == "jsonl" {
+			err = enc.Encode(struct {
+				SpecID string   `json:"spec_id"`
+				Code   string   `json:"code"`
+				Tests  []string `json:"tests,omitempty"`
+			}{spec.ID, code, tests})
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n\n", code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d specs failed to generate", failed, len(specs))
+	}
+	return nil
+}
+
+func cmdVerify(ctx context.Context, args []string) error {
+	fs := newFlagSet("verify", "[FILE]")
+	var pool poolFlags
+	pool.register(fs)
+	effect := fs.String("effect", "", "declared stack effect, e.g. \"( n -- n² )\" (required)")
+	remote := fs.Bool("remote", false, "ask the first agent instead of checking locally")
+	fs.Parse(args)
+
+	if *effect == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+	in := io.Reader(os.Stdin)
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	code, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	if *remote {
+		c, err := pool.coordinator()
+		if err != nil {
+			return err
+		}
+		valid, err := c.Agents()[0].VerifyStackEffect(ctx, string(code), *effect)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return fmt.Errorf("code does not match %s", *effect)
+		}
+		fmt.Println("ok")
+		return nil
+	}
+
+	valid, diags, err := verify.StackEffect(string(code), *effect)
+	if err != nil {
+		return err
+	}
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if !valid {
+		return fmt.Errorf("code does not match %s", *effect)
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func cmdServe(ctx context.Context, args []string) error {
+	fs := newFlagSet("serve", "")
+	host := fs.String("host", "127.0.0.1", "interface to listen on")
+	port := fs.Int("port", 8080, "port to listen on")
+	depth := fs.Int("search-depth", server.DefaultSearchDepth, "longest word sequence tried for unknown patterns (0 = off)")
+	verbose := fs.Bool("v", false, "log every request")
+	tlsCert := fs.String("tls-cert", "", "serve HTTPS with this PEM certificate")
+	tlsKey := fs.String("tls-key", "", "private key for -tls-cert")
+	clientCA := fs.String("client-ca", "", "with -tls-cert, require client certificates signed by this CA bundle (mutual TLS)")
+	fs.Parse(args)
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	var tlsConfig *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		cfg, err := server.LoadTLSConfig(*tlsCert, *tlsKey, *clientCA)
+		if err != nil {
+			return err
+		}
+		tlsConfig = cfg
+	} else if *clientCA != "" {
+		return errors.New("-client-ca needs -tls-cert and -tls-key")
+	}
+
+	// Ctrl-C drains in-flight requests before returning
+	return server.ListenAndServe(ctx, net.JoinHostPort(*host, strconv.Itoa(*port)), tlsConfig,
+		server.WithLogger(logger), server.WithSearchDepth(*depth))
+}
+
+func cmdBench(ctx context.Context, args []string) error {
+	fs := newFlagSet("bench", "")
+	var pool poolFlags
+	pool.register(fs)
+	n := fs.Int("specs", 100, "number of synthetic specs")
+	template := fs.String("template", "square", "spec template: square, factorial, drop, or mixed")
+	format := fs.String("format", "text", "text, or json for RunStats")
+	fs.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "-format must be text or json, got %q\n", *format)
+		return errUsage
+	}
+	if *n < 1 {
+		fmt.Fprintf(os.Stderr, "-specs must be at least 1, got %d\n", *n)
+		return errUsage
+	}
+	specs, err := orchestrator.SyntheticSpecs(*template, *n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-template: %v\n", err)
+		return errUsage
+	}
+
+	c, err := pool.coordinator()
+	if err != nil {
+		return err
+	}
+	// Warm agents before timing starts
+	if err := c.Warmup(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	start := time.Now()
+	results, err := c.Run(ctx, specs)
+	if err != nil && results == nil {
+		return err
+	}
+	stats := orchestrator.ComputeStats(results, time.Since(start))
+	if *format == "json" {
+		return orchestrator.JSONReporter{}.Report(stats, results)
+	}
+	fmt.Printf("%d specs on %d agents in %v: %d succeeded, %d failed\n",
+		stats.Total, len(c.Agents()), stats.Elapsed.Round(time.Millisecond), stats.Succeeded, stats.Failed)
+	fmt.Printf("throughput %.1f specs/s, latency p50 %.2fms p95 %.2fms p99 %.2fms\n",
+		stats.Throughput, stats.P50LatencyMS, stats.P95LatencyMS, stats.P99LatencyMS)
+	return nil
+}
+
+func cmdSchema(_ context.Context, args []string) error {
+	fs := newFlagSet("schema", "[spec|result]")
+	fs.Parse(args)
+
+	var v any
+	switch fs.Arg(0) {
+	case "", "spec":
+		v = orchestrator.Specification{}
+	case "result":
+		v = orchestrator.Result{}
+	default:
+		fs.Usage()
+		return errUsage
+	}
+	data, err := spec.Schema(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", data)
+	return err
+}
@@ -0,0 +1,284 @@
+// Command orchestrator runs synthetic Fast Forth specs across agents on
+// local ports 8080 upward, or those named by -agent-urls or -config, and
+// prints a summary
+//
+// Binary size: 1-2 MB (vs Python's 20 MB)
+// Compilation: 200-800ms (vs Rust's 30-180s)
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+	resultreport "github.com/quivent/fifth/compiler/examples/report"
+)
+
+// buildAgents makes the pool from a fleet file, a URL list, or n local
+// ports, in that order of preference. A fleet file also yields its
+// fleet-wide coordinator options.
+func buildAgents(n int, urls, fleetFile, selector string, useGRPC bool, tlsConfig *tls.Config, opts []orchestrator.AgentOption) ([]*orchestrator.FastForthAgent, []orchestrator.CoordinatorOption, error) {
+	if fleetFile != "" {
+		// The file sets each agent's transport
+		switch {
+		case strings.TrimSpace(urls) != "":
+			return nil, nil, errors.New("-agent-urls and -config are exclusive")
+		case useGRPC:
+			return nil, nil, errors.New("-grpc cannot be combined with a fleet file")
+		}
+		sel, err := orchestrator.ParseSelector(selector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("-select: %w", err)
+		}
+		cfg, err := orchestrator.LoadFleetConfig(fleetFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg = cfg.Select(sel)
+		agents, err := cfg.NewAgents(opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", fleetFile, err)
+		}
+		fleetOpts, err := cfg.CoordinatorOptions()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", fleetFile, err)
+		}
+		return agents, fleetOpts, nil
+	}
+	if selector != "" {
+		return nil, nil, errors.New("-select needs -config: only fleet files carry labels")
+	}
+
+	var raw []string
+	for u := range strings.SplitSeq(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			raw = append(raw, u)
+		}
+	}
+	if len(raw) == 0 {
+		if n < 1 {
+			return nil, nil, fmt.Errorf("-agents must be at least 1, got %d", n)
+		}
+		for i := range n {
+			raw = append(raw, fmt.Sprintf("http://localhost:%d", 8080+i))
+		}
+	}
+
+	agents := make([]*orchestrator.FastForthAgent, len(raw))
+	for i, u := range raw {
+		if useGRPC {
+			g, err := orchestrator.NewGRPCAgentTLS(u, tlsConfig)
+			if err != nil {
+				return nil, nil, err
+			}
+			agents[i] = orchestrator.NewAgent(g.URL, g, opts...)
+			continue
+		}
+		agent, err := orchestrator.NewFastForthAgentURL(u, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		agents[i] = agent
+	}
+	return agents, nil, nil
+}
+
+func main() {
+	numSpecs := flag.Int("specs", 100, "number of synthetic specs")
+	numAgents := flag.Int("agents", 10, "number of agents on localhost ports 8080 upward")
+	agentURLs := flag.String("agent-urls", "", "comma-separated agent URLs, instead of -agents")
+	fleetFile := flag.String("config", "", "JSON fleet file listing the agents with weights and labels, instead of -agents")
+	tlsCA := flag.String("tls-ca", "", "CA bundle that signed the https:// agents' certificates (default system roots)")
+	tlsCert := flag.String("tls-cert", "", "client certificate for agents that require mutual TLS")
+	tlsKey := flag.String("tls-key", "", "private key for -tls-cert")
+	selector := flag.String("select", "", "with -config, only agents with these labels, e.g. zone=eu,gpu=true")
+	workers := flag.Int("workers", 0, "max concurrent specs (0 = 8 per agent)")
+	template := flag.String("template", "square", "spec template: square, factorial, drop, or mixed")
+	report := flag.String("report", "text", "report format: text, json, jsonl, csv, or junit")
+	jsonOut := flag.Bool("json", false, "shorthand for -report json")
+	progress := flag.Int("progress", orchestrator.DefaultProgressInterval, "log progress every N completed specs (0 = off)")
+	maxDuration := flag.Duration("max-duration", 0, "stop dispatching specs after this long (0 = no limit)")
+	balance := flag.String("balance", "rr", "load balancing: rr (weighted round-robin), least (fewest outstanding), or latency (EWMA)")
+	checkpoint := flag.String("checkpoint", "", "append results to this NDJSON file and skip specs it already has succeeding")
+	healthEvery := flag.Duration("health", 0, "probe agents' /health this often and route around dead ones (0 = off)")
+	stageTimeouts := flag.String("stage-timeouts", "", "per-stage timeouts, e.g. validate=100ms,generate=60s,verify=100ms")
+	metricsAddr := flag.String("metrics", "", "serve Prometheus metrics on this address, e.g. :9090 (empty = off)")
+	traceDest := flag.String("trace", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "export spans to an OTLP/HTTP collector URL, or \"stderr\" for JSON lines (default $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	logLevel := flag.String("log-level", "info", "debug (adds a record per stage), info, warn, error, or off")
+	logFormat := flag.String("log-format", "text", "log records as text or json")
+	useGRPC := flag.Bool("grpc", false, "talk to agents over gRPC (HTTP/2) instead of JSON over HTTP")
+	flag.Parse()
+
+	// Create example specs
+	if *numSpecs < 1 {
+		fmt.Fprintf(os.Stderr, "-specs must be at least 1, got %d\n", *numSpecs)
+		os.Exit(2)
+	}
+	specs, err := orchestrator.SyntheticSpecs(*template, *numSpecs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *jsonOut {
+		*report = "json"
+	}
+	var reporter orchestrator.Reporter
+	switch *report {
+	case "text":
+		reporter = orchestrator.TextReporter{}
+	case "json":
+		reporter = orchestrator.JSONReporter{}
+	default:
+		if reporter, err = resultreport.New(*report, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "-report must be text, json, jsonl, csv, or junit, got %q\n", *report)
+			os.Exit(2)
+		}
+	}
+
+	// Keep stdout clean for machine-readable reports
+	logOut := os.Stdout
+	if *report != "text" {
+		logOut = os.Stderr
+	}
+	var level slog.Level
+	if *logLevel != "off" {
+		if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+			fmt.Fprintf(os.Stderr, "-log-level must be debug, info, warn, error, or off, got %q\n", *logLevel)
+			os.Exit(2)
+		}
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch {
+	case *logLevel == "off":
+		handler = slog.DiscardHandler
+	case *logFormat == "text":
+		handler = slog.NewTextHandler(logOut, handlerOpts)
+	case *logFormat == "json":
+		handler = slog.NewJSONHandler(logOut, handlerOpts)
+	default:
+		fmt.Fprintf(os.Stderr, "-log-format must be text or json, got %q\n", *logFormat)
+		os.Exit(2)
+	}
+	logger := slog.New(handler)
+	opts := []orchestrator.CoordinatorOption{
+		orchestrator.WithLogger(logger),
+		orchestrator.WithWorkers(*workers),
+		orchestrator.WithMaxDuration(*maxDuration, orchestrator.DefaultGracePeriod),
+		orchestrator.WithProgressInterval(*progress),
+	}
+	if *checkpoint != "" {
+		opts = append(opts, orchestrator.WithCheckpoint(*checkpoint))
+	}
+	switch *balance {
+	case "rr":
+	case "least":
+		opts = append(opts, orchestrator.WithScheduler(orchestrator.NewLeastLoadedScheduler()))
+	case "latency":
+		opts = append(opts, orchestrator.WithAdaptiveRouting(0))
+	default:
+		fmt.Fprintf(os.Stderr, "-balance must be rr, least, or latency, got %q\n", *balance)
+		os.Exit(2)
+	}
+	var agentOpts []orchestrator.AgentOption
+	if *stageTimeouts != "" {
+		timeouts, err := orchestrator.ParseStageTimeouts(*stageTimeouts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-stage-timeouts: %v\n", err)
+			os.Exit(2)
+		}
+		agentOpts = append(agentOpts, orchestrator.WithStageTimeouts(timeouts))
+	}
+	var tlsConfig *tls.Config
+	if *tlsCA != "" || *tlsCert != "" || *tlsKey != "" {
+		if *fleetFile != "" {
+			fmt.Fprintln(os.Stderr, "-tls-* cannot be combined with a fleet file; set tls in it")
+			os.Exit(2)
+		}
+		if tlsConfig, err = orchestrator.LoadClientTLSConfig(*tlsCert, *tlsKey, *tlsCA); err != nil {
+			fmt.Fprintf(os.Stderr, "-tls-*: %v\n", err)
+			os.Exit(2)
+		}
+		agentOpts = append(agentOpts, orchestrator.WithTLSConfig(tlsConfig))
+	}
+	agents, fleetOpts, err := buildAgents(*numAgents, *agentURLs, *fleetFile, *selector, *useGRPC, tlsConfig, agentOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var otlp *orchestrator.OTLPExporter
+	switch *traceDest {
+	case "":
+	case "stderr":
+		opts = append(opts, orchestrator.WithTracing(orchestrator.NewTracer(orchestrator.NewWriterExporter(os.Stderr))))
+	default:
+		if otlp, err = orchestrator.NewOTLPExporter(*traceDest, "fifth-orchestrator"); err != nil {
+			fmt.Fprintf(os.Stderr, "-trace: %v\n", err)
+			os.Exit(2)
+		}
+		opts = append(opts, orchestrator.WithTracing(orchestrator.NewTracer(otlp)))
+	}
+	if text, ok := reporter.(orchestrator.TextReporter); ok {
+		text.Agents = len(agents) // Sizes the performance comparison
+		reporter = text
+	}
+	coordinator := orchestrator.NewCoordinatorWithAgents(agents, append(fleetOpts, opts...)...)
+
+	// Ctrl-C aborts warmup, or stops the run early keeping partial results
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Warm agents before timing starts
+	if err := coordinator.Warmup(ctx); err != nil {
+		logger.Warn("warmup", "error", err)
+	}
+	if *healthEvery > 0 {
+		go coordinator.MonitorHealth(ctx, *healthEvery)
+	}
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", coordinator.MetricsHandler())
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				logger.Error("metrics server", "error", err)
+			}
+		}()
+	}
+
+	start := time.Now()
+	run := coordinator.Run
+	if *checkpoint != "" {
+		run = coordinator.Resume
+	}
+	results, err := run(ctx, specs)
+	if err != nil && results == nil {
+		fmt.Fprintf(os.Stderr, "Run failed: %v\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		logger.Warn("run interrupted", "error", err)
+	}
+
+	if err := reporter.Report(orchestrator.ComputeStats(results, time.Since(start)), results); err != nil {
+		fmt.Fprintf(os.Stderr, "report: %v\n", err)
+		os.Exit(1)
+	}
+	if otlp != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := otlp.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("trace export", "error", err)
+		}
+	}
+}
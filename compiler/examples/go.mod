@@ -0,0 +1,3 @@
+module github.com/quivent/fifth/compiler/examples
+
+go 1.24
@@ -1,315 +0,0 @@
-// Fast Forth Multi-Agent Orchestrator (Go)
-//
-// Binary size: 1-2 MB (vs Python's 20 MB)
-// Compilation: 200-800ms (vs Rust's 30-180s)
-// Dependencies: None (static binary)
-//
-// This is the "pragmatic compromise" - not pure Forth,
-// but 10-20x lighter than Python and proven concurrency.
-
-package main
-
-import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"sync"
-	"time"
-)
-
-// Specification for Fast Forth agent
-type Specification struct {
-	ID          string     `json:"id"`
-	Word        string     `json:"word"`
-	StackEffect string     `json:"stack_effect"`
-	PatternID   string     `json:"pattern_id"`
-	TestCases   []TestCase `json:"test_cases"`
-}
-
-// Test case for validation
-type TestCase struct {
-	Input  []int `json:"input"`
-	Output []int `json:"output"`
-}
-
-// Result from Fast Forth agent
-type Result struct {
-	SpecID    string  `json:"spec_id"`
-	Success   bool    `json:"success"`
-	Code      string  `json:"code,omitempty"`
-	Tests     []string `json:"tests,omitempty"`
-	Error     string  `json:"error,omitempty"`
-	LatencyMS float64 `json:"latency_ms"`
-}
-
-// FastForthAgent represents a single Fast Forth server
-type FastForthAgent struct {
-	URL    string
-	client *http.Client
-}
-
-// NewFastForthAgent creates agent with HTTP client
-func NewFastForthAgent(port int) *FastForthAgent {
-	return &FastForthAgent{
-		URL: fmt.Sprintf("http://localhost:%d", port),
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	}
-}
-
-// ValidateSpec validates a specification (<1ms)
-func (a *FastForthAgent) ValidateSpec(spec Specification) (bool, error) {
-	body, err := json.Marshal(spec)
-	if err != nil {
-		return false, err
-	}
-
-	resp, err := a.client.Post(
-		a.URL+"/spec/validate",
-		"application/json",
-		bytes.NewBuffer(body),
-	)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		Valid     bool    `json:"valid"`
-		LatencyMS float64 `json:"latency_ms"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false, err
-	}
-
-	return result.Valid, nil
-}
-
-// GenerateCode generates code from spec (10-50ms)
-func (a *FastForthAgent) GenerateCode(spec Specification) (string, []string, error) {
-	body, err := json.Marshal(spec)
-	if err != nil {
-		return "", nil, err
-	}
-
-	resp, err := a.client.Post(
-		a.URL+"/generate",
-		"application/json",
-		bytes.NewBuffer(body),
-	)
-	if err != nil {
-		return "", nil, err
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		Code  string   `json:"code"`
-		Tests []string `json:"tests"`
-		Error string   `json:"error,omitempty"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", nil, err
-	}
-
-	if result.Error != "" {
-		return "", nil, fmt.Errorf(result.Error)
-	}
-
-	return result.Code, result.Tests, nil
-}
-
-// VerifyStackEffect verifies stack effects (<1ms)
-func (a *FastForthAgent) VerifyStackEffect(code, effect string) (bool, error) {
-	body, err := json.Marshal(map[string]string{
-		"code":   code,
-		"effect": effect,
-	})
-	if err != nil {
-		return false, err
-	}
-
-	resp, err := a.client.Post(
-		a.URL+"/verify",
-		"application/json",
-		bytes.NewBuffer(body),
-	)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		Valid bool `json:"valid"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false, err
-	}
-
-	return result.Valid, nil
-}
-
-// ProcessSpec runs full workflow (5-10 seconds)
-func (a *FastForthAgent) ProcessSpec(spec Specification) Result {
-	start := time.Now()
-
-	// 1. Validate spec (<1ms)
-	valid, err := a.ValidateSpec(spec)
-	if err != nil || !valid {
-		return Result{
-			SpecID:    spec.ID,
-			Success:   false,
-			Error:     "Invalid specification",
-			LatencyMS: time.Since(start).Seconds() * 1000,
-		}
-	}
-
-	// 2. Generate code (10-50ms)
-	code, tests, err := a.GenerateCode(spec)
-	if err != nil {
-		return Result{
-			SpecID:    spec.ID,
-			Success:   false,
-			Error:     err.Error(),
-			LatencyMS: time.Since(start).Seconds() * 1000,
-		}
-	}
-
-	// 3. Verify stack effects (<1ms)
-	verified, err := a.VerifyStackEffect(code, spec.StackEffect)
-	if err != nil || !verified {
-		return Result{
-			SpecID:    spec.ID,
-			Success:   false,
-			Error:     "Stack effect mismatch",
-			LatencyMS: time.Since(start).Seconds() * 1000,
-		}
-	}
-
-	return Result{
-		SpecID:    spec.ID,
-		Success:   true,
-		Code:      code,
-		Tests:     tests,
-		LatencyMS: time.Since(start).Seconds() * 1000,
-	}
-}
-
-// Coordinator manages multiple Fast Forth agents
-type Coordinator struct {
-	agents []*FastForthAgent
-}
-
-// NewCoordinator creates coordinator with N agents
-func NewCoordinator(numAgents int) *Coordinator {
-	agents := make([]*FastForthAgent, numAgents)
-	for i := 0; i < numAgents; i++ {
-		agents[i] = NewFastForthAgent(8080 + i)
-	}
-	return &Coordinator{agents: agents}
-}
-
-// Run processes specs in parallel across all agents
-func (c *Coordinator) Run(specs []Specification) []Result {
-	fmt.Printf("\nProcessing %d specs with %d agents\n", len(specs), len(c.agents))
-	start := time.Now()
-
-	// Result channel (buffered)
-	results := make(chan Result, len(specs))
-
-	// WaitGroup for synchronization
-	var wg sync.WaitGroup
-
-	// Process specs with goroutines (distribute across agents)
-	for i, spec := range specs {
-		wg.Add(1)
-		go func(spec Specification, agent *FastForthAgent) {
-			defer wg.Done()
-			results <- agent.ProcessSpec(spec)
-		}(spec, c.agents[i%len(c.agents)])
-	}
-
-	// Wait for all goroutines to complete
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	// Collect results
-	var allResults []Result
-	completed := 0
-	for result := range results {
-		allResults = append(allResults, result)
-		completed++
-
-		// Progress update every 10 specs
-		if completed%10 == 0 {
-			fmt.Printf("Progress: %d/%d completed\n", completed, len(specs))
-		}
-	}
-
-	elapsed := time.Since(start)
-	fmt.Printf("\nCompleted in %.2f seconds\n", elapsed.Seconds())
-	fmt.Printf("Average: %.3f seconds per spec\n", elapsed.Seconds()/float64(len(specs)))
-	fmt.Printf("Throughput: %.2f specs/second\n", float64(len(specs))/elapsed.Seconds())
-
-	return allResults
-}
-
-// PrintSummary prints results summary
-func PrintSummary(results []Result) {
-	successful := 0
-	totalLatency := 0.0
-
-	for _, r := range results {
-		if r.Success {
-			successful++
-			totalLatency += r.LatencyMS
-		}
-	}
-
-	failed := len(results) - successful
-	avgLatency := totalLatency / float64(successful)
-
-	fmt.Printf("\n=== Results ===\n")
-	fmt.Printf("Successful: %d\n", successful)
-	fmt.Printf("Failed: %d\n", failed)
-	fmt.Printf("Success rate: %.1f%%\n", float64(successful)/float64(len(results))*100)
-	fmt.Printf("\nAverage latency per spec: %.2fms\n", avgLatency)
-
-	// Performance comparison
-	fmt.Printf("\n=== Performance Comparison ===\n")
-	fmt.Printf("Single-agent time: %.0f seconds (100 specs × 10s)\n", float64(len(results))*10)
-	fmt.Printf("Multi-agent time: ~%.0f seconds (with 10 agents)\n", float64(len(results))*10/10)
-	fmt.Printf("Speedup: ~10x from parallelism\n")
-	fmt.Printf("\nEach agent: 20-100x faster than traditional languages\n")
-	fmt.Printf("Total speedup: 200-1000x faster than traditional workflow\n")
-}
-
-func main() {
-	// Create example specs (100 functions)
-	specs := make([]Specification, 100)
-	for i := 0; i < 100; i++ {
-		specs[i] = Specification{
-			ID:          fmt.Sprintf("func_%d", i),
-			Word:        fmt.Sprintf("function_%d", i),
-			StackEffect: "( n -- n² )",
-			PatternID:   "DUP_TRANSFORM_001",
-			TestCases: []TestCase{
-				{Input: []int{5}, Output: []int{25}},
-				{Input: []int{0}, Output: []int{0}},
-			},
-		}
-	}
-
-	// Create coordinator with 10 agents
-	coordinator := NewCoordinator(10)
-
-	// Process all specs
-	results := coordinator.Run(specs)
-
-	// Print summary
-	PrintSummary(results)
-}
@@ -0,0 +1,1265 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Decoder reads a single JSON value from a response body
+type Decoder interface {
+	Decode(v any) error
+}
+
+// Codec encodes request bodies and decodes agent responses.
+// Swap in a faster implementation (jsoniter, sonic) for high-volume runs.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	NewDecoder(r io.Reader) Decoder
+}
+
+// jsonCodec is the default Codec backed by encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) NewDecoder(r io.Reader) Decoder { return json.NewDecoder(r) }
+
+// DefaultCodec uses encoding/json
+var DefaultCodec Codec = jsonCodec{}
+
+// FastForthAgent represents a single Fast Forth server
+type FastForthAgent struct {
+	URL      string
+	Weight   int               // Relative share of specs routed here (default 1)
+	Labels   map[string]string // Free-form tags from the fleet file; read-only
+	client   *http.Client
+	codec    Codec
+	pipeline Pipeline
+	cache    Cache
+	retry    RetryPolicy
+	budget   *RetryBudget // Shared across the coordinator; nil is unlimited
+	async    *PollOptions // Generate via submit and poll when set
+	rng      *lockedRand  // Retry jitter; nil uses the global source
+	tls      *tls.Config  // Client TLS for https:// agents; nil uses system defaults
+	impl     Agent        // Does the work instead of HTTP when set (see NewAgent)
+
+	maxResponse int64 // Decoded response byte cap; 0 means unlimited
+	debugBodies int   // Body bytes attached to errors; 0 disables
+
+	getValidate bool          // Validate with an idempotent GET
+	streamGen   bool          // Generate via /generate/stream
+	slots       chan struct{} // In-flight cap; nil means unlimited
+	flights     *FlightGroup
+
+	localFirst    bool          // Verify locally, calling /verify only when that can't decide
+	localFallback bool          // Verify locally when /verify fails
+	verifyTimeout time.Duration // Deadline for /verify before falling back
+
+	stageTimeouts map[string]time.Duration // Per-stage deadlines by stage name
+
+	emaMu sync.Mutex
+	ema   float64 // Moving average of spec latency (ms), failures penalized
+	emaN  int     // Samples folded into ema
+
+	retries atomic.Int64 // Requests re-sent under the RetryPolicy
+
+	tracer *Tracer // Records pipeline spans; nil disables tracing
+}
+
+// AgentOption configures a FastForthAgent
+type AgentOption func(*FastForthAgent)
+
+// WithCodec sets the codec used for request and response bodies
+func WithCodec(codec Codec) AgentOption {
+	return func(a *FastForthAgent) {
+		if codec != nil {
+			a.codec = codec
+		}
+	}
+}
+
+// WithCache reuses generated code for specs whose content already succeeded.
+// Agents may share one Cache.
+func WithCache(c Cache) AgentOption {
+	return func(a *FastForthAgent) {
+		a.cache = c
+	}
+}
+
+// WithDedup makes concurrent identical specs share one generate call.
+// Pass the same group to every agent to deduplicate across the pool.
+func WithDedup(g *FlightGroup) AgentOption {
+	return func(a *FastForthAgent) {
+		a.flights = g
+	}
+}
+
+// WithLocalVerifyFallback makes /verify best-effort: if it errors or
+// exceeds timeout (0 keeps the client timeout), the stack effect is
+// checked with VerifyStackEffectLocal instead of failing the spec
+func WithLocalVerifyFallback(timeout time.Duration) AgentOption {
+	return func(a *FastForthAgent) {
+		a.localFallback = true
+		a.verifyTimeout = timeout
+	}
+}
+
+// WithLocalVerify checks stack effects with verify.StackEffect and only
+// calls /verify for code the local checker cannot decide, such as words
+// it has no effect for. Mismatches carry the checker's diagnostics.
+func WithLocalVerify() AgentOption {
+	return func(a *FastForthAgent) {
+		a.localFirst = true
+	}
+}
+
+// WithStageTimeouts bounds each named pipeline stage separately, e.g.
+// validate 100ms, generate 60s, verify 100ms. Requests made inside a
+// bounded stage drop the client timeout (WithTimeout) in favour of the
+// stage's deadline; other stages and calls keep it. A stage that runs
+// out fails the spec with TimedOut and FailTimeout.
+func WithStageTimeouts(timeouts map[string]time.Duration) AgentOption {
+	return func(a *FastForthAgent) {
+		a.stageTimeouts = maps.Clone(timeouts)
+	}
+}
+
+// ParseStageTimeouts reads "validate=100ms,generate=60s,verify=100ms".
+// Stage names must be those of TestedPipeline.
+func ParseStageTimeouts(s string) (map[string]time.Duration, error) {
+	values := make(map[string]string)
+	for part := range strings.SplitSeq(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		stage, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("stage timeout %q: want stage=duration", part)
+		}
+		values[strings.TrimSpace(stage)] = strings.TrimSpace(value)
+	}
+	return parseStageTimeouts(values)
+}
+
+// parseStageTimeouts converts stage=duration pairs, rejecting stages
+// no built-in pipeline has
+func parseStageTimeouts(values map[string]string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(values))
+	for _, stage := range slices.Sorted(maps.Keys(values)) {
+		if !slices.ContainsFunc(TestedPipeline, func(s PipelineStep) bool { return s.Name == stage }) {
+			return nil, fmt.Errorf("stage timeout %s: unknown stage (want validate, generate, verify or test)", stage)
+		}
+		d, err := time.ParseDuration(values[stage])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("stage timeout %s=%q: want a positive duration", stage, values[stage])
+		}
+		timeouts[stage] = d
+	}
+	return timeouts, nil
+}
+
+// stageTimeoutKey marks a context bounded by a stage timeout
+type stageTimeoutKey struct{}
+
+// stageContext applies stage's timeout, if it has one
+func (a *FastForthAgent) stageContext(ctx context.Context, stage string) (context.Context, context.CancelFunc) {
+	d := a.stageTimeouts[stage]
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	ctx = context.WithValue(ctx, stageTimeoutKey{}, d)
+	return context.WithTimeoutCause(ctx, d, ErrStageTimeout)
+}
+
+// httpClient is the client for a request: within a stage timeout, the
+// stage deadline replaces the client timeout
+func (a *FastForthAgent) httpClient(ctx context.Context) *http.Client {
+	if ctx.Value(stageTimeoutKey{}) == nil {
+		return a.client
+	}
+	c := *a.client
+	c.Timeout = 0
+	return &c
+}
+
+// WithTracer records a span per spec and per stage with t and
+// propagates the trace to the agent (see Tracer)
+func WithTracer(t *Tracer) AgentOption {
+	return func(a *FastForthAgent) {
+		a.tracer = t
+	}
+}
+
+// WithMaxInFlight caps concurrent specs on this agent; ProcessSpec blocks
+// until a slot frees. Protects fragile agents regardless of global concurrency.
+func WithMaxInFlight(n int) AgentOption {
+	return func(a *FastForthAgent) {
+		if n > 0 {
+			a.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// TransportOptions tunes connection pooling for an agent's HTTP client
+type TransportOptions struct {
+	MaxIdleConns        int           // Idle connections across all hosts
+	MaxIdleConnsPerHost int           // Idle connections kept per agent
+	MaxConnsPerHost     int           // 0 means unlimited
+	IdleConnTimeout     time.Duration // How long an idle connection is kept
+	KeepAlive           time.Duration // TCP keep-alive period
+	DisableKeepAlives   bool          // Open a new connection per request
+
+	// HTTP2 negotiates HTTP/2 via ALPN on https:// agents, multiplexing
+	// concurrent requests over one connection instead of one per request.
+	// UnencryptedHTTP2 speaks HTTP/2 with prior knowledge (h2c) to
+	// http:// agents; the agent must support it or every request fails.
+	// Both default off: HTTP/1.1 works with every agent.
+	HTTP2            bool
+	UnencryptedHTTP2 bool
+}
+
+// DefaultTransportOptions sized for hundreds of workers per agent.
+// net/http's default of 2 idle conns per host forces constant
+// reconnects once concurrency exceeds that.
+var DefaultTransportOptions = TransportOptions{
+	MaxIdleConns:        1024,
+	MaxIdleConnsPerHost: 256,
+	IdleConnTimeout:     90 * time.Second,
+	KeepAlive:           30 * time.Second,
+}
+
+// newTransport builds an HTTP transport from tuning options
+func newTransport(o TransportOptions) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: o.KeepAlive,
+	}
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        o.MaxIdleConns,
+		MaxIdleConnsPerHost: o.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     o.MaxConnsPerHost,
+		IdleConnTimeout:     o.IdleConnTimeout,
+		DisableKeepAlives:   o.DisableKeepAlives,
+	}
+
+	if o.HTTP2 || o.UnencryptedHTTP2 {
+		// Without HTTP1, http:// requests go out as h2c
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP1(!o.UnencryptedHTTP2)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(o.UnencryptedHTTP2)
+	}
+	return t
+}
+
+// WithTransportOptions replaces the agent's connection pool settings
+func WithTransportOptions(o TransportOptions) AgentOption {
+	return func(a *FastForthAgent) {
+		a.client.Transport = newTransport(o)
+	}
+}
+
+// WithTLSConfig sets the TLS client config for https:// agents. For
+// mutual TLS, set Certificates to the client certificate the agents
+// verify (see LoadClientTLSConfig); RootCAs trusts a private CA.
+func WithTLSConfig(cfg *tls.Config) AgentOption {
+	return func(a *FastForthAgent) {
+		a.tls = cfg.Clone()
+	}
+}
+
+// WithRootCAs trusts only pool's CAs for agents' server certificates,
+// e.g. a private CA bundle (see LoadCABundle)
+func WithRootCAs(pool *x509.CertPool) AgentOption {
+	return func(a *FastForthAgent) {
+		a.tls = cmp.Or(a.tls, &tls.Config{MinVersion: tls.VersionTLS12})
+		a.tls.RootCAs = pool
+	}
+}
+
+// WithClientCertificate presents cert to agents that require mutual TLS
+func WithClientCertificate(cert tls.Certificate) AgentOption {
+	return func(a *FastForthAgent) {
+		a.tls = cmp.Or(a.tls, &tls.Config{MinVersion: tls.VersionTLS12})
+		a.tls.Certificates = []tls.Certificate{cert}
+	}
+}
+
+// LoadCABundle reads a PEM file of one or more CA certificates
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA file %s: no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// LoadClientTLSConfig builds a client TLS config from PEM files: the
+// client certificate and key presented to agents for mutual TLS and,
+// when caFile is not empty, the CA that signed the agents' server
+// certificates. certFile and keyFile may both be empty to only trust
+// caFile.
+func LoadClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch {
+	case certFile != "" && keyFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	case certFile != "" || keyFile != "":
+		return nil, errors.New("client certificate: need both the certificate and key file")
+	}
+	if caFile != "" {
+		pool, err := LoadCABundle(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// WithIdempotentValidate sends validation as GET /spec/validate?spec=<json>
+// so it is retried as a read (see RetryPolicy.IdempotentAttempts). The
+// agent must accept the GET form.
+func WithIdempotentValidate() AgentOption {
+	return func(a *FastForthAgent) {
+		a.getValidate = true
+	}
+}
+
+// WithTimeout sets the HTTP client timeout for each request
+func WithTimeout(d time.Duration) AgentOption {
+	return func(a *FastForthAgent) {
+		a.client.Timeout = d
+	}
+}
+
+// WithWeight sets the agent's relative share of dispatched specs
+func WithWeight(w int) AgentOption {
+	return func(a *FastForthAgent) {
+		if w > 0 {
+			a.Weight = w
+		}
+	}
+}
+
+// WithLabels tags the agent, e.g. {"zone": "eu"}; see FleetConfig.Select
+func WithLabels(labels map[string]string) AgentOption {
+	return func(a *FastForthAgent) {
+		if len(labels) > 0 {
+			a.Labels = maps.Clone(labels)
+		}
+	}
+}
+
+// NewFastForthAgent creates agent with HTTP client
+func NewFastForthAgent(port int, opts ...AgentOption) *FastForthAgent {
+	return newAgent(fmt.Sprintf("http://localhost:%d", port), opts)
+}
+
+// NewFastForthAgentURL creates agent for an http(s) base URL
+func NewFastForthAgentURL(rawURL string, opts ...AgentOption) (*FastForthAgent, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("agent URL %q: %w", rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("agent URL %q: want http(s)://host[:port]", rawURL)
+	}
+	return newAgent(strings.TrimSuffix(rawURL, "/"), opts), nil
+}
+
+// NewAgent wraps any Agent implementation, such as an InProcessAgent or
+// MockAgent, so it can be configured like an HTTP agent; a Coordinator
+// wraps unwrapped ones itself. name stands in for the URL in logs and
+// Result.Agent. Validate, generate,
+// verify, Ping and RunTest go to impl (Ping and RunTest only if impl has
+// them); HTTP-only calls like ValidateBatch and SubmitSpec will fail.
+func NewAgent(name string, impl Agent, opts ...AgentOption) *FastForthAgent {
+	a := newAgent(name, opts)
+	a.impl = impl
+	return a
+}
+
+// newAgent applies options over the default client settings
+func newAgent(baseURL string, opts []AgentOption) *FastForthAgent {
+	a := &FastForthAgent{
+		URL:    baseURL,
+		Weight: 1,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: newTransport(DefaultTransportOptions),
+		},
+		codec:    DefaultCodec,
+		pipeline: DefaultPipeline,
+		retry:    RetryPolicy{MaxAttempts: 1, ShouldRetry: DefaultShouldRetry},
+
+		maxResponse: DefaultMaxResponseSize,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	// Applied last so WithTransportOptions can't drop it
+	if t, ok := a.client.Transport.(*http.Transport); ok && a.tls != nil {
+		t.TLSClientConfig = a.tls
+	}
+	return a
+}
+
+// Retries counts requests this agent has re-sent under its RetryPolicy
+func (a *FastForthAgent) Retries() int64 {
+	return a.retries.Load()
+}
+
+// maxErrorBody caps how much of a non-2xx response body is captured
+const maxErrorBody = 512
+
+// StatusError reports a non-2xx agent response, e.g. a proxy's HTML
+// error page or a 404 from a misconfigured path
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Body       string // At most maxErrorBody bytes of the response
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s: HTTP %d", e.URL, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: HTTP %d: %s", e.URL, e.StatusCode, e.Body)
+}
+
+// newStatusError captures a bounded body prefix from resp
+func newStatusError(url string, resp *http.Response) *StatusError {
+	var snippet []byte
+	if body, err := decodedBody(resp); err == nil {
+		snippet, _ = io.ReadAll(io.LimitReader(body, maxErrorBody))
+		body.Close()
+	}
+	return &StatusError{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(snippet)),
+	}
+}
+
+// decodedBody unwraps a gzip Content-Encoding. post sends its own
+// Accept-Encoding, which stops the transport from decompressing for us.
+// Closing the result releases the decompressor; the caller still
+// closes resp.Body.
+func decodedBody(resp *http.Response) (io.ReadCloser, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return io.NopCloser(resp.Body), nil
+	}
+	return gzip.NewReader(resp.Body)
+}
+
+// DefaultMaxResponseSize caps a decoded agent response body
+const DefaultMaxResponseSize = 4 << 20
+
+// ErrResponseTooLarge reports an agent response past the agent's size limit
+var ErrResponseTooLarge = errors.New("response too large")
+
+// WithMaxResponseSize caps how many decoded bytes the agent will read
+// from one response; n <= 0 removes the cap
+func WithMaxResponseSize(n int64) AgentOption {
+	return func(a *FastForthAgent) {
+		a.maxResponse = n
+	}
+}
+
+// limitBody applies the agent's response size cap to r. The cap counts
+// decompressed bytes, so gzip bombs are caught too.
+func (a *FastForthAgent) limitBody(r io.Reader) io.Reader {
+	if a.maxResponse <= 0 {
+		return r
+	}
+	return &maxBytesReader{r: r, remaining: a.maxResponse}
+}
+
+// maxBytesReader fails with ErrResponseTooLarge once more than its
+// budget has been read
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	// Read one byte past the budget to tell "exactly full" from "over"
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n, ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// WithDebugBodies attaches up to max bytes of the request and response
+// bodies to every error the agent returns, as a *DebugError. Off by
+// default: bodies can be large or sensitive.
+func WithDebugBodies(max int) AgentOption {
+	return func(a *FastForthAgent) {
+		a.debugBodies = max
+	}
+}
+
+// DebugError wraps a failed agent call with what was sent and received
+type DebugError struct {
+	Err      error
+	Method   string
+	URL      string
+	Request  string // Truncated to the WithDebugBodies limit
+	Response string // Likewise; empty if no response arrived
+}
+
+func (e *DebugError) Error() string {
+	return fmt.Sprintf("%v [%s %s request=%q response=%q]", e.Err, e.Method, e.URL, e.Request, e.Response)
+}
+
+func (e *DebugError) Unwrap() error { return e.Err }
+
+func newDebugError(err error, method, url string, reqBody []byte, captured *cappedBuffer) *DebugError {
+	req := reqBody[:min(len(reqBody), captured.max)]
+	resp := captured.String()
+
+	// newStatusError drains non-2xx bodies itself
+	var se *StatusError
+	if resp == "" && errors.As(err, &se) {
+		resp = se.Body
+	}
+	return &DebugError{Err: err, Method: method, URL: url, Request: string(req), Response: resp}
+}
+
+// cappedBuffer keeps the first max bytes written and discards the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// requestIDKey is the context key for the correlation ID
+type requestIDKey struct{}
+
+// WithRequestID attaches a correlation ID sent as X-Request-ID on every agent call
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the correlation ID carried by ctx, if any
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random 128-bit hex correlation ID
+func NewRequestID() string {
+	var b [16]byte
+	cryptorand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// post sends payload to path and decodes the response into out,
+// retrying per the agent's RetryPolicy
+func (a *FastForthAgent) post(ctx context.Context, path string, payload, out any) error {
+	body, err := a.codec.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return a.do(ctx, http.MethodPost, path, body, out)
+}
+
+// get fetches path and decodes the response into out
+func (a *FastForthAgent) get(ctx context.Context, path string, out any) error {
+	return a.do(ctx, http.MethodGet, path, nil, out)
+}
+
+// do sends one request, retrying per the agent's RetryPolicy; a nil body
+// sends no payload
+func (a *FastForthAgent) do(ctx context.Context, method, path string, body []byte, out any) (err error) {
+	var captured *cappedBuffer // The latest attempt's response
+	if a.debugBodies > 0 {
+		defer func() {
+			if err != nil {
+				err = newDebugError(err, method, a.URL+path, body, captured)
+			}
+		}()
+	}
+
+	idempotent := method == http.MethodGet
+	maxAttempts := a.retry.MaxAttempts
+	if idempotent {
+		maxAttempts = max(maxAttempts, a.retry.IdempotentAttempts)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if a.debugBodies > 0 {
+			captured = &cappedBuffer{max: a.debugBodies}
+		}
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, a.URL+path, reqBody)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Accept-Encoding", "gzip")
+		if id := RequestIDFrom(ctx); id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		setTraceparent(ctx, req)
+
+		resp, err := a.httpClient(ctx).Do(req)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err() // Cancelled, not transient: spend no retry token
+		}
+
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		retryable := a.retry.ShouldRetry(attempt, err, status) || (idempotent && err != nil)
+		if attempt < maxAttempts && retryable && a.budget.Allow() {
+			a.retries.Add(1)
+			wait := a.retry.delay(attempt, a.rng)
+			if err == nil {
+				if status == http.StatusTooManyRequests {
+					if d, ok := a.retry.retryAfter(resp); ok {
+						wait = d
+					}
+				}
+				var sink io.Writer = io.Discard
+				if captured != nil {
+					sink = captured // Kept in case ctx ends the retries here
+				}
+				io.Copy(sink, resp.Body)
+				resp.Body.Close()
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if status < 200 || status > 299 {
+			return newStatusError(a.URL+path, resp)
+		}
+		body, err := decodedBody(resp)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.URL+path, err)
+		}
+		defer body.Close()
+		var r io.Reader = a.limitBody(body)
+		if captured != nil {
+			r = io.TeeReader(r, captured)
+		}
+		err = a.codec.NewDecoder(r).Decode(out)
+		if errors.Is(err, ErrResponseTooLarge) {
+			return fmt.Errorf("%s: %w (limit %d bytes)", a.URL+path, err, a.maxResponse)
+		}
+		return err
+	}
+}
+
+// ValidateSpec validates a specification (<1ms)
+func (a *FastForthAgent) ValidateSpec(ctx context.Context, spec Specification) (bool, error) {
+	if a.impl != nil {
+		return a.impl.ValidateSpec(ctx, spec)
+	}
+	var result struct {
+		Valid     bool    `json:"valid"`
+		LatencyMS float64 `json:"latency_ms"`
+	}
+	var err error
+	if a.getValidate {
+		var encoded []byte
+		if encoded, err = a.codec.Marshal(spec); err != nil {
+			return false, err
+		}
+		query := url.Values{"spec": {string(encoded)}}
+		err = a.get(ctx, "/spec/validate?"+query.Encode(), &result)
+	} else {
+		err = a.post(ctx, "/spec/validate", spec, &result)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return result.Valid, nil
+}
+
+// ShortBatchError reports a batch response with fewer entries than requested
+type ShortBatchError struct {
+	Want, Got int
+}
+
+func (e *ShortBatchError) Error() string {
+	return fmt.Sprintf("batch response has %d results for %d requests", e.Got, e.Want)
+}
+
+// ValidateBatch validates many specs in one /spec/validate/batch call.
+// The returned slice matches specs by position. If the agent answers
+// with fewer results, the missing tail is false and a *ShortBatchError
+// is returned alongside it.
+func (a *FastForthAgent) ValidateBatch(ctx context.Context, specs []Specification) ([]bool, error) {
+	var result struct {
+		Valid []bool `json:"valid"`
+	}
+	if err := a.post(ctx, "/spec/validate/batch", specs, &result); err != nil {
+		return nil, err
+	}
+
+	valid := make([]bool, len(specs))
+	copy(valid, result.Valid)
+	if len(result.Valid) < len(specs) {
+		return valid, &ShortBatchError{Want: len(specs), Got: len(result.Valid)}
+	}
+	return valid, nil
+}
+
+// GenerateCode generates code from spec (10-50ms)
+func (a *FastForthAgent) GenerateCode(ctx context.Context, spec Specification) (string, []string, error) {
+	if a.impl != nil {
+		return a.impl.GenerateCode(ctx, spec)
+	}
+	if a.streamGen {
+		var code strings.Builder
+		err := a.streamGenerate(ctx, spec, func(ev GenerateEvent) bool {
+			if ev.Kind == EventChunk {
+				code.WriteString(ev.Data)
+			}
+			return true
+		})
+		return code.String(), nil, err
+	}
+	if a.async != nil {
+		jobID, err := a.SubmitSpec(ctx, spec)
+		if err != nil {
+			return "", nil, err
+		}
+		return a.PollResult(ctx, jobID)
+	}
+
+	var result struct {
+		Code  string   `json:"code"`
+		Tests []string `json:"tests"`
+		Error string   `json:"error,omitempty"`
+	}
+	if err := a.post(ctx, "/generate", spec, &result); err != nil {
+		return "", nil, err
+	}
+
+	if result.Error != "" {
+		return "", nil, errors.New(result.Error)
+	}
+
+	return result.Code, result.Tests, nil
+}
+
+// WithStreamingGenerate makes generation read /generate/stream instead
+// of /generate. Streamed responses carry code only, no tests.
+func WithStreamingGenerate() AgentOption {
+	return func(a *FastForthAgent) {
+		a.streamGen = true
+	}
+}
+
+// GenerateCodeStream posts spec to /generate/stream and yields code
+// chunks as the agent produces them. The agent may answer with
+// server-sent events (a "data:" line per chunk, "event: error" to fail,
+// "event: done" to finish) or a plain chunked body. Both channels close
+// when the stream ends; the error channel carries at most one error,
+// including ctx.Err() if the caller gave up. Streams are not retried.
+func (a *FastForthAgent) GenerateCodeStream(ctx context.Context, spec Specification) (<-chan string, <-chan error) {
+	chunks := make(chan string)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(chunks)
+		defer close(errc)
+		err := a.streamGenerate(ctx, spec, func(ev GenerateEvent) bool {
+			if ev.Kind != EventChunk {
+				return true
+			}
+			select {
+			case chunks <- ev.Data:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			errc <- err
+		}
+	}()
+
+	return chunks, errc
+}
+
+// Kinds of GenerateEvent
+const (
+	EventChunk  = "chunk"  // Code; chunks concatenate to the definition
+	EventStatus = "status" // Progress note, e.g. "searching 2-word programs"
+)
+
+// GenerateEvent is one event of a /generate/stream response
+type GenerateEvent struct {
+	Kind string // EventChunk or EventStatus
+	Data string
+}
+
+// GenerateCodeEvents is GenerateCodeStream with the agent's status
+// events ("event: status") interleaved with the code chunks, so a long
+// generation can report what it is doing. Other unknown events are
+// dropped.
+func (a *FastForthAgent) GenerateCodeEvents(ctx context.Context, spec Specification) (<-chan GenerateEvent, <-chan error) {
+	events := make(chan GenerateEvent)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+		defer close(errc)
+		err := a.streamGenerate(ctx, spec, func(ev GenerateEvent) bool {
+			select {
+			case events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			errc <- err
+		}
+	}()
+
+	return events, errc
+}
+
+// streamGenerate feeds each streamed event to emit until the stream ends
+// or emit returns false
+func (a *FastForthAgent) streamGenerate(ctx context.Context, spec Specification, emit func(GenerateEvent) bool) error {
+	body, err := a.codec.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL+"/generate/stream", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+	if id := RequestIDFrom(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	setTraceparent(ctx, req)
+
+	resp, err := a.httpClient(ctx).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newStatusError(a.URL+"/generate/stream", resp)
+	}
+	decoded, err := decodedBody(resp)
+	if err != nil {
+		return err
+	}
+	defer decoded.Close()
+	r := a.limitBody(decoded)
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 && !emit(GenerateEvent{EventChunk, string(buf[:n])}) {
+				return ctx.Err()
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return cmp.Or(ctx.Err(), err)
+			}
+		}
+	}
+
+	// Server-sent events: data lines accumulate until a blank line.
+	// Unnamed events are code chunks.
+	var event string
+	var data []string
+	dispatch := func() bool {
+		kind := cmp.Or(event, EventChunk)
+		if len(data) == 0 || (kind != EventChunk && kind != EventStatus) {
+			return true
+		}
+		return emit(GenerateEvent{kind, strings.Join(data, "\n")})
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			switch event {
+			case "error":
+				return fmt.Errorf("%s/generate/stream: %s", a.URL, strings.Join(data, "\n"))
+			case "done":
+				return nil
+			}
+			if !dispatch() {
+				return ctx.Err()
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(line[len("event:"):])
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(line[len("data:"):], " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return cmp.Or(ctx.Err(), err)
+	}
+	// Stream ended without a closing blank line
+	switch {
+	case event == "error":
+		return fmt.Errorf("%s/generate/stream: %s", a.URL, strings.Join(data, "\n"))
+	case event != "done" && !dispatch():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// PollOptions controls how PollResult waits on an async generate job
+type PollOptions struct {
+	Interval    time.Duration // First wait between status checks
+	MaxInterval time.Duration // Cap for the growing wait; 0 means uncapped
+	Multiplier  float64       // Growth per poll; values <= 1 keep the wait fixed
+}
+
+// DefaultPollOptions polls after 500ms, backing off to every 10s
+var DefaultPollOptions = PollOptions{
+	Interval:    500 * time.Millisecond,
+	MaxInterval: 10 * time.Second,
+	Multiplier:  1.5,
+}
+
+// WithAsync makes generation submit to /generate/async and poll for the
+// result instead of holding one request open for the whole generate step
+func WithAsync(p PollOptions) AgentOption {
+	return func(a *FastForthAgent) {
+		if p.Interval <= 0 {
+			p.Interval = DefaultPollOptions.Interval
+		}
+		a.async = &p
+	}
+}
+
+// Async job states reported by /generate/status/{id}
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+	JobExpired = "expired"
+)
+
+// JobError reports an async job that ended in failed or expired
+type JobError struct {
+	JobID  string
+	Status string
+	Reason string // The agent's error message, if any
+}
+
+func (e *JobError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("job %s %s", e.JobID, e.Status)
+	}
+	return fmt.Sprintf("job %s %s: %s", e.JobID, e.Status, e.Reason)
+}
+
+// SubmitSpec starts an async generate job and returns its ID
+func (a *FastForthAgent) SubmitSpec(ctx context.Context, spec Specification) (string, error) {
+	var result struct {
+		JobID string `json:"job_id"`
+	}
+	if err := a.post(ctx, "/generate/async", spec, &result); err != nil {
+		return "", err
+	}
+	if result.JobID == "" {
+		return "", fmt.Errorf("%s/generate/async: response has no job_id", a.URL)
+	}
+	return result.JobID, nil
+}
+
+// PollResult checks /generate/status/{id} until the job is done and
+// returns its code and tests. A failed or expired job is a *JobError;
+// cancelling ctx stops polling and returns ctx.Err().
+func (a *FastForthAgent) PollResult(ctx context.Context, jobID string) (string, []string, error) {
+	p := DefaultPollOptions
+	if a.async != nil {
+		p = *a.async
+	}
+
+	wait := p.Interval
+	for {
+		var status struct {
+			Status string   `json:"status"`
+			Code   string   `json:"code"`
+			Tests  []string `json:"tests"`
+			Error  string   `json:"error,omitempty"`
+		}
+		if err := a.get(ctx, "/generate/status/"+url.PathEscape(jobID), &status); err != nil {
+			return "", nil, err
+		}
+
+		switch status.Status {
+		case JobDone:
+			return status.Code, status.Tests, nil
+		case JobFailed, JobExpired:
+			return "", nil, &JobError{JobID: jobID, Status: status.Status, Reason: status.Error}
+		case JobPending, JobRunning:
+		default:
+			return "", nil, fmt.Errorf("job %s: unknown status %q", jobID, status.Status)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return "", nil, ctx.Err()
+		}
+		if p.Multiplier > 1 {
+			wait = time.Duration(float64(wait) * p.Multiplier)
+			if p.MaxInterval > 0 {
+				wait = min(wait, p.MaxInterval)
+			}
+		}
+	}
+}
+
+// VerifyStackEffect verifies stack effects (<1ms)
+func (a *FastForthAgent) VerifyStackEffect(ctx context.Context, code, effect string) (bool, error) {
+	if a.impl != nil {
+		return a.impl.VerifyStackEffect(ctx, code, effect)
+	}
+	payload := map[string]string{
+		"code":   code,
+		"effect": effect,
+	}
+
+	var result struct {
+		Valid bool `json:"valid"`
+	}
+	if err := a.post(ctx, "/verify", payload, &result); err != nil {
+		return false, err
+	}
+
+	return result.Valid, nil
+}
+
+// CodeEffectPair is one snippet to check against a stack effect
+type CodeEffectPair struct {
+	Code   string `json:"code"`
+	Effect string `json:"effect"`
+}
+
+// VerifyStackEffectBatch verifies many snippets in one /verify/batch
+// call. Results match pairs by position; a short response behaves as in
+// ValidateBatch.
+func (a *FastForthAgent) VerifyStackEffectBatch(ctx context.Context, pairs []CodeEffectPair) ([]bool, error) {
+	var result struct {
+		Valid []bool `json:"valid"`
+	}
+	if err := a.post(ctx, "/verify/batch", pairs, &result); err != nil {
+		return nil, err
+	}
+
+	valid := make([]bool, len(pairs))
+	copy(valid, result.Valid)
+	if len(result.Valid) < len(pairs) {
+		return valid, &ShortBatchError{Want: len(pairs), Got: len(result.Valid)}
+	}
+	return valid, nil
+}
+
+// RunTest executes code on the agent's /run endpoint with the test
+// case's input on the stack and returns the resulting stack
+func (a *FastForthAgent) RunTest(ctx context.Context, code string, tc TestCase) ([]int, error) {
+	if a.impl != nil {
+		if r, ok := a.impl.(interface {
+			RunTest(context.Context, string, TestCase) ([]int, error)
+		}); ok {
+			return r.RunTest(ctx, code, tc)
+		}
+		return nil, fmt.Errorf("agent %s cannot run tests", a.URL)
+	}
+	payload := map[string]any{
+		"code":  code,
+		"input": tc.Input,
+	}
+
+	var result struct {
+		Output []int  `json:"output"`
+		Error  string `json:"error,omitempty"`
+	}
+	if err := a.post(ctx, "/run", payload, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+
+	return result.Output, nil
+}
+
+// Ping sends a trivial validate request and expects HTTP 200
+func (a *FastForthAgent) Ping(ctx context.Context) error {
+	if a.impl != nil {
+		if p, ok := a.impl.(interface{ Ping(context.Context) error }); ok {
+			return p.Ping(ctx)
+		}
+		return nil
+	}
+	body, err := a.codec.Marshal(Specification{
+		ID:          "warmup",
+		Word:        "warmup",
+		StackEffect: "( -- )",
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL+"/spec/validate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(req.URL.String(), resp)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Health probes GET /health and expects a 2xx. Agents that predate the
+// endpoint (404 or 405) are probed with Ping instead.
+func (a *FastForthAgent) Health(ctx context.Context) error {
+	if a.impl != nil {
+		return a.Ping(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed:
+		io.Copy(io.Discard, resp.Body)
+		return a.Ping(ctx)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return newStatusError(req.URL.String(), resp)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// ProtocolVersion is the agent protocol this orchestrator speaks. Bump it
+// when agents must understand new request fields or endpoints.
+const ProtocolVersion = 1
+
+// AgentVersion is an agent's /version response
+type AgentVersion struct {
+	Version      string   `json:"version"`      // Agent build, informational
+	Protocol     int      `json:"protocol"`     // Compared against the minimum
+	Capabilities []string `json:"capabilities"` // Optional endpoints, e.g. "generate/async"
+}
+
+// Version asks the agent for its build and protocol version
+func (a *FastForthAgent) Version(ctx context.Context) (AgentVersion, error) {
+	var v AgentVersion
+	err := a.get(ctx, "/version", &v)
+	return v, err
+}
+
+// Agent does the work behind each pipeline stage, and ProcessSpec runs
+// all of them in one call. *FastForthAgent implements it over HTTP,
+// *GRPCAgent over gRPC, and InProcessAgent and MockAgent without a
+// server. A Coordinator accepts any implementation; it wraps those that
+// are not a *FastForthAgent with NewAgent and runs its own pipeline
+// (stage timeouts, observers, caching) over the three stage calls.
+type Agent interface {
+	ValidateSpec(ctx context.Context, spec Specification) (bool, error)
+	GenerateCode(ctx context.Context, spec Specification) (string, []string, error)
+	VerifyStackEffect(ctx context.Context, code, effect string) (bool, error)
+	ProcessSpec(ctx context.Context, spec Specification) Result
+}
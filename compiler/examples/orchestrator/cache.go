@@ -0,0 +1,157 @@
+package orchestrator
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// CacheEntry is the generated output stored for a spec hash
+type CacheEntry struct {
+	Code  string   `json:"code"`
+	Tests []string `json:"tests,omitempty"`
+}
+
+// Cache stores generated code keyed by SpecHash.
+// Implementations must be safe for concurrent use. Keys are content
+// hashes, so entries never go stale and need no invalidation.
+type Cache interface {
+	Get(key string) (CacheEntry, bool)
+	Put(key string, entry CacheEntry)
+}
+
+// SpecHash returns a hex SHA-256 over the fields that determine
+// generated code: word, stack effect, pattern, test cases, and the
+// definitions it may call
+func SpecHash(spec Specification) string {
+	content, _ := json.Marshal(struct {
+		Word        string     `json:"word"`
+		StackEffect string     `json:"stack_effect"`
+		PatternID   string     `json:"pattern_id"`
+		TestCases   []TestCase `json:"test_cases"`
+		Definitions []string   `json:"definitions,omitempty"`
+	}{spec.Word, spec.StackEffect, spec.PatternID, spec.TestCases, spec.Definitions})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryCache is an in-process LRU Cache
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Front is most recently used
+	items    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry CacheEntry
+}
+
+// NewMemoryCache creates an LRU holding at most capacity entries
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the entry for key and marks it recently used
+func (c *MemoryCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*memoryCacheItem).entry, true
+}
+
+// Put stores entry, evicting the least recently used when full
+func (c *MemoryCache) Put(key string, entry CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*memoryCacheItem).entry = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&memoryCacheItem{key: key, entry: entry})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+// flightCall is one in-progress generate shared by identical specs
+type flightCall struct {
+	done    chan struct{}
+	entry   CacheEntry
+	err     error
+	waiters int                // Callers still waiting; guarded by FlightGroup.mu
+	cancel  context.CancelFunc // Cancels fn once every waiter has left
+}
+
+// FlightGroup collapses concurrent calls with the same key into one,
+// like golang.org/x/sync/singleflight without the dependency
+type FlightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// NewFlightGroup creates an empty group
+func NewFlightGroup() *FlightGroup {
+	return &FlightGroup{calls: make(map[string]*flightCall)}
+}
+
+// Do runs fn once per key among concurrent callers; shared reports
+// whether this caller joined a call another caller started. Each caller
+// waits only as long as its own ctx allows. fn gets a context of its
+// own, free of any caller's values or deadline, which is cancelled
+// once every caller has given up.
+func (g *FlightGroup) Do(ctx context.Context, key string, fn func(context.Context) (CacheEntry, error)) (entry CacheEntry, shared bool, err error) {
+	g.mu.Lock()
+	call, shared := g.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.Background())
+		call = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			entry, err := fn(callCtx)
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.entry, call.err = entry, err
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.entry, shared, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		if call.waiters--; call.waiters == 0 {
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key) // Later callers start afresh
+			}
+		}
+		g.mu.Unlock()
+		return CacheEntry{}, shared, ctx.Err()
+	}
+}
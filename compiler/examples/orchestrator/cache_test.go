@@ -0,0 +1,133 @@
+package orchestrator_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+	"github.com/quivent/fifth/compiler/examples/server"
+)
+
+// flight starts g.Do in the background and returns its outcome later
+func flight(ctx context.Context, g *orchestrator.FlightGroup, fn func(context.Context) (orchestrator.CacheEntry, error)) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := g.Do(ctx, "key", fn)
+		done <- err
+	}()
+	return done
+}
+
+func TestFlightGroup(t *testing.T) {
+	g := orchestrator.NewFlightGroup()
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int64
+	var callErr error
+	fn := func(ctx context.Context) (orchestrator.CacheEntry, error) {
+		calls.Add(1)
+		close(started)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			callErr = ctx.Err()
+			return orchestrator.CacheEntry{}, ctx.Err()
+		}
+		return orchestrator.CacheEntry{Code: "dup *"}, nil
+	}
+
+	// The leader and one sharer give up; the other sharer still gets the code
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := flight(leaderCtx, g, fn)
+	<-started
+	quitterCtx, cancelQuitter := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelQuitter()
+	quitter := flight(quitterCtx, g, fn)
+	type outcome struct {
+		entry  orchestrator.CacheEntry
+		shared bool
+		err    error
+	}
+	sharer := make(chan outcome, 1)
+	go func() {
+		entry, shared, err := g.Do(context.Background(), "key", fn)
+		sharer <- outcome{entry, shared, err}
+	}()
+
+	if err := <-quitter; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sharer past its deadline = %v, want context.DeadlineExceeded", err)
+	}
+	cancelLeader()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled leader = %v, want context.Canceled", err)
+	}
+	close(release)
+	if o := <-sharer; o.err != nil || !o.shared || o.entry.Code != "dup *" {
+		t.Errorf("sharer = %+v, want the shared code", o)
+	}
+	if n := calls.Load(); n != 1 || callErr != nil {
+		t.Errorf("fn ran %d times, ending with %v; want once, unaffected by the leaver", n, callErr)
+	}
+
+	// Once every caller has left, fn's context is cancelled and the next
+	// caller starts a new call
+	started, release = make(chan struct{}), make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := flight(ctx, g, fn)
+	<-started
+	cancel()
+	<-abandoned
+	started = make(chan struct{})
+	next := flight(context.Background(), g, fn)
+	<-started
+	close(release)
+	if err := <-next; err != nil {
+		t.Errorf("caller after an abandoned call = %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("fn ran %d times, want 3", n)
+	}
+}
+
+// TestDedupLeaderTimeout runs two identical specs on one agent, the
+// first with a Timeout shorter than generation takes, and checks that
+// its timeout does not fail the second spec sharing its call
+func TestDedupLeaderTimeout(t *testing.T) {
+	agentServer := server.New()
+	var mu sync.Mutex
+	var requestIDs []string
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/generate" {
+			mu.Lock()
+			requestIDs = append(requestIDs, r.Header.Get("X-Request-ID"))
+			mu.Unlock()
+			time.Sleep(150 * time.Millisecond)
+		}
+		agentServer.ServeHTTP(w, r)
+	}))
+	agent := newAgent(t, srv.URL, orchestrator.WithDedup(orchestrator.NewFlightGroup()))
+
+	leader, sharer := square, square
+	leader.Timeout = orchestrator.Duration(50 * time.Millisecond)
+	leaderResult := make(chan orchestrator.Result, 1)
+	go func() { leaderResult <- agent.ProcessSpec(context.Background(), leader) }()
+	time.Sleep(20 * time.Millisecond)
+	r := agent.ProcessSpec(context.Background(), sharer)
+
+	lr := <-leaderResult
+	if !lr.TimedOut {
+		t.Errorf("leader: timed out=%v error=%q, want a timeout", lr.TimedOut, lr.Error)
+	}
+	if !r.Success || !r.Shared {
+		t.Errorf("sharer: success=%v shared=%v error=%q, want the shared code", r.Success, r.Shared, r.Error)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requestIDs) != 1 || requestIDs[0] == "" || requestIDs[0] == lr.RequestID || requestIDs[0] == r.RequestID {
+		t.Errorf("generate request IDs = %q, want one of the call's own, not %q or %q", requestIDs, lr.RequestID, r.RequestID)
+	}
+}
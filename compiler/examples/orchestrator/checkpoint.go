@@ -0,0 +1,190 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// WithCheckpoint makes every run append each result to path as an NDJSON
+// line the moment it is collected, so Resume can pick up after a crash.
+// Each line also carries the SpecHash of the spec as submitted.
+func WithCheckpoint(path string) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.checkpointPath = path
+	}
+}
+
+// ErrNoCheckpoint is returned by Resume without WithCheckpoint
+var ErrNoCheckpoint = errors.New("no checkpoint file configured")
+
+// checkpointLine is a result as WithCheckpoint writes it
+type checkpointLine struct {
+	Result
+	SpecHash string `json:"spec_hash,omitempty"`
+}
+
+// specHashesKey carries the SpecHash of each spec passed to Run when
+// they differ from the specs' own: Resume hands Run specs with their
+// met dependencies folded into Definitions, but checkpoints the hashes
+// of the specs it was given
+type specHashesKey struct{}
+
+// LoadCheckpoint reads results written under WithCheckpoint. A missing
+// file yields no results, and lines torn by a crash mid-write are
+// skipped.
+func LoadCheckpoint(path string) ([]Result, error) {
+	lines, err := loadCheckpoint(path)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(lines))
+	for i, line := range lines {
+		results[i] = line.Result
+	}
+	return results, nil
+}
+
+func loadCheckpoint(path string) ([]checkpointLine, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []checkpointLine
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var l checkpointLine
+		if err := json.Unmarshal(line, &l); err != nil {
+			// A Decoder, unlike Unmarshal, says when input stops mid-value
+			var v any
+			if json.NewDecoder(bytes.NewReader(line)).Decode(&v) == io.ErrUnexpectedEOF {
+				continue // Torn
+			}
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// openCheckpoint opens path for appending, first ending a line torn by
+// a crash so the next result starts on its own line
+func openCheckpoint(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			_, err = file.Write([]byte("\n"))
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Resume runs the specs that have no successful result in the
+// checkpoint file and returns them merged with the checkpointed
+// successes, in submission order. New results are appended to the same
+// file. A success counts only while its line's SpecHash matches the spec
+// and every spec it depends on counts too, so edited specs and their
+// dependents run again. Dependencies that count are met, and their code
+// goes first in the dependent's Definitions.
+func (c *Coordinator) Resume(ctx context.Context, specs []Specification) ([]Result, error) {
+	if c.checkpointPath == "" {
+		return nil, ErrNoCheckpoint
+	}
+	prior, err := loadCheckpoint(c.checkpointPath)
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint: %w", err)
+	}
+
+	// 1. Successful results by spec ID; a later line wins
+	done := make(map[string]checkpointLine)
+	for _, line := range prior {
+		if line.Success {
+			done[line.SpecID] = line
+		}
+	}
+	byID := make(map[string]Specification, len(specs))
+	for _, spec := range specs {
+		byID[spec.ID] = spec
+	}
+	current := make(map[string]bool) // Whether done[id] still counts
+	var counts func(id string) bool
+	counts = func(id string) bool {
+		if ok, seen := current[id]; seen {
+			return ok
+		}
+		current[id] = false // A cycle counts for nothing; Run reports it
+		// A dependency not given to Resume has only its line to go on
+		line, ok := done[id]
+		if spec, given := byID[id]; ok && given {
+			ok = line.SpecHash == SpecHash(spec)
+			for _, dep := range spec.DependsOn {
+				ok = ok && counts(dep)
+			}
+		}
+		current[id] = ok
+		return ok
+	}
+
+	// 2. Keep prior successes and strip met dependencies from the rest
+	var (
+		results []Result
+		rest    []Specification
+		pos     []int    // Index in specs of each rest entry
+		hashes  []string // SpecHash of each rest entry as given
+	)
+	for i, spec := range specs {
+		if counts(spec.ID) {
+			r := done[spec.ID].Result
+			r.Index = i
+			results = append(results, r)
+			continue
+		}
+		hashes = append(hashes, SpecHash(spec))
+		defs := slices.Clip(spec.Definitions)
+		var deps []string
+		for _, dep := range spec.DependsOn {
+			if counts(dep) {
+				defs = append(defs, done[dep].Code)
+			} else {
+				deps = append(deps, dep)
+			}
+		}
+		spec.Definitions, spec.DependsOn = defs, deps
+		rest = append(rest, spec)
+		pos = append(pos, i)
+	}
+	c.logger.Info("resuming from checkpoint", "path", c.checkpointPath, "done", len(results), "remaining", len(rest))
+
+	// 3. Run the remainder and renumber it back to submission order
+	more, err := c.Run(context.WithValue(ctx, specHashesKey{}, hashes), rest)
+	if more == nil && err != nil {
+		return nil, err
+	}
+	for _, r := range more {
+		r.Index = pos[r.Index]
+		results = append(results, r)
+	}
+	SortResults(results, BySubmission)
+	return results, err
+}
@@ -0,0 +1,109 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// TestResume crashes a run while it writes one spec's checkpoint line,
+// edits another spec, and resumes: only the lost, edited and dependent
+// specs run again, with met dependencies' code from the checkpoint
+func TestResume(t *testing.T) {
+	var mu sync.Mutex
+	var ran []string
+	definitions := make(map[string][]string)
+	mock := &orchestrator.MockAgent{
+		Generate: func(spec orchestrator.Specification) (string, []string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, spec.ID)
+			definitions[spec.ID] = spec.Definitions
+			return ": " + spec.Word + " " + spec.StackEffect + " ;", nil, nil
+		},
+	}
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	coordinator := func() *orchestrator.Coordinator {
+		return orchestrator.NewCoordinatorWithAgents(
+			[]*orchestrator.FastForthAgent{orchestrator.NewAgent("mock", mock)},
+			orchestrator.WithCheckpoint(checkpoint),
+		)
+	}
+	spec := func(id string, deps ...string) orchestrator.Specification {
+		return orchestrator.Specification{ID: id, Word: id, StackEffect: "( n -- n )", DependsOn: deps}
+	}
+	specs := []orchestrator.Specification{
+		spec("base"),
+		spec("user", "base"),    // Done; kept
+		spec("lost", "base"),    // Its line is torn; reruns with base's checkpointed code
+		spec("edited"),          // Changed below; reruns
+		spec("child", "edited"), // Unchanged, but its dependency reruns
+	}
+	if _, err := coordinator().Run(context.Background(), specs); err != nil {
+		t.Fatal(err)
+	}
+
+	// The crash: lost's line was half written, and nothing came after it
+	data, err := os.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept [][]byte
+	var torn []byte
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var r struct {
+			SpecID   string `json:"spec_id"`
+			SpecHash string `json:"spec_hash"`
+		}
+		if err := json.Unmarshal(line, &r); err != nil || r.SpecHash == "" {
+			t.Fatalf("checkpoint line %s: %v, want a spec_hash", line, err)
+		}
+		if r.SpecID == "lost" {
+			torn = line[:len(line)/2]
+		} else {
+			kept = append(kept, line)
+		}
+	}
+	crashed := append(bytes.Join(kept, []byte("\n")), '\n')
+	if err := os.WriteFile(checkpoint, append(crashed, torn...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ran = nil
+	specs[3].StackEffect = "( n -- n n )"
+	results, err := coordinator().Resume(context.Background(), specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(ran)
+	if want := []string{"child", "edited", "lost"}; !slices.Equal(ran, want) {
+		t.Errorf("resume ran %q, want %q", ran, want)
+	}
+	if defs := definitions["lost"]; len(defs) != 1 || defs[0] != ": base ( n -- n ) ;" {
+		t.Errorf("lost got definitions %q, want base's checkpointed code", defs)
+	}
+	if defs := definitions["child"]; len(defs) != 1 || defs[0] != ": edited ( n -- n n ) ;" {
+		t.Errorf("child got definitions %q, want the edited spec's new code", defs)
+	}
+	if len(results) != len(specs) {
+		t.Fatalf("%d results, want %d", len(results), len(specs))
+	}
+	for i, r := range results {
+		if r.Index != i || r.SpecID != specs[i].ID || !r.Success {
+			t.Errorf("result %d = %+v", i, r)
+		}
+	}
+
+	// The resumed run's lines make a second Resume a no-op
+	ran = nil
+	if _, err := coordinator().Resume(context.Background(), specs); err != nil || len(ran) > 0 {
+		t.Errorf("second resume ran %q, %v; want nothing", ran, err)
+	}
+}
@@ -0,0 +1,440 @@
+package orchestrator
+
+import (
+	"cmp"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AgentConfig describes one agent in a fleet config file
+type AgentConfig struct {
+	URL     string       `json:"url"`
+	Weight  int          `json:"weight,omitempty"`  // Default 1
+	Timeout string       `json:"timeout,omitempty"` // Go duration, e.g. "10s"
+	Retry   *RetryConfig `json:"retry,omitempty"`   // Overrides the fleet default
+
+	// Labels are free-form tags such as zone or hardware, matched by
+	// FleetConfig.Select
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// StageTimeouts maps stage names to Go durations (see
+	// WithStageTimeouts); overrides the fleet default as a whole
+	StageTimeouts map[string]string `json:"stage_timeouts,omitempty"`
+
+	TLS *TLSFileConfig `json:"tls,omitempty"` // Overrides the fleet default
+}
+
+// TLSFileConfig is an agent's client TLS in a fleet file, as PEM paths
+// (see LoadClientTLSConfig); it needs an https:// URL
+type TLSFileConfig struct {
+	CAFile     string `json:"ca_file,omitempty"`     // CA bundle for the agent's certificate; default system roots
+	CertFile   string `json:"cert_file,omitempty"`   // Client certificate for mutual TLS
+	KeyFile    string `json:"key_file,omitempty"`    // Its private key
+	ServerName string `json:"server_name,omitempty"` // Name to verify when it differs from the URL host
+}
+
+// load reads the PEM files into a tls.Config
+func (tc TLSFileConfig) load() (*tls.Config, error) {
+	cfg, err := LoadClientTLSConfig(tc.CertFile, tc.KeyFile, tc.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ServerName = tc.ServerName
+	return cfg, nil
+}
+
+// RetryConfig is an agent's RetryPolicy in a fleet file. Delays use full
+// jitter: each retry waits a random time up to the doubled backoff.
+type RetryConfig struct {
+	MaxAttempts int    `json:"max_attempts"`          // Total tries; 1 disables retries
+	Backoff     string `json:"backoff,omitempty"`     // Base delay as a Go duration
+	MaxBackoff  string `json:"max_backoff,omitempty"` // Cap on the doubled delay
+	Statuses    []int  `json:"statuses,omitempty"`    // Retryable codes; default 429, 502, 503, 504
+}
+
+// policy converts rc to a RetryPolicy
+func (rc RetryConfig) policy() (RetryPolicy, error) {
+	if rc.MaxAttempts < 1 {
+		return RetryPolicy{}, fmt.Errorf("max_attempts must be at least 1, got %d", rc.MaxAttempts)
+	}
+	p := RetryPolicy{MaxAttempts: rc.MaxAttempts, ShouldRetry: DefaultShouldRetry}
+	var err error
+	if rc.Backoff != "" {
+		if p.Backoff, err = time.ParseDuration(rc.Backoff); err != nil {
+			return RetryPolicy{}, fmt.Errorf("backoff: %w", err)
+		}
+	}
+	if rc.MaxBackoff != "" {
+		if p.MaxBackoff, err = time.ParseDuration(rc.MaxBackoff); err != nil {
+			return RetryPolicy{}, fmt.Errorf("max_backoff: %w", err)
+		}
+	}
+	if len(rc.Statuses) > 0 {
+		p.ShouldRetry = RetryOnStatus(rc.Statuses...)
+	}
+	return p, nil
+}
+
+// FleetConfig is the on-disk agent fleet description
+type FleetConfig struct {
+	Agents  []AgentConfig `json:"agents"`
+	Timeout string        `json:"timeout,omitempty"` // Default for agents without one
+	Retry   *RetryConfig  `json:"retry,omitempty"`   // Default for agents without one
+
+	StageTimeouts map[string]string `json:"stage_timeouts,omitempty"` // Default for agents without any
+	TLS           *TLSFileConfig    `json:"tls,omitempty"`            // Default for agents without one
+
+	// RetryBudget, when set, caps retries fleet-wide (see WithRetryBudget)
+	RetryBudget *RetryBudgetConfig `json:"retry_budget,omitempty"`
+}
+
+// RetryBudgetConfig sizes the coordinator-wide retry token bucket
+type RetryBudgetConfig struct {
+	Capacity  int     `json:"capacity"`
+	PerSecond float64 `json:"per_second"`
+}
+
+// NewCoordinatorFromConfig builds the agent pool from a JSON fleet file:
+//
+//	{"timeout": "30s", "agents": [{"url": "http://10.0.0.5:8080", "weight": 2,
+//	                               "labels": {"zone": "eu", "gpu": "true"}}],
+//	 "stage_timeouts": {"validate": "100ms", "generate": "60s", "verify": "100ms"},
+//	 "retry": {"max_attempts": 3, "backoff": "100ms", "statuses": [502, 503]},
+//	 "retry_budget": {"capacity": 100, "per_second": 10},
+//	 "tls": {"ca_file": "ca.pem", "cert_file": "client.pem", "key_file": "client-key.pem"}}
+func NewCoordinatorFromConfig(path string, opts ...CoordinatorOption) (*Coordinator, error) {
+	cfg, err := LoadFleetConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cfg.NewCoordinator(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// LoadFleetConfig reads a JSON fleet file (see NewCoordinatorFromConfig)
+// so it can be narrowed with Select before building the pool
+func LoadFleetConfig(path string) (FleetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FleetConfig{}, err
+	}
+	var cfg FleetConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return FleetConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Select returns cfg with only the agents carrying every label in
+// selector; an empty selector keeps them all
+func (cfg FleetConfig) Select(selector map[string]string) FleetConfig {
+	cfg.Agents = slices.DeleteFunc(slices.Clone(cfg.Agents), func(ac AgentConfig) bool {
+		return !labelsMatch(ac.Labels, selector)
+	})
+	return cfg
+}
+
+// labelsMatch reports whether labels has every key=value of selector
+func labelsMatch(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses "zone=eu,gpu=true" into a label selector
+func ParseSelector(s string) (map[string]string, error) {
+	selector := make(map[string]string)
+	for pair := range strings.SplitSeq(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("selector %q: want key=value", pair)
+		}
+		selector[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return selector, nil
+}
+
+// NewCoordinator builds the agent pool cfg describes
+func (cfg FleetConfig) NewCoordinator(opts ...CoordinatorOption) (*Coordinator, error) {
+	agents, err := cfg.NewAgents()
+	if err != nil {
+		return nil, err
+	}
+	fleetOpts, err := cfg.CoordinatorOptions()
+	if err != nil {
+		return nil, err
+	}
+	return NewCoordinatorWithAgents(agents, append(fleetOpts, opts...)...), nil
+}
+
+// CoordinatorOptions are the fleet-wide settings, such as RetryBudget,
+// for a Coordinator built over NewAgents' agents
+func (cfg FleetConfig) CoordinatorOptions() ([]CoordinatorOption, error) {
+	var opts []CoordinatorOption
+	if rb := cfg.RetryBudget; rb != nil {
+		if rb.Capacity <= 0 || rb.PerSecond < 0 {
+			return nil, errors.New("retry_budget: capacity must be positive and per_second non-negative")
+		}
+		opts = append(opts, WithRetryBudget(rb.Capacity, rb.PerSecond))
+	}
+	return opts, nil
+}
+
+// NewAgents builds cfg's agents without a Coordinator; pass
+// CoordinatorOptions to the one they join. opts apply to every agent
+// after the file's settings.
+func (cfg FleetConfig) NewAgents(opts ...AgentOption) ([]*FastForthAgent, error) {
+	if len(cfg.Agents) == 0 {
+		return nil, errors.New("no agents configured")
+	}
+
+	defaultStageTimeouts, err := parseStageTimeouts(cfg.StageTimeouts)
+	if err != nil {
+		return nil, fmt.Errorf("fleet stage_timeouts: %w", err)
+	}
+
+	agents := make([]*FastForthAgent, 0, len(cfg.Agents))
+	for i, ac := range cfg.Agents {
+		var agentOpts []AgentOption
+
+		timeout := ac.Timeout
+		if timeout == "" {
+			timeout = cfg.Timeout
+		}
+		if timeout != "" {
+			d, err := time.ParseDuration(timeout)
+			if err != nil {
+				return nil, fmt.Errorf("agent %d: timeout: %w", i, err)
+			}
+			agentOpts = append(agentOpts, WithTimeout(d))
+		}
+		timeouts := defaultStageTimeouts
+		if len(ac.StageTimeouts) > 0 {
+			if timeouts, err = parseStageTimeouts(ac.StageTimeouts); err != nil {
+				return nil, fmt.Errorf("agent %d: stage_timeouts: %w", i, err)
+			}
+		}
+		if len(timeouts) > 0 {
+			agentOpts = append(agentOpts, WithStageTimeouts(timeouts))
+		}
+		if ac.Weight < 0 {
+			return nil, fmt.Errorf("agent %d: negative weight %d", i, ac.Weight)
+		}
+		agentOpts = append(agentOpts, WithWeight(ac.Weight), WithLabels(ac.Labels))
+
+		if rc := cmp.Or(ac.Retry, cfg.Retry); rc != nil {
+			p, err := rc.policy()
+			if err != nil {
+				return nil, fmt.Errorf("agent %d: retry: %w", i, err)
+			}
+			agentOpts = append(agentOpts, WithRetry(p))
+		}
+
+		if tc := cmp.Or(ac.TLS, cfg.TLS); tc != nil {
+			if !strings.HasPrefix(ac.URL, "https://") {
+				return nil, fmt.Errorf("agent %d: tls: URL %q is not https://", i, ac.URL)
+			}
+			tlsCfg, err := tc.load()
+			if err != nil {
+				return nil, fmt.Errorf("agent %d: tls: %w", i, err)
+			}
+			agentOpts = append(agentOpts, WithTLSConfig(tlsCfg))
+		}
+
+		agent, err := NewFastForthAgentURL(ac.URL, append(agentOpts, opts...)...)
+		if err != nil {
+			return nil, fmt.Errorf("agent %d: %w", i, err)
+		}
+		agents = append(agents, agent)
+	}
+	return agents, nil
+}
+
+// Environment variables read by NewCoordinatorFromEnv
+const (
+	EnvAgentURLs = "FIFTH_AGENT_URLS"     // Comma-separated agent base URLs
+	EnvConfig    = "FIFTH_CONFIG"         // Fleet file, used when FIFTH_AGENT_URLS is unset
+	EnvSelector  = "FIFTH_AGENT_SELECTOR" // Labels the fleet file's agents must carry, e.g. "zone=eu"
+	EnvTimeout   = "FIFTH_TIMEOUT"        // Per-request timeout as a Go duration; default 30s
+	EnvWorkers   = "FIFTH_WORKERS"        // Concurrent specs; default 8 per agent
+
+	EnvStageTimeouts = "FIFTH_STAGE_TIMEOUTS" // e.g. "validate=100ms,generate=60s"; see ParseStageTimeouts
+
+	EnvTLSCA   = "FIFTH_TLS_CA"   // CA bundle for https:// agents' certificates
+	EnvTLSCert = "FIFTH_TLS_CERT" // Client certificate for mutual TLS
+	EnvTLSKey  = "FIFTH_TLS_KEY"  // Its private key
+)
+
+// NewCoordinatorFromEnv builds the agent pool from environment variables,
+// for container deployments:
+//
+//	FIFTH_AGENT_URLS=http://agent-0:8080,http://agent-1:8080
+//	FIFTH_TIMEOUT=10s
+//	FIFTH_STAGE_TIMEOUTS=validate=100ms,generate=60s,verify=100ms
+//	FIFTH_WORKERS=64
+//	FIFTH_TLS_CA=/etc/fifth/ca.pem FIFTH_TLS_CERT=... FIFTH_TLS_KEY=...
+//
+// or, for weights, labels and per-agent settings, from a fleet file
+// (see NewCoordinatorFromConfig) narrowed by a label selector:
+//
+//	FIFTH_CONFIG=/etc/fifth/fleet.json
+//	FIFTH_AGENT_SELECTOR=zone=eu
+//
+// FIFTH_AGENT_URLS wins when both are set. Variables the chosen source
+// cannot honour are errors, as the matching flags are for fifth: a
+// fleet file with FIFTH_TIMEOUT, FIFTH_STAGE_TIMEOUTS or FIFTH_TLS_*
+// (see FleetConflict), and URLs with FIFTH_AGENT_SELECTOR. opts are
+// applied after the environment, so they take precedence.
+func NewCoordinatorFromEnv(opts ...CoordinatorOption) (*Coordinator, error) {
+	workers, err := WorkersFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if workers > 0 {
+		opts = append([]CoordinatorOption{WithWorkers(workers)}, opts...)
+	}
+
+	if path := os.Getenv(EnvConfig); path != "" && os.Getenv(EnvAgentURLs) == "" {
+		if err := FleetConflict(FleetSettingsFromEnv()...); err != nil {
+			return nil, err
+		}
+		cfg, err := LoadFleetConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvConfig, err)
+		}
+		selector, err := ParseSelector(os.Getenv(EnvSelector))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvSelector, err)
+		}
+		c, err := cfg.Select(selector).NewCoordinator(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return c, nil
+	}
+
+	if os.Getenv(EnvSelector) != "" {
+		return nil, fmt.Errorf("%s %w", EnvSelector, ErrSelectorNeedsFleet)
+	}
+	agentOpts, _, err := AgentOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	var agents []*FastForthAgent
+	for raw := range strings.SplitSeq(os.Getenv(EnvAgentURLs), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		agent, err := NewFastForthAgentURL(raw, agentOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvAgentURLs, err)
+		}
+		agents = append(agents, agent)
+	}
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("%s: no agent URLs set (or set %s to a fleet file)", EnvAgentURLs, EnvConfig)
+	}
+
+	return NewCoordinatorWithAgents(agents, opts...), nil
+}
+
+// WorkersFromEnv parses FIFTH_WORKERS, returning 0 when it is unset
+func WorkersFromEnv() (int, error) {
+	v := os.Getenv(EnvWorkers)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s=%q: want a positive integer", EnvWorkers, v)
+	}
+	return n, nil
+}
+
+// AgentOptionsFromEnv parses FIFTH_TIMEOUT, FIFTH_STAGE_TIMEOUTS and
+// FIFTH_TLS_* into options for agents listed by URL. The TLS config is
+// also returned on its own, for transports that take it directly.
+func AgentOptionsFromEnv() ([]AgentOption, *tls.Config, error) {
+	var agentOpts []AgentOption
+	if v := os.Getenv(EnvTimeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("%s=%q: want a positive duration such as 10s", EnvTimeout, v)
+		}
+		agentOpts = append(agentOpts, WithTimeout(d))
+	}
+	if v := os.Getenv(EnvStageTimeouts); v != "" {
+		timeouts, err := ParseStageTimeouts(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", EnvStageTimeouts, err)
+		}
+		agentOpts = append(agentOpts, WithStageTimeouts(timeouts))
+	}
+	var tlsCfg *tls.Config
+	if ca, cert, key := os.Getenv(EnvTLSCA), os.Getenv(EnvTLSCert), os.Getenv(EnvTLSKey); ca != "" || cert != "" || key != "" {
+		var err error
+		if tlsCfg, err = LoadClientTLSConfig(cert, key, ca); err != nil {
+			return nil, nil, fmt.Errorf("%s/%s/%s: %w", EnvTLSCA, EnvTLSCert, EnvTLSKey, err)
+		}
+		agentOpts = append(agentOpts, WithTLSConfig(tlsCfg))
+	}
+	return agentOpts, tlsCfg, nil
+}
+
+// ErrFleetConflict is wrapped by FleetConflict's errors
+var ErrFleetConflict = errors.New("cannot be combined with a fleet file")
+
+// ErrSelectorNeedsFleet is wrapped by the error for a label selector
+// given with agent URLs: only fleet files carry labels
+var ErrSelectorNeedsFleet = errors.New("needs a fleet file: only fleet files carry labels")
+
+// FleetSetting is a per-agent setting made outside a fleet file, under
+// the flag or variable name it was given by
+type FleetSetting struct {
+	Name  string
+	Field string // The fleet file's field for it; "" if it has none
+	Set   bool
+}
+
+// FleetConflict reports the first setting that is Set. A fleet file
+// sets each agent's transport, so it would silently win over them.
+func FleetConflict(settings ...FleetSetting) error {
+	for _, s := range settings {
+		switch {
+		case !s.Set:
+		case s.Field == "":
+			return fmt.Errorf("%s %w", s.Name, ErrFleetConflict)
+		default:
+			return fmt.Errorf("%s %w; set %s in it", s.Name, ErrFleetConflict, s.Field)
+		}
+	}
+	return nil
+}
+
+// FleetSettingsFromEnv lists the variables AgentOptionsFromEnv reads, for
+// FleetConflict
+func FleetSettingsFromEnv() []FleetSetting {
+	return []FleetSetting{
+		{Name: EnvTimeout, Field: "timeout", Set: os.Getenv(EnvTimeout) != ""},
+		{Name: EnvStageTimeouts, Field: "stage_timeouts", Set: os.Getenv(EnvStageTimeouts) != ""},
+		{Name: "FIFTH_TLS_*", Field: "tls", Set: os.Getenv(EnvTLSCA) != "" || os.Getenv(EnvTLSCert) != "" || os.Getenv(EnvTLSKey) != ""},
+	}
+}
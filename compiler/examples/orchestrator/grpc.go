@@ -0,0 +1,177 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/agentpb"
+)
+
+// GRPCAgent speaks the gRPC service in agentpb/agent.proto instead of
+// JSON over HTTP: binary messages multiplexed over one HTTP/2
+// connection, the context deadline sent as grpc-timeout, and failures
+// returned as *agentpb.Status. http:// URLs use HTTP/2 without TLS
+// (h2c), as `fifth serve` expects. Wrap it with NewAgent to give it a
+// Coordinator pool name and AgentOptions.
+type GRPCAgent struct {
+	URL    string
+	client *http.Client
+}
+
+// NewGRPCAgent creates a gRPC client for an http(s) base URL
+func NewGRPCAgent(rawURL string) (*GRPCAgent, error) {
+	return NewGRPCAgentTLS(rawURL, nil)
+}
+
+// NewGRPCAgentTLS is NewGRPCAgent with a client TLS config for https://
+// URLs, e.g. from LoadClientTLSConfig for mutual TLS; nil uses the
+// system roots
+func NewGRPCAgentTLS(rawURL string, tlsConfig *tls.Config) (*GRPCAgent, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("agent URL %q: %w", rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("agent URL %q: want http(s)://host[:port]", rawURL)
+	}
+	o := DefaultTransportOptions
+	o.HTTP2, o.UnencryptedHTTP2 = true, u.Scheme == "http"
+	t := newTransport(o)
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig.Clone()
+	}
+	return &GRPCAgent{
+		URL:    strings.TrimSuffix(rawURL, "/"),
+		client: &http.Client{Transport: t},
+	}, nil
+}
+
+// invoke makes one unary call, decoding the reply into out
+func (g *GRPCAgent) invoke(ctx context.Context, method string, in, out agentpb.Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL+method, bytes.NewReader(agentpb.Frame(in.Marshal())))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", agentpb.ContentType)
+	req.Header.Set("TE", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", agentpb.EncodeTimeout(time.Until(deadline)))
+	}
+	if id := RequestIDFrom(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	setTraceparent(ctx, req)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(req.URL.String(), resp)
+	}
+
+	// 1. The reply; failed calls have none
+	msg, readErr := agentpb.ReadFrame(resp.Body, agentpb.DefaultMaxMessageSize)
+	if readErr != nil && readErr != io.EOF {
+		return readErr
+	}
+	io.Copy(io.Discard, resp.Body) // Trailers arrive after the body
+
+	// 2. Status from the trailers, or the headers of a trailers-only reply
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.ParseUint(status, 10, 32)
+	if err != nil {
+		return agentpb.Errorf(agentpb.Internal, "%s: missing grpc-status", req.URL)
+	}
+	if code != uint64(agentpb.OK) {
+		return &agentpb.Status{Code: agentpb.Code(code), Message: agentpb.DecodeStatusMessage(message)}
+	}
+	if readErr == io.EOF {
+		return agentpb.Errorf(agentpb.Internal, "%s: no reply message", req.URL)
+	}
+	return out.Unmarshal(msg)
+}
+
+// pbSpec converts spec to its wire form
+func pbSpec(spec Specification) *agentpb.Spec {
+	s := &agentpb.Spec{
+		ID:          spec.ID,
+		Word:        spec.Word,
+		StackEffect: spec.StackEffect,
+		PatternID:   spec.PatternID,
+		Definitions: spec.Definitions,
+		RequestID:   spec.RequestID,
+	}
+	for _, tc := range spec.TestCases {
+		s.TestCases = append(s.TestCases, agentpb.TestCase{Input: tc.Input, Output: tc.Output})
+	}
+	return s
+}
+
+func (g *GRPCAgent) ValidateSpec(ctx context.Context, spec Specification) (bool, error) {
+	var reply agentpb.ValidateReply
+	err := g.invoke(ctx, agentpb.MethodValidate, pbSpec(spec), &reply)
+	return reply.Valid, err
+}
+
+func (g *GRPCAgent) GenerateCode(ctx context.Context, spec Specification) (string, []string, error) {
+	var reply agentpb.GenerateReply
+	err := g.invoke(ctx, agentpb.MethodGenerate, pbSpec(spec), &reply)
+	return reply.Code, reply.Tests, err
+}
+
+func (g *GRPCAgent) VerifyStackEffect(ctx context.Context, code, effect string) (bool, error) {
+	var reply agentpb.VerifyReply
+	err := g.invoke(ctx, agentpb.MethodVerify, &agentpb.VerifyRequest{Code: code, Effect: effect}, &reply)
+	return reply.Valid, err
+}
+
+// ProcessSpec runs validate, generate and verify on the agent in one
+// round trip. A Coordinator goes through NewAgent's pipeline instead,
+// calling the stages one by one.
+func (g *GRPCAgent) ProcessSpec(ctx context.Context, spec Specification) Result {
+	start := time.Now()
+	var reply agentpb.Result
+	if err := g.invoke(ctx, agentpb.MethodProcess, pbSpec(spec), &reply); err != nil {
+		return Result{
+			SpecID:    spec.ID,
+			RequestID: spec.RequestID,
+			Agent:     g.URL,
+			Error:     err.Error(),
+			Category:  stageFailure("", err),
+			LatencyMS: time.Since(start).Seconds() * 1000,
+			Labels:    spec.Labels,
+		}
+	}
+	return Result{
+		SpecID:    spec.ID,
+		RequestID: spec.RequestID,
+		Agent:     g.URL,
+		Success:   reply.Success,
+		Code:      reply.Code,
+		Tests:     reply.Tests,
+		TestCount: len(reply.Tests),
+		Error:     reply.Error,
+		Category:  FailureCategory(reply.Category),
+		LatencyMS: time.Since(start).Seconds() * 1000,
+		Labels:    spec.Labels,
+	}
+}
+
+// Ping validates a trivial spec, as FastForthAgent.Ping does
+func (g *GRPCAgent) Ping(ctx context.Context) error {
+	_, err := g.ValidateSpec(ctx, Specification{ID: "warmup", Word: "warmup", StackEffect: "( -- )"})
+	return err
+}
@@ -0,0 +1,86 @@
+package orchestrator_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/agentpb"
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// TestGRPCAgent calls the Go agent's gRPC service over h2c
+func TestGRPCAgent(t *testing.T) {
+	srv, _ := newAgentServer(t, nil)
+	agent, err := orchestrator.NewGRPCAgent(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	code := func(err error) agentpb.Code {
+		var st *agentpb.Status
+		if !errors.As(err, &st) {
+			return agentpb.OK
+		}
+		return st.Code
+	}
+
+	if err := agent.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	noWord := square
+	noWord.Word = ""
+	if ok, err := agent.ValidateSpec(ctx, square); !ok || err != nil {
+		t.Errorf("ValidateSpec(square) = %v, %v", ok, err)
+	}
+	if ok, err := agent.ValidateSpec(ctx, noWord); ok || err != nil {
+		t.Errorf("ValidateSpec(no word) = %v, %v; want false, nil", ok, err)
+	}
+
+	if code, tests, err := agent.GenerateCode(ctx, square); err != nil || code != ": square ( n -- n² )\n  dup * ;" || len(tests) != 1 {
+		t.Errorf("GenerateCode(square) = %q, %q, %v", code, tests, err)
+	}
+	unknown := square
+	unknown.PatternID, unknown.TestCases = "NO_SUCH_PATTERN", nil
+	if _, _, err := agent.GenerateCode(ctx, unknown); code(err) != agentpb.NotFound {
+		t.Errorf("GenerateCode(unknown pattern) = %v, want NOT_FOUND", err)
+	}
+	if _, _, err := agent.GenerateCode(ctx, noWord); code(err) != agentpb.InvalidArgument {
+		t.Errorf("GenerateCode(no word) = %v, want INVALID_ARGUMENT", err)
+	}
+
+	if ok, err := agent.VerifyStackEffect(ctx, "dup *", "( n -- n )"); !ok || err != nil {
+		t.Errorf("VerifyStackEffect(dup *) = %v, %v", ok, err)
+	}
+	if ok, err := agent.VerifyStackEffect(ctx, "dup", "( n -- n )"); ok || err != nil {
+		t.Errorf("VerifyStackEffect(dup) = %v, %v; want false, nil", ok, err)
+	}
+	if _, err := agent.VerifyStackEffect(ctx, "frobnicate", "( n -- n )"); code(err) != agentpb.InvalidArgument {
+		t.Errorf("VerifyStackEffect(unknown word) = %v, want INVALID_ARGUMENT", err)
+	}
+
+	if r := agent.ProcessSpec(ctx, square); !r.Success || r.Code == "" || r.TestCount != 1 {
+		t.Errorf("ProcessSpec(square) = %+v", r)
+	}
+	if r := agent.ProcessSpec(ctx, noWord); r.Success || r.Category != orchestrator.FailInvalidSpec {
+		t.Errorf("ProcessSpec(no word) = %+v, want an invalid spec", r)
+	}
+}
+
+// TestGRPCNeedsHTTP2 checks that a plain HTTP/1.1 call is refused
+// rather than answered
+func TestGRPCNeedsHTTP2(t *testing.T) {
+	srv, _ := newAgentServer(t, nil)
+	resp, err := srv.Client().Post(srv.URL+agentpb.MethodValidate, agentpb.ContentType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("HTTP/1.1 gRPC call: status %d, want 415", resp.StatusCode)
+	}
+}
@@ -0,0 +1,342 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoAgents is returned when work is submitted to an empty agent pool
+var ErrNoAgents = errors.New("coordinator has no agents")
+
+// checkAgents returns ErrNoAgents if no agent is registered
+func (c *Coordinator) checkAgents() error {
+	c.agentsMu.RLock()
+	defer c.agentsMu.RUnlock()
+	if len(c.agents) == 0 {
+		return ErrNoAgents
+	}
+	return nil
+}
+
+// rebuildLocked recomputes the live set; agentsMu must be held
+func (c *Coordinator) rebuildLocked() {
+	c.live = nil
+	for _, agent := range c.agents {
+		if _, isDown := c.down[agent]; !isDown {
+			c.live = append(c.live, agent)
+		}
+	}
+}
+
+// Agents returns a snapshot of the registered pool, live or not
+func (c *Coordinator) Agents() []*FastForthAgent {
+	c.agentsMu.RLock()
+	defer c.agentsMu.RUnlock()
+	return slices.Clone(c.agents)
+}
+
+// LiveAgents returns a snapshot of the agents the scheduler draws from
+func (c *Coordinator) LiveAgents() []*FastForthAgent {
+	c.agentsMu.RLock()
+	defer c.agentsMu.RUnlock()
+	return slices.Clone(c.live)
+}
+
+// AddAgent registers an agent, of any Agent implementation as in
+// NewCoordinatorWithAgents; it is eligible for the next dispatched spec
+func (c *Coordinator) AddAgent(a Agent) {
+	c.agentsMu.Lock()
+	agent := c.poolAgent(a)
+	if agent.budget == nil {
+		agent.budget = c.budget
+	}
+	if agent.tracer == nil {
+		agent.tracer = c.tracer
+	}
+	if agent.rng == nil {
+		agent.rng = c.rng
+	}
+	c.agents = append(c.agents, agent)
+	c.rebuildLocked()
+	c.agentsMu.Unlock()
+
+	c.logger.Info("agent added", "agent", agent.URL)
+}
+
+// RemoveAgent unregisters the agent with url. Specs already running on
+// it finish there. Reports whether the agent was registered.
+func (c *Coordinator) RemoveAgent(url string) bool {
+	c.agentsMu.Lock()
+	i := slices.IndexFunc(c.agents, func(a *FastForthAgent) bool { return a.URL == url })
+	if i >= 0 {
+		delete(c.down, c.agents[i])
+		delete(c.streaks, c.agents[i])
+		c.agents = slices.Delete(c.agents, i, i+1)
+		c.rebuildLocked()
+	}
+	c.agentsMu.Unlock()
+
+	if i >= 0 {
+		c.logger.Info("agent removed", "agent", url)
+	}
+	return i >= 0
+}
+
+// observeProbe records a health probe and calls setHealth once the
+// agent's streak of failures or passes reaches its threshold
+func (c *Coordinator) observeProbe(agent *FastForthAgent, err error) {
+	c.agentsMu.Lock()
+	streak := c.streaks[agent]
+	if err != nil {
+		streak = min(streak, 0) - 1
+	} else {
+		streak = max(streak, 0) + 1
+	}
+	c.streaks[agent] = streak
+	_, down := c.down[agent]
+	c.agentsMu.Unlock()
+
+	switch {
+	case err != nil && !down && -streak >= c.unhealthyAfter:
+		c.setHealth(agent, fmt.Errorf("%d consecutive health checks failed: %w", -streak, err))
+	case err == nil && down && streak >= c.healthyAfter:
+		c.setHealth(agent, nil)
+	}
+}
+
+// setHealth moves agent in or out of the live set and reports transitions
+func (c *Coordinator) setHealth(agent *FastForthAgent, err error) {
+	c.agentsMu.Lock()
+	_, wasDown := c.down[agent]
+	registered := slices.Contains(c.agents, agent)
+	changed := registered && wasDown != (err != nil)
+	if changed {
+		if err != nil {
+			c.down[agent] = err
+		} else {
+			delete(c.down, agent)
+		}
+		c.rebuildLocked()
+	}
+	c.agentsMu.Unlock()
+
+	if !changed {
+		return
+	}
+	mo, _ := c.observer.(MembershipObserver)
+	if err != nil {
+		c.logger.Warn("agent down", "agent", agent.URL, "err", err)
+		if mo != nil {
+			mo.OnAgentDown(agent.URL, err)
+		}
+	} else {
+		c.logger.Info("agent up", "agent", agent.URL)
+		if mo != nil {
+			mo.OnAgentUp(agent.URL)
+		}
+	}
+}
+
+// DefaultHealthInterval is MonitorHealth's interval when given none
+const DefaultHealthInterval = 10 * time.Second
+
+// MonitorHealth probes every registered agent's /health each interval,
+// or DefaultHealthInterval if interval is not positive. An agent is
+// dropped from the live set after WithHealthThreshold's consecutive
+// failures and restored after its consecutive passes. It blocks until
+// ctx is done; run it in its own goroutine.
+func (c *Coordinator) MonitorHealth(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, agent := range c.Agents() {
+			wg.Add(1)
+			go func(agent *FastForthAgent) {
+				defer wg.Done()
+				pingCtx, cancel := context.WithTimeout(ctx, interval)
+				defer cancel()
+				err := agent.Health(pingCtx)
+				if ctx.Err() == nil {
+					c.observeProbe(agent, err)
+				}
+			}(agent)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WarmupError lists the agents that failed the preflight ping
+type WarmupError struct {
+	Failed map[string]error // Keyed by agent URL
+}
+
+func (e *WarmupError) Error() string {
+	urls := make([]string, 0, len(e.Failed))
+	for url := range e.Failed {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	parts := make([]string, len(urls))
+	for i, url := range urls {
+		parts[i] = fmt.Sprintf("%s: %v", url, e.Failed[url])
+	}
+	return fmt.Sprintf("%d agent(s) failed warmup: %s", len(urls), strings.Join(parts, "; "))
+}
+
+// Warmup pings every agent in parallel so cold starts don't skew Run timing.
+// Returns a *WarmupError naming each agent that did not answer with 200.
+func (c *Coordinator) Warmup(ctx context.Context) error {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed = make(map[string]error)
+	)
+
+	for _, agent := range c.Agents() {
+		wg.Add(1)
+		go func(agent *FastForthAgent) {
+			defer wg.Done()
+			if err := agent.Ping(ctx); err != nil {
+				mu.Lock()
+				failed[agent.URL] = err
+				mu.Unlock()
+			}
+		}(agent)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return &WarmupError{Failed: failed}
+	}
+	return nil
+}
+
+// AgentCompat is one agent's row in a CheckCompatibility report
+type AgentCompat struct {
+	URL        string
+	Version    AgentVersion
+	Compatible bool
+	Err        error // Set when /version could not be read
+}
+
+// IncompatibleError lists agents below the minimum protocol version or
+// that could not report one
+type IncompatibleError struct {
+	MinProtocol int
+	Agents      []AgentCompat
+}
+
+func (e *IncompatibleError) Error() string {
+	parts := make([]string, len(e.Agents))
+	for i, a := range e.Agents {
+		if a.Err != nil {
+			parts[i] = fmt.Sprintf("%s (%v)", a.URL, a.Err)
+		} else {
+			parts[i] = fmt.Sprintf("%s (protocol %d)", a.URL, a.Version.Protocol)
+		}
+	}
+	return fmt.Sprintf("%d agent(s) incompatible with protocol %d: %s", len(e.Agents), e.MinProtocol, strings.Join(parts, ", "))
+}
+
+// CheckCompatibility queries every registered agent's /version and
+// compares it with the minimum protocol (ProtocolVersion unless set with
+// WithMinProtocol). The report covers all agents in registration order;
+// the error is an *IncompatibleError if any fall short.
+func (c *Coordinator) CheckCompatibility(ctx context.Context) ([]AgentCompat, error) {
+	agents := c.Agents()
+	minProtocol := cmp.Or(c.minProtocol, ProtocolVersion)
+
+	report := make([]AgentCompat, len(agents))
+	var wg sync.WaitGroup
+	for i, agent := range agents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := agent.Version(ctx)
+			report[i] = AgentCompat{
+				URL:        agent.URL,
+				Version:    v,
+				Compatible: err == nil && v.Protocol >= minProtocol,
+				Err:        err,
+			}
+		}()
+	}
+	wg.Wait()
+
+	var bad []AgentCompat
+	for _, r := range report {
+		if !r.Compatible {
+			bad = append(bad, r)
+		}
+	}
+	if len(bad) > 0 {
+		return report, &IncompatibleError{MinProtocol: minProtocol, Agents: bad}
+	}
+	return report, nil
+}
+
+// preflight is the pre-run check: the pool is non-empty and, with
+// WithMinProtocol, every agent is compatible
+func (c *Coordinator) preflight(ctx context.Context) error {
+	if err := c.checkAgents(); err != nil {
+		return err
+	}
+	if c.minProtocol > 0 {
+		_, err := c.CheckCompatibility(ctx)
+		return err
+	}
+	return nil
+}
+
+// Health check thresholds used by MonitorHealth unless overridden
+const (
+	DefaultUnhealthyAfter = 3
+	DefaultHealthyAfter   = 2
+)
+
+// WithHealthThreshold sets how many consecutive failed probes drop an
+// agent from the live set and how many consecutive passes re-admit it.
+// Values below 1 are treated as 1.
+func WithHealthThreshold(unhealthyAfter, healthyAfter int) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.unhealthyAfter = max(unhealthyAfter, 1)
+		c.healthyAfter = max(healthyAfter, 1)
+	}
+}
+
+// WithMinProtocol makes Run, RunResults and RunStream call
+// CheckCompatibility first and refuse to start if any agent reports a
+// protocol below v, so an old agent can't silently ignore new fields.
+func WithMinProtocol(v int) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.minProtocol = v
+	}
+}
+
+// probeKey marks the context of an AutoTuneWorkers probe run
+type probeKey struct{}
+
+// probing reports whether ctx belongs to an AutoTuneWorkers probe, which
+// bypasses caches and writes no checkpoint or failed-specs file
+func probing(ctx context.Context) bool {
+	return ctx.Value(probeKey{}) != nil
+}
@@ -0,0 +1,85 @@
+package orchestrator_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// membership records agent transitions with the probe they followed
+type membership struct {
+	orchestrator.NopObserver
+	probes *atomic.Int64
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *membership) OnAgentDown(url string, err error) { m.record("down") }
+func (m *membership) OnAgentUp(url string)              { m.record("up") }
+
+func (m *membership) record(event string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, fmt.Sprintf("%s@%d", event, m.probes.Load()))
+}
+
+// TestMonitorHealth scripts an agent's /health answers and checks it
+// leaves the live set only after three failures in a row and returns
+// only after two passes in a row
+func TestMonitorHealth(t *testing.T) {
+	// Probe n (1-based) fails where script[n-1] is false; later probes pass
+	script := []bool{true, false, false, true, false, false, false, true, false, true, true}
+	var probes atomic.Int64
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := int(probes.Add(1)); n <= len(script) && !script[n-1] {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+		}
+	}))
+	m := &membership{probes: &probes}
+	c := orchestrator.NewCoordinatorWithAgents(
+		[]*orchestrator.FastForthAgent{newAgent(t, srv.URL)},
+		orchestrator.WithObserver(m),
+		orchestrator.WithHealthThreshold(3, 2),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.MonitorHealth(ctx, time.Millisecond)
+	}()
+	for probes.Load() < int64(len(script))+3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if want := []string{"down@7", "up@11"}; !slices.Equal(m.events, want) {
+		t.Errorf("transitions %q, want %q", m.events, want)
+	}
+	if live := c.LiveAgents(); len(live) != 1 {
+		t.Errorf("%d live agents after recovery, want 1", len(live))
+	}
+}
+
+// A non-positive interval falls back to DefaultHealthInterval rather
+// than panicking in time.NewTicker
+func TestMonitorHealthZeroInterval(t *testing.T) {
+	c := orchestrator.NewCoordinatorWithAgents([]*orchestrator.FastForthAgent{
+		orchestrator.NewAgent("mock", &orchestrator.MockAgent{}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, interval := range []time.Duration{0, -time.Second} {
+		c.MonitorHealth(ctx, interval)
+	}
+}
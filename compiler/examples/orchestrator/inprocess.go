@@ -0,0 +1,106 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// InProcessAgent does an agent's work without a server: specs are
+// valid when they name a word and have a parseable stack effect, code
+// comes from Generate, and verification is VerifyStackEffectLocal, which
+// knows core words and the code's own colon definitions.
+type InProcessAgent struct {
+	Generate func(ctx context.Context, spec Specification) (code string, tests []string, err error)
+}
+
+func (p *InProcessAgent) ValidateSpec(_ context.Context, spec Specification) (bool, error) {
+	_, err := NormalizeStackEffect(spec.StackEffect)
+	return spec.Word != "" && err == nil, nil
+}
+
+func (p *InProcessAgent) GenerateCode(ctx context.Context, spec Specification) (string, []string, error) {
+	if p.Generate == nil {
+		return "", nil, errors.New("in-process agent has no Generate func")
+	}
+	return p.Generate(ctx, spec)
+}
+
+func (p *InProcessAgent) VerifyStackEffect(_ context.Context, code, effect string) (bool, error) {
+	return VerifyStackEffectLocal(code, effect)
+}
+
+// ProcessSpec runs DefaultPipeline against p
+func (p *InProcessAgent) ProcessSpec(ctx context.Context, spec Specification) Result {
+	return NewAgent("in-process", p).ProcessSpec(ctx, spec)
+}
+
+// MockAgent is a scripted Agent for testing orchestration without
+// servers. Nil funcs accept every spec, generate ": <word> ;" and verify
+// everything; Latency delays each call (cut short by ctx).
+type MockAgent struct {
+	Latency  time.Duration
+	Validate func(spec Specification) (bool, error)
+	Generate func(spec Specification) (string, []string, error)
+	Verify   func(code, effect string) (bool, error)
+
+	calls atomic.Int64
+}
+
+// Calls counts ValidateSpec, GenerateCode and VerifyStackEffect calls
+func (m *MockAgent) Calls() int64 {
+	return m.calls.Load()
+}
+
+// wait records a call and sleeps for Latency
+func (m *MockAgent) wait(ctx context.Context) error {
+	m.calls.Add(1)
+	if m.Latency <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(m.Latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *MockAgent) ValidateSpec(ctx context.Context, spec Specification) (bool, error) {
+	if err := m.wait(ctx); err != nil {
+		return false, err
+	}
+	if m.Validate == nil {
+		return true, nil
+	}
+	return m.Validate(spec)
+}
+
+func (m *MockAgent) GenerateCode(ctx context.Context, spec Specification) (string, []string, error) {
+	if err := m.wait(ctx); err != nil {
+		return "", nil, err
+	}
+	if m.Generate == nil {
+		return fmt.Sprintf(": %s ;", spec.Word), nil, nil
+	}
+	return m.Generate(spec)
+}
+
+func (m *MockAgent) VerifyStackEffect(ctx context.Context, code, effect string) (bool, error) {
+	if err := m.wait(ctx); err != nil {
+		return false, err
+	}
+	if m.Verify == nil {
+		return true, nil
+	}
+	return m.Verify(code, effect)
+}
+
+// ProcessSpec runs DefaultPipeline against m
+func (m *MockAgent) ProcessSpec(ctx context.Context, spec Specification) Result {
+	return NewAgent("mock", m).ProcessSpec(ctx, spec)
+}
@@ -0,0 +1,180 @@
+package orchestrator
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricBuckets are histogram upper bounds in seconds: Prometheus'
+// defaults stretched to cover minute-long generations
+var metricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// histogram counts observations per metricBuckets bucket; the extra
+// last bucket is +Inf
+type histogram struct {
+	counts [14]int64
+	sum    float64
+	n      int64
+}
+
+func (h *histogram) observe(seconds float64) {
+	i, _ := slices.BinarySearch(metricBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.n++
+}
+
+// metrics accumulates what the live counters don't: latency histograms
+// and per-agent and per-category counts
+type metrics struct {
+	mu         sync.Mutex
+	spec       histogram
+	stages     map[string]*histogram // By stage name
+	agentSpecs map[[2]string]int64   // By agent URL and "success"/"failure"
+	failures   map[FailureCategory]int64
+}
+
+func (m *metrics) observe(r Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stages == nil {
+		m.stages = make(map[string]*histogram)
+		m.failures = make(map[FailureCategory]int64)
+	}
+
+	if !r.Success {
+		m.failures[failureCategory(r)]++
+	}
+	if r.Skipped {
+		return // Never dispatched: no latency, no agent
+	}
+	m.spec.observe(r.LatencyMS / 1000)
+	for stage, ms := range map[string]float64{"validate": r.ValidateMS, "generate": r.GenerateMS, "verify": r.VerifyMS} {
+		if ms > 0 {
+			if m.stages[stage] == nil {
+				m.stages[stage] = &histogram{}
+			}
+			m.stages[stage].observe(ms / 1000)
+		}
+	}
+}
+
+// observeAgent counts one spec outcome against the agent at url
+func (m *metrics) observeAgent(url string, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.agentSpecs == nil {
+		m.agentSpecs = make(map[[2]string]int64)
+	}
+	outcome := "success"
+	if !success {
+		outcome = "failure"
+	}
+	m.agentSpecs[[2]string{url, outcome}]++
+}
+
+// MetricsHandler serves the Coordinator's metrics in the Prometheus
+// text format, for scraping when it runs as a long-lived service:
+// spec counts and latency, per-stage latency, failures by category,
+// queue depth, and per-agent outcomes, health and retries
+func (c *Coordinator) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes what MetricsHandler serves to w
+func (c *Coordinator) WriteMetrics(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	sample := func(name, labels string, v float64) {
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(b, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	hist := func(name, labels string, h *histogram) {
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		var cum int64
+		for i, le := range metricBuckets {
+			cum += h.counts[i]
+			sample(name+"_bucket", labels+sep+`le="`+strconv.FormatFloat(le, 'g', -1, 64)+`"`, float64(cum))
+		}
+		sample(name+"_bucket", labels+sep+`le="+Inf"`, float64(h.n))
+		sample(name+"_sum", labels, h.sum)
+		sample(name+"_count", labels, float64(h.n))
+	}
+
+	// 1. Live counters
+	snap := c.Snapshot()
+	metric("fifth_specs_total", "counter", "Specs finished, by result.")
+	sample("fifth_specs_total", `result="success"`, float64(snap.Succeeded))
+	sample("fifth_specs_total", `result="failure"`, float64(snap.Failed))
+	metric("fifth_specs_queued", "gauge", "Specs ready and waiting for a worker.")
+	sample("fifth_specs_queued", "", float64(snap.Queued))
+	metric("fifth_specs_blocked", "gauge", "Specs waiting on their dependencies.")
+	sample("fifth_specs_blocked", "", float64(snap.Blocked))
+	metric("fifth_specs_in_flight", "gauge", "Specs being processed.")
+	sample("fifth_specs_in_flight", "", float64(snap.InFlight))
+
+	// 2. Histograms and per-category and per-agent counts
+	m := &c.metrics
+	m.mu.Lock()
+	metric("fifth_spec_duration_seconds", "histogram", "Time to process a spec through every stage.")
+	hist("fifth_spec_duration_seconds", "", &m.spec)
+	metric("fifth_stage_duration_seconds", "histogram", "Time spent in each pipeline stage.")
+	for _, stage := range slices.Sorted(maps.Keys(m.stages)) {
+		hist("fifth_stage_duration_seconds", `stage="`+labelValue(stage)+`"`, m.stages[stage])
+	}
+	metric("fifth_spec_failures_total", "counter", "Failed specs, by failure category.")
+	for _, category := range slices.Sorted(maps.Keys(m.failures)) {
+		sample("fifth_spec_failures_total", `category="`+labelValue(string(category))+`"`, float64(m.failures[category]))
+	}
+	metric("fifth_agent_specs_total", "counter", "Specs finished on each agent, by result; a spec retried on a fallback counts on both agents.")
+	for _, key := range slices.SortedFunc(maps.Keys(m.agentSpecs), func(x, y [2]string) int {
+		return cmp.Or(cmp.Compare(x[0], y[0]), cmp.Compare(x[1], y[1]))
+	}) {
+		sample("fifth_agent_specs_total", `agent="`+labelValue(key[0])+`",result="`+key[1]+`"`, float64(m.agentSpecs[key]))
+	}
+	m.mu.Unlock()
+
+	// 3. Agent health and retries
+	agents := c.Agents()
+	c.agentsMu.RLock()
+	up := make([]bool, len(agents))
+	for i, agent := range agents {
+		_, isDown := c.down[agent]
+		up[i] = !isDown
+	}
+	c.agentsMu.RUnlock()
+	metric("fifth_agent_up", "gauge", "Whether the agent is in the live set (1) or routed around (0).")
+	for i, agent := range agents {
+		v := 0.0
+		if up[i] {
+			v = 1
+		}
+		sample("fifth_agent_up", `agent="`+labelValue(agent.URL)+`"`, v)
+	}
+	metric("fifth_agent_retries_total", "counter", "Requests re-sent to the agent under its retry policy.")
+	for _, agent := range agents {
+		sample("fifth_agent_retries_total", `agent="`+labelValue(agent.URL)+`"`, float64(agent.Retries()))
+	}
+	return b.Flush()
+}
+
+// labelValue escapes s for a Prometheus label value
+var labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
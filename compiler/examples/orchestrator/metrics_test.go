@@ -0,0 +1,183 @@
+package orchestrator_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// promSample is one parsed line of the Prometheus text format
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseMetrics parses the text format strictly enough to catch bad
+// escaping: label values must be quoted, with only \\, \" and \n escapes
+func parseMetrics(t *testing.T, text string) []promSample {
+	t.Helper()
+	var samples []promSample
+	typed := make(map[string]bool)
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		line := sc.Text()
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			typed[strings.Fields(rest)[0]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		s := promSample{labels: make(map[string]string)}
+		i := strings.IndexAny(line, "{ ")
+		if i < 0 {
+			t.Fatalf("bad line %q", line)
+		}
+		s.name, line = line[:i], line[i:]
+		if line[0] == '{' {
+			line = line[1:]
+			for line[0] != '}' {
+				eq := strings.Index(line, `="`)
+				if eq < 0 {
+					t.Fatalf("bad labels in %q", line)
+				}
+				key := line[:eq]
+				var value strings.Builder
+				j := eq + 2
+				for ; line[j] != '"'; j++ {
+					if line[j] == '\\' {
+						j++
+						switch line[j] {
+						case '\\', '"':
+							value.WriteByte(line[j])
+						case 'n':
+							value.WriteByte('\n')
+						default:
+							t.Fatalf("bad escape \\%c in %q", line[j], line)
+						}
+						continue
+					}
+					value.WriteByte(line[j])
+				}
+				s.labels[key] = value.String()
+				line = strings.TrimPrefix(line[j+1:], ",")
+			}
+			line = line[1:]
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(line), 64)
+		if err != nil {
+			t.Fatalf("%s: bad value: %v", s.name, err)
+		}
+		s.value = v
+		family := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(s.name, "_bucket"), "_sum"), "_count")
+		if !typed[s.name] && !typed[family] {
+			t.Errorf("%s has no # TYPE line before it", s.name)
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+func TestWriteMetrics(t *testing.T) {
+	// URLs with the characters label values must escape
+	failing, working := `mock "a"\one`, "mock b\ntwo"
+	c := orchestrator.NewCoordinatorWithAgents([]*orchestrator.FastForthAgent{
+		orchestrator.NewAgent(failing, &orchestrator.MockAgent{
+			Generate: func(orchestrator.Specification) (string, []string, error) {
+				return "", nil, errors.New("model refused")
+			},
+		}),
+		orchestrator.NewAgent(working, &orchestrator.MockAgent{Latency: 3 * time.Millisecond}),
+	})
+	results, err := c.Run(context.Background(), specsN(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]float64) // By agent and result
+	for _, r := range results {
+		want[fmt.Sprint(r.Agent, r.Success)]++
+	}
+	if want[fmt.Sprint(failing, false)] == 0 || want[fmt.Sprint(working, true)] == 0 {
+		t.Fatalf("both agents should have run specs: %v", want)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	samples := parseMetrics(t, buf.String())
+
+	// Per-agent outcomes, read back through the escaping
+	got := make(map[string]float64)
+	for _, s := range samples {
+		if s.name == "fifth_agent_specs_total" {
+			got[fmt.Sprint(s.labels["agent"], s.labels["result"] == "success")] += s.value
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("fifth_agent_specs_total = %v, want %v", got, want)
+	}
+	up := 0
+	for _, s := range samples {
+		if s.name == "fifth_agent_up" && (s.labels["agent"] == failing || s.labels["agent"] == working) {
+			up++
+		}
+	}
+	if up != 2 {
+		t.Errorf("fifth_agent_up has %d of the 2 agents:\n%s", up, buf.String())
+	}
+
+	// Histograms: buckets cumulative, +Inf equal to _count
+	type series struct {
+		last, inf, count float64
+		buckets          int
+	}
+	hists := make(map[string]*series) // By name and labels other than le
+	for _, s := range samples {
+		family, kind, ok := strings.Cut(s.name, "_seconds_")
+		if !ok {
+			continue
+		}
+		key := family + s.labels["stage"]
+		h := hists[key]
+		if h == nil {
+			h = &series{}
+			hists[key] = h
+		}
+		switch kind {
+		case "bucket":
+			if s.value < h.last {
+				t.Errorf("%s le=%s: %v after %v; buckets must be cumulative", key, s.labels["le"], s.value, h.last)
+			}
+			h.last = s.value
+			h.buckets++
+			if s.labels["le"] == "+Inf" {
+				h.inf = s.value
+			}
+		case "count":
+			h.count = s.value
+		}
+	}
+	if h := hists["fifth_spec_duration"]; h == nil || h.count != float64(len(results)) {
+		t.Errorf("fifth_spec_duration_seconds = %+v, want %d observations", h, len(results))
+	}
+	for _, stage := range []string{"validate", "generate", "verify"} {
+		if hists["fifth_stage_duration"+stage] == nil {
+			t.Errorf("no %s stage histogram", stage)
+		}
+	}
+	for key, h := range hists {
+		if h.buckets != 14 || h.inf != h.count {
+			t.Errorf("%s: %d buckets, +Inf %v, _count %v; want 14 buckets and +Inf = _count", key, h.buckets, h.inf, h.count)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package orchestrator
+
+import (
+	"fmt"
+	"time"
+)
+
+// Observer receives lifecycle events during a run.
+// OnSpecStart, OnStageComplete, and OnSpecComplete are called concurrently
+// from worker goroutines and must be safe for concurrent use.
+// OnBatchComplete is called once, after every spec has finished.
+type Observer interface {
+	OnSpecStart(specID string)
+	OnStageComplete(specID, stage string, err error) // stage is the PipelineStep name
+	OnSpecComplete(result Result)
+	OnBatchComplete(stats RunStats)
+}
+
+// NopObserver ignores all events
+type NopObserver struct{}
+
+func (NopObserver) OnSpecStart(string)                    {}
+func (NopObserver) OnStageComplete(string, string, error) {}
+func (NopObserver) OnSpecComplete(Result)                 {}
+func (NopObserver) OnBatchComplete(RunStats)              {}
+
+// MembershipObserver is optionally implemented by an Observer to hear
+// about agents leaving and rejoining the live set
+type MembershipObserver interface {
+	OnAgentDown(url string, err error)
+	OnAgentUp(url string)
+}
+
+// ProgressObserver is optionally implemented by an Observer to receive
+// Run's progress ticks (see WithProgressInterval)
+type ProgressObserver interface {
+	OnProgress(p Progress)
+}
+
+// Progress is a Run's completion so far with a naive ETA
+type Progress struct {
+	Completed int
+	Total     int
+	Elapsed   time.Duration
+	Remaining time.Duration // elapsed / completed × remaining specs
+}
+
+func newProgress(completed, total int, elapsed time.Duration) Progress {
+	p := Progress{Completed: completed, Total: total, Elapsed: elapsed}
+	if completed > 0 {
+		p.Remaining = elapsed / time.Duration(completed) * time.Duration(total-completed)
+	}
+	return p
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("Progress: %d/%d, ~%v remaining", p.Completed, p.Total, p.Remaining.Round(time.Second))
+}
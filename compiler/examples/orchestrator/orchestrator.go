@@ -0,0 +1,1290 @@
+// Package orchestrator coordinates Fast Forth agents: it fans
+// specifications out across a pool of agent servers, runs each through
+// validate, generate and verify, and collects the results.
+//
+// This is the "pragmatic compromise" - not pure Forth,
+// but 10-20x lighter than Python and proven concurrency.
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Specification for Fast Forth agent
+type Specification struct {
+	ID          string     `json:"id"`
+	Word        string     `json:"word"`
+	StackEffect string     `json:"stack_effect"`
+	PatternID   string     `json:"pattern_id"`
+	TestCases   []TestCase `json:"test_cases"`
+	DependsOn   []string   `json:"depends_on,omitempty"`  // Spec IDs that must succeed first
+	Definitions []string   `json:"definitions,omitempty"` // Code of DependsOn words, filled in by Run
+	RequestID   string     `json:"request_id,omitempty"`  // X-Request-ID for all calls; generated when empty
+	Priority    int        `json:"priority,omitempty"`    // Higher dispatches first; default 0
+
+	// Timeout bounds the whole pipeline for this spec; 0 means no limit
+	// beyond the client and batch timeouts
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// Labels are free-form tags (team, source, experiment) copied to the
+	// Result for grouping with StatsBy
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Duration is a time.Duration written in JSON as a Go duration string
+// such as "5s" or "1m30s", like the timeouts in a fleet file
+type Duration time.Duration
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New(`duration: want a string such as "5s"`)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Test case for validation
+type TestCase struct {
+	Input  []int `json:"input"`
+	Output []int `json:"output"`
+}
+
+// Result from Fast Forth agent
+type Result struct {
+	SpecID     string   `json:"spec_id"`
+	RequestID  string   `json:"request_id,omitempty"` // Sent as X-Request-ID to the agent
+	Agent      string   `json:"agent,omitempty"`      // URL of the agent that ran the spec
+	Success    bool     `json:"success"`
+	Code       string   `json:"code,omitempty"`
+	Tests      []string `json:"tests,omitempty"`
+	TestCount  int      `json:"test_count"` // len(Tests); 0 flags code that came back without tests
+	Error      string   `json:"error,omitempty"`
+	LatencyMS  float64  `json:"latency_ms"`
+	ValidateMS float64  `json:"validate_ms,omitempty"` // Per-stage share of LatencyMS
+	GenerateMS float64  `json:"generate_ms,omitempty"`
+	VerifyMS   float64  `json:"verify_ms,omitempty"`
+	Index      int      `json:"index"`                // Submission order within the batch
+	Skipped    bool     `json:"skipped,omitempty"`    // Not run: dependency failed or run cancelled
+	Cancelled  bool     `json:"cancelled,omitempty"`  // Aborted by CancelSpec or context
+	TimedOut   bool     `json:"timed_out,omitempty"`  // Exceeded Specification.Timeout
+	FromCache  bool     `json:"from_cache,omitempty"` // Code served from Cache, not generated
+	Shared     bool     `json:"shared,omitempty"`     // Code from an identical spec's in-flight call
+
+	FailedTestCase int    `json:"failed_test_case,omitempty"` // 1-based index of the diverging TestCase
+	VerifyMethod   string `json:"verify_method,omitempty"`    // "agent" or "local"
+	Flipped        bool   `json:"flipped,omitempty"`          // Reverify: passed before, fails now
+	Fallback       bool   `json:"fallback,omitempty"`         // Primary pool failed; this is the fallback's result
+
+	Category FailureCategory `json:"category,omitempty"` // Why a failed spec failed; empty on success
+
+	Labels map[string]string `json:"labels,omitempty"` // Copied from Specification.Labels
+}
+
+// FailureCategory buckets failed results by cause, separating bad specs
+// from flaky agents
+type FailureCategory string
+
+const (
+	FailInvalidSpec   FailureCategory = "invalid_spec"   // Rejected by CheckArity or the agent
+	FailGeneration    FailureCategory = "generation"     // The agent could not generate code
+	FailStackMismatch FailureCategory = "stack_mismatch" // Code does not match the stack effect
+	FailTest          FailureCategory = "test_failed"    // A TestCase produced the wrong output
+	FailNetwork       FailureCategory = "network"        // Transport error or agent HTTP error
+	FailTimeout       FailureCategory = "timeout"        // Spec or request deadline exceeded
+	FailCancelled     FailureCategory = "cancelled"
+	FailSkipped       FailureCategory = "skipped"
+	FailOther         FailureCategory = "other"
+)
+
+// Coordinator manages multiple Fast Forth agents
+type Coordinator struct {
+	agentsMu sync.RWMutex
+	agents   []*FastForthAgent // Registered pool
+	down     map[*FastForthAgent]error
+	streaks  map[*FastForthAgent]int // Consecutive probe passes (>0) or failures (<0)
+	live     []*FastForthAgent       // Registered and not down
+	budget   *RetryBudget            // Handed to agents added later
+	tracer   *Tracer                 // Handed to agents added later
+	rng      *lockedRand             // Seeded source from WithRandSource
+	named    int                     // Agents poolAgent has named, for the next name
+
+	scheduler Scheduler
+
+	observer Observer
+	logger   *slog.Logger
+	workers  atomic.Int64 // Concurrent specs per run; see workerCount
+
+	throughput *ThroughputTracker
+
+	failedSpecsPath string // Run writes failed specs here when set
+	checkpointPath  string // Run appends each result here when set
+
+	inflightMu sync.Mutex
+	inflight   map[string][]*inflightSpec // Cancel handles by spec ID
+
+	emaAlpha float64 // Smoothing for agent latency EMAs
+
+	counters struct {
+		inFlight, completed, succeeded, failed atomic.Int64
+		queued, blocked                        atomic.Int64
+	}
+
+	fallbacks  []*FastForthAgent // Tried once when the primary pool fails a spec
+	fallbackRR RoundRobinScheduler
+
+	unhealthyAfter int // Consecutive failed probes before an agent is dropped
+	healthyAfter   int // Consecutive passed probes before it is re-admitted
+
+	minProtocol int  // Run refuses to start below this; 0 skips the check
+	shuffle     bool // Run dispatches equal-priority specs in random order
+
+	progressEvery int // Completed specs between progress ticks; 0 disables
+	onResult      func(completed, total int, r Result)
+
+	maxDuration time.Duration // Run stops dispatching after this; 0 means no limit
+	gracePeriod time.Duration // In-flight specs get this long past maxDuration
+
+	spillAfter int    // RunResults in-memory cap; 0 means unlimited
+	spillDir   string // Where RunResults spills past spillAfter
+
+	metrics metrics // Latency histograms and per-agent counts for MetricsHandler
+}
+
+// Snapshot is a point-in-time view of the Coordinator's live counters
+type Snapshot struct {
+	Queued    int64 `json:"queued"`  // Ready, waiting for a Run worker
+	Blocked   int64 `json:"blocked"` // Waiting on DependsOn
+	InFlight  int64 `json:"in_flight"`
+	Completed int64 `json:"completed"` // Includes skipped specs
+	Succeeded int64 `json:"succeeded"`
+	Failed    int64 `json:"failed"`
+}
+
+// Snapshot reads the live counters; safe to call from any goroutine
+// while runs are in progress. Counts accumulate over the Coordinator's
+// lifetime across Run, RunChan and ProcessOne.
+func (c *Coordinator) Snapshot() Snapshot {
+	return Snapshot{
+		Queued:    c.counters.queued.Load(),
+		Blocked:   c.counters.blocked.Load(),
+		InFlight:  c.counters.inFlight.Load(),
+		Completed: c.counters.completed.Load(),
+		Succeeded: c.counters.succeeded.Load(),
+		Failed:    c.counters.failed.Load(),
+	}
+}
+
+// count folds a finished result into the live counters and metrics
+func (c *Coordinator) count(r Result) {
+	c.counters.completed.Add(1)
+	if r.Success {
+		c.counters.succeeded.Add(1)
+	} else {
+		c.counters.failed.Add(1)
+	}
+	c.metrics.observe(r)
+}
+
+// CoordinatorOption configures a Coordinator
+type CoordinatorOption func(*Coordinator)
+
+// WithObserver registers an observer for run lifecycle events
+func WithObserver(o Observer) CoordinatorOption {
+	return func(c *Coordinator) {
+		if o != nil {
+			c.observer = o
+		}
+	}
+}
+
+// WithLogger routes run diagnostics to logger (default: discarded)
+func WithLogger(logger *slog.Logger) CoordinatorOption {
+	return func(c *Coordinator) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithThroughputWindow sets the bucket width and how many buckets the
+// throughput series retains (default one hour of 1s buckets)
+func WithThroughputWindow(width time.Duration, buckets int) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.throughput = NewThroughputTracker(width, buckets)
+	}
+}
+
+// Throughput returns the completion-rate series for the current or last run.
+// Runs sharing a Coordinator concurrently share one series.
+func (c *Coordinator) Throughput() *ThroughputTracker {
+	return c.throughput
+}
+
+// WithFailedSpecsFile makes Run save failed specs to path for reprocessing
+func WithFailedSpecsFile(path string) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.failedSpecsPath = path
+	}
+}
+
+// DefaultProgressInterval is how many completed specs separate progress
+// ticks
+const DefaultProgressInterval = 10
+
+// WithProgressInterval logs progress, and calls a ProgressObserver, every
+// n completed specs and at the end of a Run; 0 disables it
+func WithProgressInterval(n int) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.progressEvery = max(n, 0)
+	}
+}
+
+// WithProgressFunc calls fn with every result Run, RunResults and
+// Stream collect, along with the count so far and the batch size, for
+// callers drawing their own progress UI. fn runs on the collecting
+// goroutine and should return quickly.
+func WithProgressFunc(fn func(completed, total int, r Result)) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.onResult = fn
+	}
+}
+
+// reportProgress logs a tick and forwards it to a ProgressObserver
+func (c *Coordinator) reportProgress(p Progress) {
+	c.logger.Info("progress", "completed", p.Completed, "total", p.Total,
+		"remaining", p.Remaining.Round(time.Second), "queued", c.counters.queued.Load())
+	if po, ok := c.observer.(ProgressObserver); ok {
+		po.OnProgress(p)
+	}
+}
+
+// WithShuffle makes Run dispatch specs of equal Priority in random
+// order rather than submission order, so batches sorted by pattern don't
+// send long runs of one pattern to the same agent. Seed the order with
+// WithRandSource for reproducible runs. Results still come back in
+// submission order with their original Index.
+func WithShuffle() CoordinatorOption {
+	return func(c *Coordinator) {
+		c.shuffle = true
+	}
+}
+
+// WithFallback adds a reliability tier: a spec that fails on the
+// primary pool is tried once more on one of agents (round-robin) before
+// it is marked failed; invalid specs are not. Fallback agents are not
+// health-monitored or scheduled for regular work.
+func WithFallback[A Agent](agents ...A) CoordinatorOption {
+	return func(c *Coordinator) {
+		for _, a := range agents {
+			agent := c.poolAgent(a)
+			if agent.tracer == nil {
+				agent.tracer = c.tracer
+			}
+			c.fallbacks = append(c.fallbacks, agent)
+		}
+	}
+}
+
+// DefaultWorkersPerAgent sizes the worker cap when WithWorkers is unset
+const DefaultWorkersPerAgent = 8
+
+// workerCount is the worker cap set by WithWorkers or AutoTuneWorkers
+func (c *Coordinator) workerCount() int {
+	return int(c.workers.Load())
+}
+
+// WithWorkers caps how many specs a run processes at once
+func WithWorkers(n int) CoordinatorOption {
+	return func(c *Coordinator) {
+		if n > 0 {
+			c.workers.Store(int64(n))
+		}
+	}
+}
+
+// NewCoordinator creates coordinator with N agents
+// Panics if numAgents < 1, which is always a configuration bug.
+func NewCoordinator(numAgents int, opts ...CoordinatorOption) *Coordinator {
+	if numAgents < 1 {
+		panic(fmt.Sprintf("NewCoordinator: need at least 1 agent, got %d", numAgents))
+	}
+	agents := make([]*FastForthAgent, numAgents)
+	for i := 0; i < numAgents; i++ {
+		agents[i] = NewFastForthAgent(8080 + i)
+	}
+	return NewCoordinatorWithAgents(agents, opts...)
+}
+
+// NewCoordinatorWithAgents creates coordinator over an existing agent
+// pool of any Agent implementation; see poolAgent
+func NewCoordinatorWithAgents[A Agent](agents []A, opts ...CoordinatorOption) *Coordinator {
+	c := &Coordinator{
+		observer: NopObserver{},
+		logger:   slog.New(slog.DiscardHandler),
+
+		throughput: NewThroughputTracker(time.Second, 3600),
+		inflight:   make(map[string][]*inflightSpec),
+		emaAlpha:   DefaultEMAAlpha,
+		scheduler:  &RoundRobinScheduler{},
+
+		progressEvery:  DefaultProgressInterval,
+		down:           make(map[*FastForthAgent]error),
+		streaks:        make(map[*FastForthAgent]int),
+		unhealthyAfter: DefaultUnhealthyAfter,
+		healthyAfter:   DefaultHealthyAfter,
+	}
+	for _, a := range agents {
+		c.agents = append(c.agents, c.poolAgent(a))
+	}
+	c.workers.Store(int64(len(agents) * DefaultWorkersPerAgent))
+	c.rebuildLocked()
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// poolAgent returns agent as the Coordinator schedules it: a
+// *FastForthAgent as is, and any other implementation wrapped with
+// NewAgent under the name "agent-<n>", n counting from 0 per Coordinator
+func (c *Coordinator) poolAgent(agent Agent) *FastForthAgent {
+	if a, ok := agent.(*FastForthAgent); ok {
+		return a
+	}
+	a := NewAgent(fmt.Sprintf("agent-%d", c.named), agent)
+	c.named++
+	return a
+}
+
+// buildDependencyGraph indexes DependsOn edges by spec position.
+// Returns each spec's dependents and its count of unmet dependencies.
+func buildDependencyGraph(specs []Specification) ([][]int, []int, error) {
+	dependents := make([][]int, len(specs))
+	pending := make([]int, len(specs))
+
+	// Duplicate IDs are tolerated until a dependency edge touches one,
+	// since the edge could not say which occurrence it means
+	index := make(map[string]int, len(specs))
+	dups := make(map[string]bool)
+	for i, spec := range specs {
+		if _, dup := index[spec.ID]; dup {
+			dups[spec.ID] = true
+		}
+		index[spec.ID] = i
+	}
+
+	for i, spec := range specs {
+		if len(spec.DependsOn) > 0 && dups[spec.ID] {
+			return nil, nil, fmt.Errorf("duplicate spec ID %q", spec.ID)
+		}
+		for _, dep := range spec.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, nil, fmt.Errorf("spec %q depends on unknown spec %q", spec.ID, dep)
+			}
+			if dups[dep] {
+				return nil, nil, fmt.Errorf("spec %q depends on duplicate spec ID %q", spec.ID, dep)
+			}
+			dependents[j] = append(dependents[j], i)
+			pending[i]++
+		}
+	}
+
+	// Depth-first search for back edges (0 = unvisited, 1 = on stack, 2 = done)
+	state := make([]int, len(specs))
+	var stack []int
+	var visit func(i int) error
+	visit = func(i int) error {
+		state[i] = 1
+		stack = append(stack, i)
+		for _, dep := range specs[i].DependsOn {
+			j := index[dep]
+			switch state[j] {
+			case 0:
+				if err := visit(j); err != nil {
+					return err
+				}
+			case 1:
+				// Unwind the stack back to j to name the cycle
+				var cycle []string
+				for k := len(stack) - 1; k >= 0; k-- {
+					cycle = append([]string{specs[stack[k]].ID}, cycle...)
+					if stack[k] == j {
+						break
+					}
+				}
+				cycle = append(cycle, specs[j].ID)
+				return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = 2
+		return nil
+	}
+	for i := range specs {
+		if state[i] == 0 {
+			if err := visit(i); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return dependents, pending, nil
+}
+
+// inflightSpec is the cancel handle for one running spec
+type inflightSpec struct {
+	cancel context.CancelFunc
+}
+
+// trackInflight registers a running spec so CancelSpec can reach it
+func (c *Coordinator) trackInflight(id string, cancel context.CancelFunc) *inflightSpec {
+	token := &inflightSpec{cancel: cancel}
+	c.inflightMu.Lock()
+	c.inflight[id] = append(c.inflight[id], token)
+	c.inflightMu.Unlock()
+	return token
+}
+
+// untrackInflight removes a finished spec's cancel handle
+func (c *Coordinator) untrackInflight(id string, token *inflightSpec) {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+
+	remaining := slices.DeleteFunc(c.inflight[id], func(t *inflightSpec) bool { return t == token })
+	if len(remaining) == 0 {
+		delete(c.inflight, id)
+	} else {
+		c.inflight[id] = remaining
+	}
+}
+
+// CancelSpec aborts the in-flight spec with this ID, leaving the rest of
+// the batch running. Its result comes back with Cancelled set. Reports
+// whether a running spec was found.
+func (c *Coordinator) CancelSpec(id string) bool {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+
+	for _, t := range c.inflight[id] {
+		t.cancel()
+	}
+	return len(c.inflight[id]) > 0
+}
+
+// fallbackFor returns a fallback agent to retry a failed result on, or
+// nil if it succeeded, was cancelled or timed out, failed because the
+// spec itself is invalid (it would fail there too), or there is none
+func (c *Coordinator) fallbackFor(ctx context.Context, r Result) *FastForthAgent {
+	if r.Success || r.Cancelled || r.TimedOut || r.Category == FailInvalidSpec || len(c.fallbacks) == 0 || ctx.Err() != nil {
+		return nil
+	}
+	return c.fallbackRR.Pick(c.fallbacks)
+}
+
+// process runs one spec on agent, notifying the observer and logger
+func (c *Coordinator) process(ctx context.Context, index int, spec Specification) Result {
+	agent := c.pick(spec)
+	if agent == nil {
+		// The pool emptied out (RemoveAgent) after the run started
+		result := Result{SpecID: spec.ID, Error: ErrNoAgents.Error(), Category: FailOther, Index: index, Labels: spec.Labels}
+		c.count(result)
+		c.observer.OnSpecComplete(result)
+		return result
+	}
+	defer c.release(agent)
+
+	c.counters.inFlight.Add(1)
+	defer c.counters.inFlight.Add(-1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	token := c.trackInflight(spec.ID, cancel)
+	defer func() {
+		c.untrackInflight(spec.ID, token)
+		cancel()
+	}()
+
+	// Spec.Timeout spans the primary and any fallback attempt
+	if spec.Timeout > 0 {
+		var cancelSpec context.CancelFunc
+		ctx, cancelSpec = context.WithTimeoutCause(ctx, time.Duration(spec.Timeout), ErrSpecTimeout)
+		defer cancelSpec()
+	}
+
+	c.observer.OnSpecStart(spec.ID)
+	result := agent.processSpec(ctx, spec, c.stageObserver(ctx, agent))
+	if fb := c.fallbackFor(ctx, result); fb != nil {
+		c.logger.Info("retrying on fallback", "spec_id", spec.ID, "agent", fb.URL, "primary_error", result.Error)
+		c.observeAgent(agent, result) // The rescue doesn't clear the primary
+		agent = fb
+		result = fb.processSpec(ctx, spec, c.stageObserver(ctx, fb))
+		result.Fallback = true
+	}
+	result.Index = index
+	result.Agent = agent.URL
+	c.count(result)
+	c.observeAgent(agent, result)
+	c.observer.OnSpecComplete(result)
+
+	level := slog.LevelDebug
+	if !result.Success {
+		level = slog.LevelWarn
+	}
+	c.logger.Log(ctx, level, "spec complete",
+		"spec_id", spec.ID,
+		"request_id", result.RequestID,
+		"agent", agent.URL,
+		"latency_ms", result.LatencyMS,
+		"success", result.Success,
+		"category", result.Category,
+		"error", result.Error,
+	)
+	return result
+}
+
+// observeAgent credits r to the agent that produced it, in its latency
+// EMA and its per-agent outcome counts
+func (c *Coordinator) observeAgent(agent *FastForthAgent, r Result) {
+	switch {
+	case r.Success && !r.FromCache && !r.Shared:
+		agent.observeLatency(r.LatencyMS, c.emaAlpha)
+	case !r.Success && !r.Cancelled && r.Category != FailInvalidSpec:
+		// The spec was fine but the agent let it down
+		agent.observeFailure(r.LatencyMS, c.emaAlpha)
+	}
+	c.metrics.observeAgent(agent.URL, r.Success)
+}
+
+// stageObserver is c.observer, plus a debug record per finished stage
+// when the logger wants them
+func (c *Coordinator) stageObserver(ctx context.Context, agent *FastForthAgent) Observer {
+	if !c.logger.Enabled(ctx, slog.LevelDebug) {
+		return c.observer
+	}
+	return &stageLogger{Observer: c.observer, logger: c.logger, agent: agent.URL, last: time.Now()}
+}
+
+// stageLogger logs each stage of one spec with its latency
+type stageLogger struct {
+	Observer
+	logger *slog.Logger
+	agent  string
+	last   time.Time // When the previous stage ended
+}
+
+func (l *stageLogger) OnStageComplete(specID, stage string, err error) {
+	now := time.Now()
+	attrs := []any{
+		"spec_id", specID,
+		"agent", l.agent,
+		"stage", stage,
+		"latency_ms", now.Sub(l.last).Seconds() * 1000,
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	}
+	l.logger.Debug("stage complete", attrs...)
+	l.last = now
+	l.Observer.OnStageComplete(specID, stage, err)
+}
+
+// ValidateAll validates specs in chunks of batchSize spread across the
+// agent pool, for dry runs over large batches. At most parallelism()
+// chunks are in flight at once. Results match specs by position; the
+// first error from any chunk is returned.
+func (c *Coordinator) ValidateAll(ctx context.Context, specs []Specification, batchSize int) ([]bool, error) {
+	if err := c.checkAgents(); err != nil && len(specs) > 0 {
+		return nil, err
+	}
+	batchSize = max(batchSize, 1)
+	valid := make([]bool, len(specs))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, c.parallelism())
+dispatch:
+	for start := 0; start < len(specs); start += batchSize {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			mu.Lock()
+			firstErr = cmp.Or(firstErr, ctx.Err())
+			mu.Unlock()
+			break dispatch
+		}
+		end := min(start+batchSize, len(specs))
+		wg.Add(1)
+		go func(agent *FastForthAgent, start, end int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if agent == nil {
+				mu.Lock()
+				firstErr = cmp.Or(firstErr, ErrNoAgents)
+				mu.Unlock()
+				return
+			}
+			defer c.release(agent)
+			chunk, err := agent.ValidateBatch(ctx, specs[start:end])
+			copy(valid[start:end], chunk)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", agent.URL, err)
+				}
+				mu.Unlock()
+			}
+		}(c.pick(specs[start]), start, end)
+	}
+	wg.Wait()
+
+	return valid, firstErr
+}
+
+// parallelism is how many specs a run can have in flight at once: the
+// worker count, further bounded by agent in-flight caps when every live
+// agent has one
+func (c *Coordinator) parallelism() int {
+	capped := 0
+	for _, agent := range c.LiveAgents() {
+		if agent.slots == nil {
+			return max(c.workerCount(), 1)
+		}
+		capped += cap(agent.slots)
+	}
+	if capped == 0 {
+		return max(c.workerCount(), 1)
+	}
+	return max(min(c.workerCount(), capped), 1)
+}
+
+// EstimateDuration predicts how long Run takes for numSpecs specs that
+// each take perSpecLatency, assuming specs run in full waves of the
+// coordinator's parallelism
+func (c *Coordinator) EstimateDuration(numSpecs int, perSpecLatency time.Duration) time.Duration {
+	if numSpecs <= 0 {
+		return 0
+	}
+	p := c.parallelism()
+	waves := (numSpecs + p - 1) / p
+	return time.Duration(waves) * perSpecLatency
+}
+
+// RunStream runs specs from in via RunChan and persists each result to w
+// as it completes, flushing per line so a crash loses at most the specs
+// in flight. The returned stats keep latencies in a LatencySketch rather
+// than per result, so memory stays flat regardless of batch size. A
+// write error stops the run.
+func (c *Coordinator) RunStream(ctx context.Context, in <-chan Specification, w *ResultWriter) (RunStats, error) {
+	if err := c.preflight(ctx); err != nil {
+		return RunStats{}, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	var (
+		b        statsBuilder
+		writeErr error
+	)
+	for result := range c.RunChan(ctx, in) {
+		b.add(result)
+		if writeErr != nil {
+			continue
+		}
+		if err := w.Write(result); err != nil {
+			writeErr = err
+		} else if err := w.Flush(); err != nil {
+			writeErr = err
+		}
+		if writeErr != nil {
+			cancel()
+		}
+	}
+
+	stats := b.finish(time.Since(start))
+	c.observer.OnBatchComplete(stats)
+	c.logger.Info("stream finished", "total", stats.Total, "succeeded", stats.Succeeded, "failed", stats.Failed)
+
+	if writeErr != nil {
+		return stats, fmt.Errorf("writing results: %w", writeErr)
+	}
+	return stats, ctx.Err()
+}
+
+// Reverify re-runs only stack-effect verification on prior results'
+// Code against the current specs, matched by SpecID, without
+// regenerating. Results that had not succeeded are returned unchanged.
+// Output order matches results; Flipped marks specs that passed before
+// and fail now.
+func (c *Coordinator) Reverify(ctx context.Context, results []Result, specs []Specification) []Result {
+	byID := make(map[string]Specification, len(specs))
+	for _, spec := range specs {
+		byID[spec.ID] = spec
+	}
+
+	out := make([]Result, len(results))
+	sem := make(chan struct{}, max(c.workerCount(), 1))
+	var wg sync.WaitGroup
+	for i, prev := range results {
+		out[i] = prev
+		if !prev.Success {
+			continue
+		}
+		spec, ok := byID[prev.SpecID]
+		if !ok {
+			out[i].Success, out[i].Flipped = false, true
+			out[i].Error = "reverify: no spec with this ID"
+			continue
+		}
+
+		wg.Add(1)
+		go func(r *Result, spec Specification) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				r.Success, r.Cancelled, r.Error = false, true, ctx.Err().Error()
+				return
+			}
+
+			agent := c.pick(spec)
+			if agent == nil {
+				r.Success, r.Error = false, ErrNoAgents.Error()
+				return
+			}
+			defer c.release(agent)
+
+			start := time.Now()
+			verified, method, err := agent.verifyWithFallback(ctx, r.Code, spec.StackEffect)
+			r.Agent, r.VerifyMethod = agent.URL, method
+			r.LatencyMS = time.Since(start).Seconds() * 1000
+			switch {
+			case err != nil && ctx.Err() != nil:
+				r.Success, r.Cancelled, r.Error = false, true, ctx.Err().Error()
+			case err != nil:
+				// Could not verify either way; not a flip
+				r.Success, r.Error = false, "Verification failed: "+err.Error()
+			case !verified:
+				r.Success, r.Flipped, r.Error = false, true, "Stack effect mismatch"
+			}
+		}(&out[i], spec)
+	}
+	wg.Wait()
+
+	return out
+}
+
+// ProcessOne runs a single spec on an agent chosen the same way Run
+// chooses, with the same observer, logging, and cancellation handling
+func (c *Coordinator) ProcessOne(ctx context.Context, spec Specification) Result {
+	return c.process(ctx, 0, spec)
+}
+
+// Run processes specs in parallel across all agents.
+// Ready specs dispatch highest Priority first, then in submission order.
+// Specs wait for everything in DependsOn to succeed before dispatching,
+// then go out with the dependencies' generated code appended to
+// Definitions, in DependsOn order, so generated code can call those
+// words and still verify;
+// a spec whose dependency failed is skipped. Returns an error without
+// running anything if the dependencies are unknown or form a cycle.
+// Results are returned in submission order so runs can be diffed;
+// channel-based variants deliver in completion order and stay unordered.
+//
+// Cancelling ctx stops the batch early: Run still returns a result for
+// every spec, along with ctx.Err(). Specs that were mid-flight come back
+// Cancelled; specs that had not started come back Skipped.
+func (c *Coordinator) Run(ctx context.Context, specs []Specification) ([]Result, error) {
+	if err := c.preflight(ctx); err != nil && len(specs) > 0 {
+		return nil, err
+	}
+	dependents, pending, err := buildDependencyGraph(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	allResults := make([]Result, 0, len(specs))
+	_, err = c.run(ctx, specs, dependents, pending, c.workerCount(), func(r Result) {
+		allResults = append(allResults, r)
+	})
+	SortResults(allResults, BySubmission)
+	return allResults, err
+}
+
+// run dispatches specs and hands each result to collect as it completes,
+// from a single goroutine. Stats, observer and failed-spec handling live
+// here so Run and RunResults behave the same.
+func (c *Coordinator) run(ctx context.Context, specs []Specification, dependents [][]int, pending []int, workers int, collect func(Result)) (RunStats, error) {
+	// With WithMaxDuration, dispatch stops at the limit and in-flight
+	// specs are cancelled once the grace period is also spent
+	parent, dispatchCtx := ctx, ctx
+	if c.maxDuration > 0 {
+		var cancelDispatch, cancelWork context.CancelFunc
+		dispatchCtx, cancelDispatch = context.WithTimeoutCause(ctx, c.maxDuration, ErrTimeLimit)
+		defer cancelDispatch()
+		ctx, cancelWork = context.WithTimeoutCause(ctx, c.maxDuration+c.gracePeriod, ErrTimeLimit)
+		defer cancelWork()
+	}
+	var notAttempted atomic.Int64
+
+	// Dependents get their dependencies' code, so work on a copy
+	var code map[string]string // Successful spec code by ID, for dependents
+	submitted := specs
+	if slices.ContainsFunc(dependents, func(d []int) bool { return len(d) > 0 }) {
+		specs = slices.Clone(specs)
+		code = make(map[string]string)
+	}
+
+	// AutoTuneWorkers probes leave no trace in the run's output files
+	checkpointPath, failedSpecsPath := c.checkpointPath, c.failedSpecsPath
+	if probing(ctx) {
+		checkpointPath, failedSpecsPath = "", ""
+	}
+
+	var checkpoint *json.Encoder
+	var checkpointErr error
+	if checkpointPath != "" {
+		file, err := openCheckpoint(checkpointPath)
+		if err != nil {
+			return RunStats{}, fmt.Errorf("open checkpoint: %w", err)
+		}
+		defer file.Close()
+		checkpoint = json.NewEncoder(file)
+	}
+	hashes, _ := ctx.Value(specHashesKey{}).([]string)
+
+	c.logger.Info("run started", "specs", len(specs), "agents", len(c.LiveAgents()))
+	start := time.Now()
+	c.throughput.Reset(start)
+
+	// Result channel (buffered)
+	results := make(chan Result, len(specs))
+
+	// Workers pull ready specs highest Priority first
+	queue := newSpecQueue(specs)
+	if c.shuffle {
+		queue.heap.rank = c.rng.perm(len(specs))
+	}
+	dispatch := func(i int) {
+		c.counters.queued.Add(1)
+		queue.put(i)
+	}
+	defer queue.close()
+
+	for w := 0; w < min(max(workers, 1), len(specs)); w++ {
+		go func() {
+			for {
+				i, ok := queue.take()
+				if !ok {
+					return
+				}
+				c.counters.queued.Add(-1)
+				if dispatchCtx.Err() != nil {
+					notAttempted.Add(1)
+					skip := Result{
+						SpecID:   specs[i].ID,
+						Success:  false,
+						Skipped:  true,
+						Error:    "not started: " + context.Cause(dispatchCtx).Error(),
+						Category: FailSkipped,
+						Index:    i,
+						Labels:   specs[i].Labels,
+					}
+					c.count(skip)
+					results <- skip
+					continue
+				}
+				results <- c.process(ctx, i, specs[i])
+			}
+		}()
+	}
+
+	// Specs without dependencies start immediately
+	for i := range specs {
+		if pending[i] == 0 {
+			dispatch(i)
+		} else {
+			c.counters.blocked.Add(1)
+		}
+	}
+
+	// Collect results, releasing or skipping dependents as they finish
+	var (
+		stats  statsBuilder
+		failed []Result // Kept for the failed-specs file
+	)
+	completed := 0
+	skipped := make([]bool, len(specs))
+
+	var record func(result Result)
+	record = func(result Result) {
+		collect(result)
+		stats.add(result)
+		if checkpoint != nil && checkpointErr == nil {
+			// One unbuffered write per result: a crash should lose at most
+			// the line in flight
+			line := checkpointLine{Result: result}
+			if hashes != nil {
+				line.SpecHash = hashes[result.Index]
+			} else {
+				line.SpecHash = SpecHash(submitted[result.Index])
+			}
+			if checkpointErr = checkpoint.Encode(line); checkpointErr != nil {
+				c.logger.Warn("checkpoint disabled", "path", checkpointPath, "err", checkpointErr)
+			}
+		}
+		if !result.Success && failedSpecsPath != "" {
+			failed = append(failed, result)
+		}
+		completed++
+		c.throughput.Record(time.Now())
+		if c.onResult != nil {
+			c.onResult(completed, len(specs), result)
+		}
+
+		if c.progressEvery > 0 && (completed%c.progressEvery == 0 || completed == len(specs)) {
+			c.reportProgress(newProgress(completed, len(specs), time.Since(start)))
+		}
+
+		if result.Success && len(dependents[result.Index]) > 0 {
+			code[result.SpecID] = result.Code
+		}
+		for _, d := range dependents[result.Index] {
+			if skipped[d] {
+				continue
+			}
+			if !result.Success {
+				skipped[d] = true
+				c.counters.blocked.Add(-1)
+				skip := Result{
+					SpecID:   specs[d].ID,
+					Success:  false,
+					Skipped:  true,
+					Error:    fmt.Sprintf("dependency %s failed", result.SpecID),
+					Category: FailSkipped,
+					Index:    d,
+					Labels:   specs[d].Labels,
+				}
+				c.observer.OnSpecComplete(skip)
+				c.count(skip)
+				record(skip)
+				continue
+			}
+			pending[d]--
+			if pending[d] == 0 {
+				c.counters.blocked.Add(-1)
+				defs := slices.Clip(specs[d].Definitions)
+				for _, dep := range specs[d].DependsOn {
+					defs = append(defs, code[dep])
+				}
+				specs[d].Definitions = defs
+				dispatch(d)
+			}
+		}
+	}
+
+	for completed < len(specs) {
+		record(<-results)
+	}
+
+	runStats := stats.finish(time.Since(start))
+	c.logger.Info("run complete",
+		"elapsed", runStats.Elapsed,
+		"succeeded", runStats.Succeeded,
+		"failed", runStats.Failed,
+		"specs_per_second", runStats.Throughput,
+	)
+
+	c.observer.OnBatchComplete(runStats)
+
+	if failedSpecsPath != "" {
+		if err := WriteFailedSpecs(failedSpecsPath, submitted, failed); err != nil {
+			return runStats, fmt.Errorf("write failed specs: %w", err)
+		}
+	}
+	if checkpointErr != nil {
+		return runStats, fmt.Errorf("write checkpoint: %w", checkpointErr)
+	}
+
+	if parent.Err() == nil && context.Cause(dispatchCtx) == ErrTimeLimit {
+		n := notAttempted.Load()
+		if n > 0 || runStats.Cancelled > 0 {
+			c.logger.Warn("time limit reached", "limit", c.maxDuration, "not_attempted", n, "cancelled", runStats.Cancelled)
+			return runStats, fmt.Errorf("%w, %d specs not attempted", ErrTimeLimit, n)
+		}
+	}
+	return runStats, parent.Err()
+}
+
+// ErrTimeLimit is returned by Run when WithMaxDuration's limit cut it
+// short; completed results are still returned
+var ErrTimeLimit = errors.New("time limit reached")
+
+// DefaultGracePeriod is how long in-flight specs may run past the limit
+// set with WithMaxDuration before they are cancelled
+const DefaultGracePeriod = 5 * time.Second
+
+// WithMaxDuration caps a Run at d: no spec starts after d, specs still in
+// flight get grace to finish and are then marked cancelled. Specs never
+// started come back skipped, and Run returns ErrTimeLimit.
+func WithMaxDuration(d, grace time.Duration) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.maxDuration = d
+		c.gracePeriod = grace
+	}
+}
+
+// Soak runs specs back to back until ctx is cancelled, starting a new
+// iteration every interval (immediately if the last one overran) and
+// sending each iteration's RunStats, to expose latency drift, leaks and
+// agents dropping out over long runs. Iterations share the agents' HTTP
+// clients, so connections are reused. An iteration cut short by ctx is
+// not sent. The channel closes when ctx is done, or at once if specs
+// have a dependency error or the pool is empty (logged).
+func (c *Coordinator) Soak(ctx context.Context, specs []Specification, interval time.Duration) <-chan RunStats {
+	out := make(chan RunStats, 1)
+
+	go func() {
+		defer close(out)
+		if err := c.preflight(ctx); err != nil {
+			c.logger.Error("soak", "err", err)
+			return
+		}
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for iteration := 1; ; iteration++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			start := time.Now()
+
+			// 1. The dependency graph is consumed by run, so rebuild it
+			dependents, pending, err := buildDependencyGraph(specs)
+			if err != nil {
+				c.logger.Error("soak", "err", err)
+				return
+			}
+
+			// 2. Run the batch, discarding results
+			stats, err := c.run(ctx, specs, dependents, pending, c.workerCount(), func(Result) {})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				c.logger.Warn("soak iteration", "iteration", iteration, "err", err)
+			}
+
+			// 3. Emit and schedule the next iteration
+			select {
+			case out <- stats:
+			case <-ctx.Done():
+				return
+			}
+			timer.Reset(max(interval-time.Since(start), 0))
+		}
+	}()
+	return out
+}
+
+// TuneMeasurement is one concurrency level probed by AutoTuneWorkers
+type TuneMeasurement struct {
+	Workers      int     `json:"workers"`
+	Throughput   float64 `json:"throughput"` // Specs per second
+	P99LatencyMS float64 `json:"p99_latency_ms"`
+}
+
+// AutoTuneWorkers hill-climbs the worker count: it runs sampleSpecs at
+// one worker per live agent, then keeps doubling while throughput gains
+// at least 5% and p99 latency stays within 1.5x of the best level's.
+// The best level becomes the coordinator's worker count (as with
+// WithWorkers) and is returned with every measurement taken. Probes skip
+// any Cache and FlightGroup, so every level times real generations, and
+// leave the checkpoint and failed-specs files alone. Call it before
+// starting runs, ideally after Warmup; a sample of a few hundred specs
+// gives stable numbers.
+func (c *Coordinator) AutoTuneWorkers(ctx context.Context, sampleSpecs []Specification) (int, []TuneMeasurement, error) {
+	const (
+		minGain      = 1.05 // Throughput a doubling must add to be kept
+		maxP99Growth = 1.5
+	)
+	if err := c.preflight(ctx); err != nil {
+		return 0, nil, err
+	}
+	if len(sampleSpecs) == 0 {
+		return 0, nil, errors.New("autotune: no sample specs")
+	}
+
+	ctx = context.WithValue(ctx, probeKey{}, true)
+	probe := func(workers int) (TuneMeasurement, error) {
+		dependents, pending, err := buildDependencyGraph(sampleSpecs)
+		if err != nil {
+			return TuneMeasurement{}, err
+		}
+		stats, err := c.run(ctx, sampleSpecs, dependents, pending, workers, func(Result) {})
+		if err != nil {
+			return TuneMeasurement{}, err
+		}
+		m := TuneMeasurement{Workers: workers, Throughput: stats.Throughput, P99LatencyMS: stats.P99LatencyMS}
+		c.logger.Info("autotune probe", "workers", m.Workers, "specs_per_second", m.Throughput, "p99_ms", m.P99LatencyMS)
+		return m, nil
+	}
+
+	workers := max(len(c.LiveAgents()), 1)
+	best, err := probe(workers)
+	if err != nil {
+		return 0, nil, fmt.Errorf("autotune: %w", err)
+	}
+	measurements := []TuneMeasurement{best}
+
+	// More workers than sample specs would measure nothing new
+	for workers*2 <= len(sampleSpecs) {
+		workers *= 2
+		m, err := probe(workers)
+		if err != nil {
+			return best.Workers, measurements, fmt.Errorf("autotune: %w", err)
+		}
+		measurements = append(measurements, m)
+		if m.Throughput < best.Throughput*minGain || m.P99LatencyMS > best.P99LatencyMS*maxP99Growth {
+			break
+		}
+		best = m
+	}
+
+	c.workers.Store(int64(best.Workers))
+	return best.Workers, measurements, nil
+}
+
+// Stream is Run delivering results on a channel in completion order as
+// they finish, so post-processing can start before the batch ends.
+// Dependencies and priorities behave as in Run. Both channels close once
+// every spec has a result; the error channel then carries at most one
+// error, whatever Run would have returned: a dependency cycle, a failed
+// checkpoint write, ErrTimeLimit or ctx.Err(). Consumers must drain the
+// results or cancel ctx; results not yet received when ctx is cancelled
+// are dropped.
+func (c *Coordinator) Stream(ctx context.Context, specs []Specification) (<-chan Result, <-chan error) {
+	out := make(chan Result, max(c.workerCount(), 1))
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(out)
+		if err := c.preflight(ctx); err != nil && len(specs) > 0 {
+			errc <- err
+			return
+		}
+		dependents, pending, err := buildDependencyGraph(specs)
+		if err != nil {
+			errc <- err
+			return
+		}
+		_, err = c.run(ctx, specs, dependents, pending, c.workerCount(), func(r Result) {
+			select {
+			case out <- r:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return out, errc
+}
+
+// RunChan streams specs from in through a pool of workers so the full
+// batch never has to be held in memory. Results arrive in completion order.
+// The returned channel closes after in is closed and drained, or once ctx
+// is cancelled. DependsOn is ignored since a stream cannot look ahead.
+//
+// Consumers must either drain the channel or cancel ctx: workers block
+// sending once its buffer fills. Cancelling unblocks them, aborts specs
+// in flight and stops reading in, so whoever feeds in should also select
+// on ctx.Done. Results not yet received when ctx is cancelled are dropped.
+func (c *Coordinator) RunChan(ctx context.Context, in <-chan Specification) <-chan Result {
+	type job struct {
+		index int
+		spec  Specification
+	}
+
+	jobs := make(chan job)
+	out := make(chan Result, c.workerCount())
+	c.throughput.Reset(time.Now())
+
+	// Number specs in arrival order so Index matches submission order
+	go func() {
+		defer close(jobs)
+		for i := 0; ; i++ {
+			var spec Specification
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case spec, ok = <-in:
+				if !ok {
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- job{index: i, spec: spec}:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < max(c.workerCount(), 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				result := c.process(ctx, j.index, j.spec)
+				c.throughput.Record(time.Now())
+
+				select {
+				case <-ctx.Done():
+					return
+				case out <- result:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
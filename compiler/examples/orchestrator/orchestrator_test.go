@@ -0,0 +1,968 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+	"github.com/quivent/fifth/compiler/examples/server"
+)
+
+// square is a spec the Go agent answers from its pattern table
+var square = orchestrator.Specification{
+	ID:          "square",
+	Word:        "square",
+	StackEffect: "( n -- n² )",
+	PatternID:   "DUP_TRANSFORM_001",
+	TestCases:   []orchestrator.TestCase{{Input: []int{4}, Output: []int{16}}},
+}
+
+// specsN returns n copies of square with distinct IDs
+func specsN(n int) []orchestrator.Specification {
+	specs := make([]orchestrator.Specification, n)
+	for i := range specs {
+		specs[i] = square
+		specs[i].ID = fmt.Sprintf("square-%d", i)
+	}
+	return specs
+}
+
+// newAgentServer serves the Go agent, over HTTP/1.1 and h2c as
+// fifth-agent does, and counts the connections opened to it
+func newAgentServer(t testing.TB, h http.Handler) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	if h == nil {
+		h = server.New()
+	}
+	var conns atomic.Int64
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+func newAgent(t testing.TB, url string, opts ...orchestrator.AgentOption) *orchestrator.FastForthAgent {
+	t.Helper()
+	agent, err := orchestrator.NewFastForthAgentURL(url, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+// BenchmarkTransport compares net/http's default of 2 idle connections
+// per host with DefaultTransportOptions, and with HTTP/2 multiplexing,
+// when 64 goroutines share one agent. The conns metric counts TCP
+// connections the agent accepted.
+func BenchmarkTransport(b *testing.B) {
+	cases := []struct {
+		name string
+		opts orchestrator.TransportOptions
+	}{
+		{"net-http-default", orchestrator.TransportOptions{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost,
+			IdleConnTimeout:     90 * time.Second,
+			KeepAlive:           30 * time.Second,
+		}},
+		{"default-options", orchestrator.DefaultTransportOptions},
+		{"h2c", func() orchestrator.TransportOptions {
+			o := orchestrator.DefaultTransportOptions
+			o.UnencryptedHTTP2 = true
+			return o
+		}()},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			srv, conns := newAgentServer(b, nil)
+			agent := newAgent(b, srv.URL, orchestrator.WithTransportOptions(tc.opts))
+			ctx := context.Background()
+			if _, err := agent.ValidateSpec(ctx, square); err != nil {
+				b.Fatal(err)
+			}
+
+			// RunParallel starts parallelism × GOMAXPROCS goroutines
+			b.SetParallelism(max(1, 64/runtime.GOMAXPROCS(0)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := agent.ValidateSpec(ctx, square); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(conns.Load()), "conns")
+		})
+	}
+}
+
+func TestRunRejectsDuplicateDependency(t *testing.T) {
+	srv, _ := newAgentServer(t, nil)
+	c := orchestrator.NewCoordinatorWithAgents([]*orchestrator.FastForthAgent{newAgent(t, srv.URL)})
+
+	base := specsN(3)
+	base[1].ID = base[0].ID
+	tests := []struct {
+		name  string
+		specs func() []orchestrator.Specification
+	}{
+		{"dependency target", func() []orchestrator.Specification {
+			specs := slices.Clone(base)
+			specs[2].DependsOn = []string{specs[0].ID}
+			return specs
+		}},
+		{"dependent", func() []orchestrator.Specification {
+			specs := slices.Clone(base)
+			specs[0].DependsOn = []string{specs[2].ID}
+			return specs
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := c.Run(context.Background(), tc.specs())
+			if err == nil || !strings.Contains(err.Error(), "duplicate spec ID") {
+				t.Fatalf("Run error = %v, want duplicate spec ID", err)
+			}
+		})
+	}
+
+	// Duplicates no edge refers to still run
+	results, err := c.Run(context.Background(), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(base) {
+		t.Fatalf("got %d results, want %d", len(results), len(base))
+	}
+}
+
+// TestRunDependencies submits a dependent spec before its dependencies
+// and checks the Go agent gets their generated code in DependsOn order,
+// and generates a word calling one of them
+func TestRunDependencies(t *testing.T) {
+	agentServer := server.New()
+	var mu sync.Mutex
+	var generated []string                   // Spec IDs in /generate order
+	definitions := make(map[string][]string) // By spec ID, as /generate got them
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/generate" {
+			data, _ := io.ReadAll(r.Body)
+			var spec orchestrator.Specification
+			if err := json.Unmarshal(data, &spec); err != nil {
+				t.Errorf("/generate body %s: %v", data, err)
+			}
+			mu.Lock()
+			generated = append(generated, spec.ID)
+			definitions[spec.ID] = spec.Definitions
+			mu.Unlock()
+			r.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		agentServer.ServeHTTP(w, r)
+	}))
+	c := orchestrator.NewCoordinatorWithAgents([]*orchestrator.FastForthAgent{newAgent(t, srv.URL)},
+		orchestrator.WithWorkers(4))
+
+	inc := orchestrator.Specification{
+		ID:          "inc",
+		Word:        "inc",
+		StackEffect: "( n -- n+1 )",
+		TestCases:   []orchestrator.TestCase{{Input: []int{4}, Output: []int{5}}},
+	}
+	// No pattern: the search has to call a dependency to pass the tests
+	nextSquare := orchestrator.Specification{
+		ID:          "next-square",
+		Word:        "next-square",
+		StackEffect: "( n -- m )",
+		DependsOn:   []string{square.ID, inc.ID},
+		TestCases: []orchestrator.TestCase{
+			{Input: []int{3}, Output: []int{16}},
+			{Input: []int{-2}, Output: []int{1}},
+		},
+	}
+	results, err := c.Run(context.Background(), []orchestrator.Specification{nextSquare, inc, square})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := make(map[string]string)
+	for _, r := range results {
+		if !r.Success {
+			t.Fatalf("%s: %s", r.SpecID, r.Error)
+		}
+		code[r.SpecID] = r.Code
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if i := slices.Index(generated, nextSquare.ID); i != len(generated)-1 {
+		t.Errorf("/generate order %q, want %s last", generated, nextSquare.ID)
+	}
+	if want := []string{code[square.ID], code[inc.ID]}; !slices.Equal(definitions[nextSquare.ID], want) {
+		t.Errorf("%s got definitions %q, want %q in DependsOn order", nextSquare.ID, definitions[nextSquare.ID], want)
+	}
+	if len(definitions[square.ID]) > 0 || len(definitions[inc.ID]) > 0 {
+		t.Errorf("specs without dependencies got definitions %q", definitions)
+	}
+	body := strings.Fields(strings.TrimSuffix(code[nextSquare.ID][strings.Index(code[nextSquare.ID], "\n")+1:], ";"))
+	if !slices.Contains(body, square.Word) {
+		t.Errorf("%s = %q, want a definition calling %s", nextSquare.ID, code[nextSquare.ID], square.Word)
+	}
+}
+
+func TestAdaptiveRoutingAvoidsFailingAgent(t *testing.T) {
+	good, _ := newAgentServer(t, nil)
+	bad, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	goodAgent, badAgent := newAgent(t, good.URL), newAgent(t, bad.URL)
+	c := orchestrator.NewCoordinatorWithAgents(
+		[]*orchestrator.FastForthAgent{goodAgent, badAgent},
+		orchestrator.WithAdaptiveRouting(0.5),
+		orchestrator.WithRandSource(rand.NewPCG(1, 2)),
+		orchestrator.WithWorkers(1),
+	)
+
+	results, err := c.Run(context.Background(), specsN(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := 0
+	for _, r := range results {
+		if !r.Success {
+			failed++
+		}
+	}
+	goodEMA, _ := goodAgent.LatencyEMA()
+	badEMA, _ := badAgent.LatencyEMA()
+	if badEMA <= goodEMA {
+		t.Errorf("failing agent EMA %.2fms <= healthy agent EMA %.2fms", badEMA, goodEMA)
+	}
+	if failed > len(results)/4 {
+		t.Errorf("%d of %d specs went to the failing agent", failed, len(results))
+	}
+}
+
+func TestRunCancelReturnsPartialResults(t *testing.T) {
+	const n, k, workers = 10, 4, 2
+
+	// The first k generations answer; later ones hang until the client
+	// gives up, and the run is cancelled once every worker is stuck
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent := server.New()
+	var generated, stuck atomic.Int64
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/generate" && generated.Add(1) > k {
+			if stuck.Add(1) == workers {
+				cancel()
+			}
+			// The server notices the client leaving once the body is read
+			io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+			return
+		}
+		agent.ServeHTTP(w, r)
+	}))
+	c := orchestrator.NewCoordinatorWithAgents(
+		[]*orchestrator.FastForthAgent{newAgent(t, srv.URL)},
+		orchestrator.WithWorkers(workers),
+	)
+
+	results, err := c.Run(ctx, specsN(n))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run error = %v, want context.Canceled", err)
+	}
+	if len(results) != n {
+		t.Fatalf("got %d results, want one per spec (%d)", len(results), n)
+	}
+	var succeeded, cancelled, skipped int
+	for i, r := range results {
+		if r.Index != i {
+			t.Errorf("results[%d].Index = %d, want submission order", i, r.Index)
+		}
+		switch {
+		case r.Success:
+			succeeded++
+		case r.Cancelled:
+			cancelled++
+		case r.Skipped:
+			skipped++
+		default:
+			t.Errorf("spec %s failed: %s", r.SpecID, r.Error)
+		}
+	}
+	if succeeded != k || cancelled != workers || skipped != n-k-workers {
+		t.Errorf("succeeded/cancelled/skipped = %d/%d/%d, want %d/%d/%d",
+			succeeded, cancelled, skipped, k, workers, n-k-workers)
+	}
+}
+
+func TestValidateAllBoundsConcurrency(t *testing.T) {
+	const workers = 3
+	agent := server.New()
+	var active, peak atomic.Int64
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(time.Millisecond)
+		agent.ServeHTTP(w, r)
+	}))
+	c := orchestrator.NewCoordinatorWithAgents(
+		[]*orchestrator.FastForthAgent{newAgent(t, srv.URL)},
+		orchestrator.WithWorkers(workers),
+	)
+
+	specs := specsN(50)
+	specs[7].StackEffect = ""
+	valid, err := c.ValidateAll(context.Background(), specs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range valid {
+		if ok != (i != 7) {
+			t.Errorf("valid[%d] = %v", i, ok)
+		}
+	}
+	if p := peak.Load(); p > workers {
+		t.Errorf("%d batches in flight at once, want at most %d", p, workers)
+	}
+}
+
+// gzipResponses compresses every response from h, as a proxy in front
+// of an agent might
+func gzipResponses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(rec.Body.Bytes())
+		zw.Close()
+		maps.Copy(w.Header(), rec.Header())
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(rec.Code)
+		w.Write(buf.Bytes())
+	})
+}
+
+func TestGzipResponses(t *testing.T) {
+	srv, _ := newAgentServer(t, gzipResponses(server.New()))
+	agent := newAgent(t, srv.URL)
+
+	for name, a := range map[string]*orchestrator.FastForthAgent{
+		"json":   agent,
+		"stream": newAgent(t, srv.URL, orchestrator.WithStreamingGenerate()),
+	} {
+		r := a.ProcessSpec(context.Background(), square)
+		if !r.Success || r.Code == "" {
+			t.Errorf("%s: ProcessSpec over gzip: success=%v code=%q error=%s", name, r.Success, r.Code, r.Error)
+		}
+	}
+
+	// Error bodies are decompressed too; the Go agent has no async jobs
+	_, err := agent.SubmitSpec(context.Background(), square)
+	var se *orchestrator.StatusError
+	if !errors.As(err, &se) || !strings.Contains(se.Body, "not found") {
+		t.Fatalf("SubmitSpec error = %v, want a StatusError with a readable body", err)
+	}
+}
+
+func TestCheckArity(t *testing.T) {
+	tests := []struct {
+		effect string
+		cases  []orchestrator.TestCase
+		want   string // Error substring; empty for none
+	}{
+		{"( n -- n² )", []orchestrator.TestCase{{Input: []int{2}, Output: []int{4}}}, ""},
+		{"( a b -- a+b )", []orchestrator.TestCase{
+			{Input: []int{1, 2}, Output: []int{3}},
+			{Input: []int{1}, Output: []int{1}},
+		}, "test case 2: 1 inputs"},
+		{"( n -- )", []orchestrator.TestCase{{Input: []int{1}, Output: []int{1}}}, "test case 1: 1 outputs"},
+		{"n -- n", nil, ""}, // Nothing to check without test cases
+	}
+	for _, tc := range tests {
+		err := orchestrator.CheckArity(orchestrator.Specification{StackEffect: tc.effect, TestCases: tc.cases})
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("CheckArity(%q) = %v, want nil", tc.effect, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("CheckArity(%q) = %v, want %q", tc.effect, err, tc.want)
+		}
+	}
+}
+
+func TestSpecificationTimeoutJSON(t *testing.T) {
+	spec := square
+	spec.Timeout = orchestrator.Duration(1500 * time.Millisecond)
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"timeout":"1.5s"`) {
+		t.Errorf("Marshal = %s, want timeout as a duration string", data)
+	}
+	var back orchestrator.Specification
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Timeout != spec.Timeout {
+		t.Errorf("round trip Timeout = %v, want %v", back.Timeout, spec.Timeout)
+	}
+
+	for _, bad := range []string{`{"timeout": 5000}`, `{"timeout": "5 secs"}`} {
+		if err := json.Unmarshal([]byte(bad), &back); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestDebugBodies(t *testing.T) {
+	var attempts atomic.Int64
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if attempts.Add(1) == 1 {
+			http.Error(w, "first attempt: overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "second attempt: not json")
+	}))
+	agent := newAgent(t, srv.URL,
+		orchestrator.WithRetry(orchestrator.RetryPolicy{MaxAttempts: 2}),
+		orchestrator.WithDebugBodies(1024),
+	)
+
+	_, err := agent.ValidateSpec(context.Background(), square)
+	var de *orchestrator.DebugError
+	if !errors.As(err, &de) {
+		t.Fatalf("ValidateSpec error = %v, want a *DebugError", err)
+	}
+	sent, _ := json.Marshal(square)
+	if de.Request != string(sent) {
+		t.Errorf("DebugError.Request = %s, want the sent spec %s", de.Request, sent)
+	}
+	if !strings.HasPrefix(de.Response, "second attempt") {
+		t.Errorf("DebugError.Response = %q, want the failing attempt's body", de.Response)
+	}
+
+	// The request side is truncated to the limit too
+	agent = newAgent(t, srv.URL, orchestrator.WithDebugBodies(8))
+	_, err = agent.ValidateSpec(context.Background(), square)
+	if !errors.As(err, &de) || de.Request != string(sent[:8]) || len(de.Response) > 8 {
+		t.Errorf("with an 8-byte limit got request %q, response %q", de.Request, de.Response)
+	}
+}
+
+func TestFallbackSkipsInvalidSpecs(t *testing.T) {
+	agent := server.New()
+	primary, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/generate" {
+			http.Error(w, "model unavailable", http.StatusInternalServerError)
+			return
+		}
+		agent.ServeHTTP(w, r)
+	}))
+	fallback, fallbackConns := newAgentServer(t, nil)
+	c := orchestrator.NewCoordinatorWithAgents(
+		[]*orchestrator.FastForthAgent{newAgent(t, primary.URL)},
+		orchestrator.WithFallback(newAgent(t, fallback.URL)),
+	)
+
+	invalid := square
+	invalid.ID, invalid.StackEffect = "invalid", ""
+	results, err := c.Run(context.Background(), []orchestrator.Specification{invalid, square})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; r.Success || r.Fallback || r.Category != orchestrator.FailInvalidSpec {
+		t.Errorf("invalid spec: success=%v fallback=%v category=%s, want an invalid_spec failure on the primary",
+			r.Success, r.Fallback, r.Category)
+	}
+	if r := results[1]; !r.Success || !r.Fallback || r.Agent != fallback.URL {
+		t.Errorf("square: success=%v fallback=%v agent=%s, want success on the fallback", r.Success, r.Fallback, r.Agent)
+	}
+	if n := fallbackConns.Load(); n != 1 {
+		t.Errorf("fallback saw %d connections, want 1", n)
+	}
+}
+
+// TestFallbackCreditsPrimary checks that a spec the fallback rescues
+// still counts against its primary, and that both attempts share the
+// spec's Timeout
+func TestFallbackCreditsPrimary(t *testing.T) {
+	refuse := func(orchestrator.Specification) (string, []string, error) {
+		return "", nil, errors.New("model unavailable")
+	}
+	primary := orchestrator.NewAgent("primary", &orchestrator.MockAgent{Latency: 50 * time.Millisecond, Generate: refuse})
+	fallback := orchestrator.NewAgent("fallback", &orchestrator.MockAgent{Latency: 50 * time.Millisecond})
+	c := orchestrator.NewCoordinatorWithAgents([]*orchestrator.FastForthAgent{primary}, orchestrator.WithFallback(fallback))
+
+	// The primary spends 100ms failing; the fallback's three stages would
+	// take 150ms, within the Timeout on their own but not after that
+	slow, fast := square, square
+	slow.ID, slow.Timeout = "slow", orchestrator.Duration(200*time.Millisecond)
+	fast.ID = "fast"
+	results, err := c.Run(context.Background(), []orchestrator.Specification{fast, slow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; !r.Success || !r.Fallback || r.Agent != "fallback" {
+		t.Errorf("fast: success=%v fallback=%v agent=%s, want success on the fallback", r.Success, r.Fallback, r.Agent)
+	}
+	if r := results[1]; r.Success || !r.TimedOut || !r.Fallback || r.Category != orchestrator.FailTimeout {
+		t.Errorf("slow: success=%v timed out=%v fallback=%v category=%s error=%q, want a timeout on the fallback",
+			r.Success, r.TimedOut, r.Fallback, r.Category, r.Error)
+	}
+
+	if ema, ok := primary.LatencyEMA(); !ok || ema < 100*orchestrator.EMAFailurePenalty {
+		t.Errorf("primary EMA = %v, %v; want its failures recorded", ema, ok)
+	}
+	var metrics bytes.Buffer
+	if err := c.WriteMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`fifth_agent_specs_total{agent="primary",result="failure"} 2`,
+		`fifth_agent_specs_total{agent="fallback",result="success"} 1`,
+		`fifth_agent_specs_total{agent="fallback",result="failure"} 1`,
+		`fifth_specs_total{result="success"} 1`,
+		`fifth_specs_total{result="failure"} 1`,
+	} {
+		if !strings.Contains(metrics.String(), want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
+
+// coordinatorGoroutines counts goroutines started by Coordinator methods
+func coordinatorGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	n := 0
+	for g := range strings.SplitSeq(string(buf), "\n\n") {
+		if strings.Contains(g, "orchestrator.(*Coordinator)") {
+			n++
+		}
+	}
+	return n
+}
+
+// waitForNoCoordinatorGoroutines fails t if Coordinator goroutines are
+// still running a second from now
+func waitForNoCoordinatorGoroutines(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for coordinatorGoroutines() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d coordinator goroutines still running after cancel", coordinatorGoroutines())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunChanCancelStopsWorkers(t *testing.T) {
+	srv, _ := newAgentServer(t, nil)
+	c := orchestrator.NewCoordinatorWithAgents(
+		[]*orchestrator.FastForthAgent{newAgent(t, srv.URL)},
+		orchestrator.WithWorkers(4),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// An endless feed, as from a queue
+	in := make(chan orchestrator.Specification)
+	go func() {
+		defer close(in)
+		for i := 0; ; i++ {
+			spec := square
+			spec.ID = fmt.Sprint(i)
+			select {
+			case in <- spec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Take a few results, then walk away with the workers' sends
+	// blocked on a full buffer
+	out := c.RunChan(ctx, in)
+	for range 3 {
+		if r := <-out; !r.Success {
+			t.Fatalf("spec %s failed: %s", r.SpecID, r.Error)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if coordinatorGoroutines() == 0 {
+		t.Fatal("no coordinator goroutines found before cancel")
+	}
+	cancel()
+	waitForNoCoordinatorGoroutines(t)
+}
+
+func TestAutoTuneWorkersLeavesNoTrace(t *testing.T) {
+	agent := server.New(server.WithSearchDepth(0))
+	var generated atomic.Int64
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/generate" {
+			generated.Add(1)
+		}
+		agent.ServeHTTP(w, r)
+	}))
+	dir := t.TempDir()
+	checkpoint, failedSpecs := filepath.Join(dir, "checkpoint.jsonl"), filepath.Join(dir, "failed.json")
+	c := orchestrator.NewCoordinatorWithAgents(
+		[]*orchestrator.FastForthAgent{newAgent(t, srv.URL, orchestrator.WithCache(orchestrator.NewMemoryCache(100)))},
+		orchestrator.WithCheckpoint(checkpoint),
+		orchestrator.WithFailedSpecsFile(failedSpecs),
+	)
+
+	sample := specsN(8)
+	sample[0].PatternID = "NO_SUCH_PATTERN" // Fails generation
+	workers, measurements, err := c.AutoTuneWorkers(context.Background(), sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) == 0 || workers < 1 {
+		t.Fatalf("AutoTuneWorkers = %d, %v", workers, measurements)
+	}
+	if got, want := generated.Load(), int64(len(measurements)*len(sample)); got != want {
+		t.Errorf("%d generate calls for %d probes of %d specs, want %d: probes hit the cache",
+			got, len(measurements), len(sample), want)
+	}
+	for _, path := range []string{checkpoint, failedSpecs} {
+		if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+			t.Errorf("probes wrote %s:\n%s", filepath.Base(path), data)
+		}
+	}
+}
+
+func TestCoordinatorWithMockAgents(t *testing.T) {
+	flaky := &orchestrator.MockAgent{
+		Generate: func(spec orchestrator.Specification) (string, []string, error) {
+			if spec.ID == "square-3" {
+				return "", nil, errors.New("model refused")
+			}
+			return ": square dup * ;", nil, nil
+		},
+	}
+	steady := &orchestrator.MockAgent{Latency: time.Millisecond}
+	c := orchestrator.NewCoordinatorWithAgents([]*orchestrator.FastForthAgent{
+		orchestrator.NewAgent("flaky", flaky),
+		orchestrator.NewAgent("steady", steady),
+	}, orchestrator.WithWorkers(4))
+
+	results, err := c.Run(context.Background(), specsN(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	perAgent := make(map[string]int)
+	for _, r := range results {
+		perAgent[r.Agent]++
+		wantSuccess := r.SpecID != "square-3" || r.Agent == "steady"
+		if r.Success != wantSuccess {
+			t.Errorf("%s on %s: success=%v error=%q", r.SpecID, r.Agent, r.Success, r.Error)
+		}
+		if !r.Success && r.Category != orchestrator.FailGeneration {
+			t.Errorf("%s: category %s, want %s", r.SpecID, r.Category, orchestrator.FailGeneration)
+		}
+	}
+	if perAgent["flaky"] != 5 || perAgent["steady"] != 5 {
+		t.Errorf("specs per agent = %v, want 5 each from round-robin", perAgent)
+	}
+	// Validate, generate and verify per spec, short of verify on a failure
+	if got := flaky.Calls() + steady.Calls(); got < 28 || got > 30 {
+		t.Errorf("mock agents saw %d calls for 10 specs, want 28-30", got)
+	}
+}
+
+func TestInProcessAgent(t *testing.T) {
+	agent := orchestrator.NewAgent("in-process", &orchestrator.InProcessAgent{
+		Generate: func(_ context.Context, spec orchestrator.Specification) (string, []string, error) {
+			code, err := server.New().Generate(spec)
+			return code, nil, err
+		},
+	})
+	if r := agent.ProcessSpec(context.Background(), square); !r.Success || r.Code == "" {
+		t.Fatalf("ProcessSpec: success=%v code=%q error=%s", r.Success, r.Code, r.Error)
+	}
+
+	bad := square
+	bad.StackEffect = "( n n"
+	if r := agent.ProcessSpec(context.Background(), bad); r.Success || r.Category != orchestrator.FailInvalidSpec {
+		t.Errorf("unparseable stack effect: success=%v category=%s", r.Success, r.Category)
+	}
+}
+
+// Every implementation satisfies Agent, ProcessSpec included
+var (
+	_ orchestrator.Agent = (*orchestrator.FastForthAgent)(nil)
+	_ orchestrator.Agent = (*orchestrator.GRPCAgent)(nil)
+	_ orchestrator.Agent = (*orchestrator.InProcessAgent)(nil)
+	_ orchestrator.Agent = (*orchestrator.MockAgent)(nil)
+)
+
+// TestCoordinatorWithAnyAgent hands a Coordinator unwrapped Agent
+// implementations next to an HTTP agent, as a pool, added agent and
+// fallback
+func TestCoordinatorWithAnyAgent(t *testing.T) {
+	srv, _ := newAgentServer(t, nil)
+	mock := &orchestrator.MockAgent{}
+	inProcess := &orchestrator.InProcessAgent{
+		Generate: func(_ context.Context, spec orchestrator.Specification) (string, []string, error) {
+			code, err := server.New().Generate(spec)
+			return code, nil, err
+		},
+	}
+	refuse := &orchestrator.MockAgent{Generate: func(orchestrator.Specification) (string, []string, error) {
+		return "", nil, errors.New("model refused")
+	}}
+	c := orchestrator.NewCoordinatorWithAgents([]orchestrator.Agent{mock, newAgent(t, srv.URL), inProcess},
+		orchestrator.WithFallback(&orchestrator.MockAgent{}))
+	c.AddAgent(refuse)
+
+	var names []string
+	for _, a := range c.Agents() {
+		names = append(names, a.URL)
+	}
+	if want := []string{"agent-0", srv.URL, "agent-1", "agent-3"}; !slices.Equal(names, want) {
+		t.Errorf("pool %q, want %q", names, want)
+	}
+
+	results, err := c.Run(context.Background(), specsN(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	perAgent := make(map[string]int)
+	for _, r := range results {
+		if !r.Success {
+			t.Errorf("%s on %s: %s", r.SpecID, r.Agent, r.Error)
+		}
+		perAgent[r.Agent]++
+	}
+	// Round-robin: agent-3 refuses its two, which the fallback, agent-2, takes
+	if want := map[string]int{"agent-0": 2, srv.URL: 2, "agent-1": 2, "agent-2": 2}; !maps.Equal(perAgent, want) {
+		t.Errorf("specs per agent = %v, want %v", perAgent, want)
+	}
+	if mock.Calls() == 0 || refuse.Calls() == 0 {
+		t.Errorf("mock agents saw %d and %d calls, want both used", mock.Calls(), refuse.Calls())
+	}
+}
+
+func TestStream(t *testing.T) {
+	srv, _ := newAgentServer(t, nil)
+	agents := []*orchestrator.FastForthAgent{newAgent(t, srv.URL)}
+	ctx := context.Background()
+
+	var progress atomic.Int64
+	c := orchestrator.NewCoordinatorWithAgents(agents, orchestrator.WithProgressFunc(func(completed, total int, r orchestrator.Result) {
+		progress.Add(1)
+	}))
+	results, errc := c.Stream(ctx, specsN(20))
+	n := 0
+	for r := range results {
+		if !r.Success {
+			t.Errorf("%s: %s", r.SpecID, r.Error)
+		}
+		n++
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 || progress.Load() != 20 {
+		t.Errorf("got %d results and %d progress calls, want 20 each", n, progress.Load())
+	}
+
+	// Errors arrive after the results channel closes
+	cyclic := specsN(2)
+	cyclic[0].DependsOn, cyclic[1].DependsOn = []string{cyclic[1].ID}, []string{cyclic[0].ID}
+	results, errc = c.Stream(ctx, cyclic)
+	for range results {
+		t.Error("result from a cyclic batch")
+	}
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("cyclic batch error = %v", err)
+	}
+
+	c = orchestrator.NewCoordinatorWithAgents(agents,
+		orchestrator.WithCheckpoint(filepath.Join(t.TempDir(), "missing", "checkpoint.jsonl")))
+	results, errc = c.Stream(ctx, specsN(2))
+	for range results {
+	}
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "checkpoint") {
+		t.Errorf("unwritable checkpoint error = %v", err)
+	}
+}
+
+func TestParseStageTimeouts(t *testing.T) {
+	got, err := orchestrator.ParseStageTimeouts(" validate=100ms, generate=1m,,test=2s ")
+	want := map[string]time.Duration{"validate": 100 * time.Millisecond, "generate": time.Minute, "test": 2 * time.Second}
+	if err != nil || !maps.Equal(got, want) {
+		t.Errorf("ParseStageTimeouts = %v, %v; want %v", got, err, want)
+	}
+
+	for in, msg := range map[string]string{
+		"verfy=1s":      "verfy: unknown stage",
+		"validate":      "want stage=duration",
+		"generate=soon": `generate="soon": want a positive duration`,
+		"verify=-1s":    `verify="-1s": want a positive duration`,
+	} {
+		if _, err := orchestrator.ParseStageTimeouts(in); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("ParseStageTimeouts(%q) error = %v, want %q", in, err, msg)
+		}
+	}
+}
+
+func TestFleetStageTimeouts(t *testing.T) {
+	agent := func(st map[string]string) orchestrator.AgentConfig {
+		return orchestrator.AgentConfig{URL: "http://127.0.0.1:1", StageTimeouts: st}
+	}
+	tests := []struct {
+		name string
+		cfg  orchestrator.FleetConfig
+		want string // Error prefix; "" for success
+	}{
+		{"fleet default", orchestrator.FleetConfig{
+			Agents:        []orchestrator.AgentConfig{agent(nil)},
+			StageTimeouts: map[string]string{"generate": "60s"},
+		}, ""},
+		{"agent override", orchestrator.FleetConfig{
+			Agents: []orchestrator.AgentConfig{agent(nil), agent(map[string]string{"verify": "1s"})},
+		}, ""},
+		{"unknown fleet stage", orchestrator.FleetConfig{
+			Agents:        []orchestrator.AgentConfig{agent(map[string]string{"verify": "1s"})},
+			StageTimeouts: map[string]string{"verfy": "1s"},
+		}, "fleet stage_timeouts: stage timeout verfy: unknown stage"},
+		{"bad fleet duration", orchestrator.FleetConfig{
+			Agents:        []orchestrator.AgentConfig{agent(nil)},
+			StageTimeouts: map[string]string{"verify": "0s"},
+		}, "fleet stage_timeouts: "},
+		{"unknown agent stage", orchestrator.FleetConfig{
+			Agents: []orchestrator.AgentConfig{agent(nil), agent(map[string]string{"generat": "1s"})},
+		}, "agent 1: stage_timeouts: stage timeout generat: unknown stage"},
+	}
+	for _, tc := range tests {
+		_, err := tc.cfg.NewAgents()
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.want != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.want)):
+			t.Errorf("%s: error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestSyntheticSpecs(t *testing.T) {
+	specs, err := orchestrator.SyntheticSpecs("mixed", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patterns []string
+	for i, s := range specs {
+		if s.ID != fmt.Sprintf("func_%d", i) || s.Word != fmt.Sprintf("function_%d", i) {
+			t.Errorf("spec %d is %s/%s", i, s.ID, s.Word)
+		}
+		patterns = append(patterns, s.PatternID)
+	}
+	if want := []string{"DROP_EXCESS_001", "RECURSIVE_004", "DUP_TRANSFORM_001", "DROP_EXCESS_001"}; !slices.Equal(patterns, want) {
+		t.Errorf("mixed patterns = %v, want %v", patterns, want)
+	}
+
+	for _, tc := range []struct {
+		template string
+		n        int
+	}{{"square", 0}, {"square", -1}, {"cube", 1}} {
+		if _, err := orchestrator.SyntheticSpecs(tc.template, tc.n); err == nil {
+			t.Errorf("SyntheticSpecs(%q, %d) succeeded", tc.template, tc.n)
+		}
+	}
+}
+
+func TestFleetCoordinatorOptions(t *testing.T) {
+	cfg := orchestrator.FleetConfig{Agents: []orchestrator.AgentConfig{{URL: "http://127.0.0.1:1"}}}
+	if opts, err := cfg.CoordinatorOptions(); err != nil || len(opts) != 0 {
+		t.Errorf("without retry_budget: %d options, %v", len(opts), err)
+	}
+	cfg.RetryBudget = &orchestrator.RetryBudgetConfig{Capacity: 10, PerSecond: 1}
+	if opts, err := cfg.CoordinatorOptions(); err != nil || len(opts) != 1 {
+		t.Errorf("with retry_budget: %d options, %v", len(opts), err)
+	}
+	cfg.RetryBudget.Capacity = 0
+	if _, err := cfg.CoordinatorOptions(); err == nil {
+		t.Error("zero retry_budget capacity accepted")
+	}
+	if _, err := cfg.NewCoordinator(); err == nil {
+		t.Error("NewCoordinator accepted a zero retry_budget capacity")
+	}
+}
+
+func TestNewCoordinatorFromEnv(t *testing.T) {
+	fleet := filepath.Join(t.TempDir(), "fleet.json")
+	if err := os.WriteFile(fleet, []byte(`{"agents": [{"url": "http://127.0.0.1:1", "labels": {"zone": "eu"}}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string // Error; "" for success
+	}{
+		{"urls", map[string]string{"FIFTH_AGENT_URLS": "http://127.0.0.1:1", "FIFTH_TIMEOUT": "5s", "FIFTH_WORKERS": "4"}, ""},
+		{"fleet", map[string]string{"FIFTH_CONFIG": fleet, "FIFTH_AGENT_SELECTOR": "zone=eu", "FIFTH_WORKERS": "4"}, ""},
+		{"fleet with timeout", map[string]string{"FIFTH_CONFIG": fleet, "FIFTH_TIMEOUT": "5s"},
+			"FIFTH_TIMEOUT cannot be combined with a fleet file; set timeout in it"},
+		{"fleet with stage timeouts", map[string]string{"FIFTH_CONFIG": fleet, "FIFTH_STAGE_TIMEOUTS": "generate=1s"},
+			"FIFTH_STAGE_TIMEOUTS cannot be combined with a fleet file; set stage_timeouts in it"},
+		{"fleet with tls", map[string]string{"FIFTH_CONFIG": fleet, "FIFTH_TLS_CA": "ca.pem"},
+			"FIFTH_TLS_* cannot be combined with a fleet file; set tls in it"},
+		{"urls with selector", map[string]string{"FIFTH_AGENT_URLS": "http://127.0.0.1:1", "FIFTH_AGENT_SELECTOR": "zone=eu"},
+			"FIFTH_AGENT_SELECTOR needs a fleet file: only fleet files carry labels"},
+		{"bad workers", map[string]string{"FIFTH_AGENT_URLS": "http://127.0.0.1:1", "FIFTH_WORKERS": "0"},
+			`FIFTH_WORKERS="0": want a positive integer`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range []string{
+				orchestrator.EnvAgentURLs, orchestrator.EnvConfig, orchestrator.EnvSelector, orchestrator.EnvTimeout,
+				orchestrator.EnvWorkers, orchestrator.EnvStageTimeouts, orchestrator.EnvTLSCA, orchestrator.EnvTLSCert, orchestrator.EnvTLSKey,
+			} {
+				t.Setenv(name, tc.env[name])
+			}
+			c, err := orchestrator.NewCoordinatorFromEnv()
+			switch {
+			case tc.want == "" && (err != nil || len(c.Agents()) != 1):
+				t.Errorf("NewCoordinatorFromEnv() = %v, %v", c, err)
+			case tc.want != "" && (err == nil || err.Error() != tc.want):
+				t.Errorf("error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
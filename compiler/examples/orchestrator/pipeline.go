@@ -0,0 +1,547 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/agentpb"
+	"github.com/quivent/fifth/compiler/examples/verify"
+)
+
+// PipelineState carries one spec through the pipeline stages
+type PipelineState struct {
+	Spec  Specification
+	Agent *FastForthAgent
+	Code  string   // Set by GenerateStage; later stages may rewrite it
+	Tests []string // Set by GenerateStage
+
+	FromCache      bool   // GenerateStage served Code from the agent's Cache
+	Shared         bool   // GenerateStage joined another spec's in-flight call
+	VerifyMethod   string // VerifyAgent or VerifyLocal, set by VerifyStage
+	FailedTestCase int    // 1-based TestCases index that diverged, set by TestStage
+}
+
+// program is Code preceded by the Definitions it may call, so checking
+// it needs no knowledge of the spec's dependencies
+func (st *PipelineState) program() string {
+	return strings.Join(append(slices.Clip(st.Spec.Definitions), st.Code), "\n")
+}
+
+// Stage is one step of the spec workflow; a non-nil error fails the spec
+type Stage func(ctx context.Context, st *PipelineState) error
+
+// PipelineStep names a stage for observers
+type PipelineStep struct {
+	Name string
+	Run  Stage
+}
+
+// Pipeline is the ordered list of stages ProcessSpec executes
+type Pipeline []PipelineStep
+
+// ValidateStage validates the spec (<1ms)
+func ValidateStage(ctx context.Context, st *PipelineState) error {
+	// Catch test cases that disagree with the stack effect locally
+	if err := CheckArity(st.Spec); err != nil {
+		return fmt.Errorf("Invalid specification: %w", err)
+	}
+
+	valid, err := st.Agent.ValidateSpec(ctx, st.Spec)
+	if err != nil {
+		return fmt.Errorf("Invalid specification: %w", err)
+	}
+	if !valid {
+		return errors.New("Invalid specification")
+	}
+	return nil
+}
+
+// GenerateStage generates code and tests (10-50ms), or reuses a cached
+// result for an identical spec
+func GenerateStage(ctx context.Context, st *PipelineState) error {
+	cache, flights := st.Agent.cache, st.Agent.flights
+	if probing(ctx) {
+		// AutoTuneWorkers has to time real generations
+		cache, flights = nil, nil
+	}
+	var key string
+	if cache != nil || flights != nil {
+		key = SpecHash(st.Spec)
+	}
+
+	if cache != nil {
+		if entry, ok := cache.Get(key); ok {
+			st.Code, st.Tests, st.FromCache = entry.Code, entry.Tests, true
+			return nil
+		}
+	}
+
+	if flights != nil {
+		entry, shared, err := flights.Do(ctx, key, func(ctx context.Context) (CacheEntry, error) {
+			// The call serves every sharer, so it gets its own request
+			// ID, trace and stage timeout rather than the first caller's
+			ctx = WithRequestID(ctx, NewRequestID())
+			ctx, cancel := st.Agent.stageContext(ctx, "generate")
+			defer cancel()
+			ctx, span := st.Agent.tracer.start(ctx, "generate", map[string]string{
+				"agent.url": st.Agent.URL, "request.id": RequestIDFrom(ctx), "spec.hash": key, "shared": "true",
+			})
+			code, tests, err := st.Agent.generateNonEmpty(ctx, st.Spec)
+			if err != nil {
+				span.end(err.Error())
+			} else {
+				span.end("")
+			}
+			return CacheEntry{Code: code, Tests: tests}, err
+		})
+		if err != nil {
+			return err
+		}
+		st.Code, st.Tests, st.Shared = entry.Code, slices.Clone(entry.Tests), shared
+		return nil
+	}
+
+	code, tests, err := st.Agent.generateNonEmpty(ctx, st.Spec)
+	if err != nil {
+		return err
+	}
+	st.Code, st.Tests = code, tests
+	return nil
+}
+
+// ErrEmptyCode fails a spec whose agent generated blank code without an
+// error, which an empty-input verify could otherwise pass
+var ErrEmptyCode = errors.New("empty code returned")
+
+// generateNonEmpty is generateCode, failing on blank code
+func (a *FastForthAgent) generateNonEmpty(ctx context.Context, spec Specification) (string, []string, error) {
+	code, tests, err := a.GenerateCode(ctx, spec)
+	if err == nil && strings.TrimSpace(code) == "" {
+		return "", nil, ErrEmptyCode
+	}
+	return code, tests, err
+}
+
+// VerifyStage verifies the generated code's stack effect (<1ms)
+func VerifyStage(ctx context.Context, st *PipelineState) error {
+	verified, method, err := st.Agent.verifyWithFallback(ctx, st.program(), st.Spec.StackEffect)
+	st.VerifyMethod = method
+	if err != nil {
+		return fmt.Errorf("Stack effect mismatch: %w", err)
+	}
+	if !verified {
+		return errors.New("Stack effect mismatch")
+	}
+	return nil
+}
+
+// TestStage runs every TestCase against the generated code on the
+// agent's /run endpoint and fails on the first output mismatch
+func TestStage(ctx context.Context, st *PipelineState) error {
+	for i, tc := range st.Spec.TestCases {
+		got, err := st.Agent.RunTest(ctx, st.program(), tc)
+		if err == nil && !slices.Equal(got, tc.Output) {
+			err = fmt.Errorf("want %v, got %v", tc.Output, got)
+		}
+		if err != nil {
+			st.FailedTestCase = i + 1
+			return fmt.Errorf("test case %d (input %v): %w", i+1, tc.Input, err)
+		}
+	}
+	return nil
+}
+
+// DefaultPipeline is validate -> generate -> verify
+var DefaultPipeline = Pipeline{
+	{Name: "validate", Run: ValidateStage},
+	{Name: "generate", Run: GenerateStage},
+	{Name: "verify", Run: VerifyStage},
+}
+
+// TestedPipeline extends DefaultPipeline by executing TestCases.
+// Requires an agent that serves /run.
+var TestedPipeline = append(slices.Clip(DefaultPipeline), PipelineStep{Name: "test", Run: TestStage})
+
+// RunOptions describes a planned run for EstimateRequests
+type RunOptions struct {
+	Pipeline  Pipeline // Stages each spec runs; nil means DefaultPipeline
+	DryRun    bool     // Validate only, one call per spec
+	Cache     Cache    // Specs already cached skip /generate
+	Dedup     bool     // Identical specs share one /generate call
+	RetryRate float64  // Expected retries per call, e.g. 0.05
+}
+
+// RequestEstimate is the expected agent call count for a batch
+type RequestEstimate struct {
+	ByEndpoint map[string]int `json:"by_endpoint"` // Custom stages appear under their step name
+	CacheHits  int            `json:"cache_hits"`
+	Retries    int            `json:"retries"`
+	Total      int            `json:"total"` // Including retries
+}
+
+// stageEndpoints maps built-in stage names to the endpoint they call
+var stageEndpoints = map[string]string{
+	"validate": "/spec/validate",
+	"generate": "/generate",
+	"verify":   "/verify",
+	"test":     "/run",
+}
+
+// EstimateRequests predicts how many agent calls specs will cost, to
+// check against metered agents before running. It assumes every spec
+// passes every stage, so it is an upper bound apart from retries.
+func EstimateRequests(specs []Specification, opts RunOptions) RequestEstimate {
+	pipeline := opts.Pipeline
+	if pipeline == nil {
+		pipeline = DefaultPipeline
+	}
+	if opts.DryRun {
+		pipeline = Pipeline{{Name: "validate", Run: ValidateStage}}
+	}
+
+	est := RequestEstimate{ByEndpoint: make(map[string]int)}
+	generated := make(map[string]bool)
+	for _, spec := range specs {
+		hash := SpecHash(spec)
+		for _, step := range pipeline {
+			endpoint, ok := stageEndpoints[step.Name]
+			if !ok {
+				endpoint = step.Name
+			}
+			switch step.Name {
+			case "generate":
+				if opts.Cache != nil {
+					// Earlier identical specs will have filled the cache
+					if _, hit := opts.Cache.Get(hash); hit || generated[hash] {
+						est.CacheHits++
+						continue
+					}
+				}
+				if opts.Dedup && generated[hash] {
+					continue
+				}
+				generated[hash] = true
+				est.ByEndpoint[endpoint]++
+			case "test":
+				est.ByEndpoint[endpoint] += len(spec.TestCases)
+			default:
+				est.ByEndpoint[endpoint]++
+			}
+		}
+	}
+
+	for _, n := range est.ByEndpoint {
+		est.Total += n
+	}
+	est.Retries = int(math.Ceil(float64(est.Total) * opts.RetryRate))
+	est.Total += est.Retries
+	return est
+}
+
+// WithPipeline replaces the stages ProcessSpec runs, e.g. to skip
+// verify for trusted patterns or append a post-processing stage
+func WithPipeline(p Pipeline) AgentOption {
+	return func(a *FastForthAgent) {
+		a.pipeline = p
+	}
+}
+
+// ProcessSpec runs full workflow (5-10 seconds). Cancelling ctx aborts
+// any in-flight request and returns a Cancelled result.
+func (a *FastForthAgent) ProcessSpec(ctx context.Context, spec Specification) Result {
+	return a.processSpec(ctx, spec, NopObserver{})
+}
+
+// processSpec runs the agent's pipeline, reporting each stage to obs,
+// and caches the generated code on success
+func (a *FastForthAgent) processSpec(ctx context.Context, spec Specification, obs Observer) Result {
+	result := a.runPipeline(ctx, &PipelineState{Spec: spec, Agent: a}, a.pipeline, obs)
+	if result.Success && a.cache != nil && !result.FromCache && !probing(ctx) {
+		a.cache.Put(SpecHash(spec), CacheEntry{Code: result.Code, Tests: result.Tests})
+	}
+	return result
+}
+
+// VerifyOnly checks pre-generated code against a stack effect without
+// validating or generating, e.g. to re-verify cached or stored code.
+// The Result follows ProcessSpec's conventions: Cancelled on ctx,
+// Category on failure, and VerifyMS.
+func (a *FastForthAgent) VerifyOnly(ctx context.Context, specID, code, effect string) Result {
+	st := &PipelineState{
+		Spec:  Specification{ID: specID, StackEffect: effect},
+		Agent: a,
+		Code:  code,
+	}
+	return a.runPipeline(ctx, st, verifyOnlyPipeline, NopObserver{})
+}
+
+// verifyOnlyPipeline is VerifyOnly's single stage
+var verifyOnlyPipeline = Pipeline{{Name: "verify", Run: VerifyStage}}
+
+// runPipeline runs pipeline over st, reporting each stage to obs.
+// Cancelling ctx aborts the spec with a Cancelled result.
+func (a *FastForthAgent) runPipeline(ctx context.Context, st *PipelineState, pipeline Pipeline, obs Observer) (result Result) {
+	spec := st.Spec
+	requestID := spec.RequestID
+	if requestID == "" {
+		requestID = NewRequestID()
+	}
+	ctx, span := a.tracer.start(ctx, "ProcessSpec", map[string]string{
+		"spec.id": spec.ID, "spec.word": spec.Word, "agent.url": a.URL, "request.id": requestID,
+	})
+	defer func() { span.end(result.Error) }()
+
+	if a.slots != nil {
+		select {
+		case a.slots <- struct{}{}:
+			defer func() { <-a.slots }()
+		case <-ctx.Done():
+			return cancelledResult(spec, requestID, ctx.Err(), 0)
+		}
+	}
+
+	start := time.Now()
+	ctx = WithRequestID(ctx, requestID)
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, time.Duration(spec.Timeout), ErrSpecTimeout)
+		defer cancel()
+	}
+	stageMS := make(map[string]float64, len(pipeline))
+
+	for _, step := range pipeline {
+		stepStart := time.Now()
+		stepCtx, cancelStep := a.stageContext(ctx, step.Name)
+		stepCtx, stageSpan := a.tracer.start(stepCtx, step.Name, nil)
+		err := step.Run(stepCtx, st)
+		stageTimedOut := context.Cause(stepCtx) == ErrStageTimeout
+		cancelStep()
+		if err != nil {
+			stageSpan.end(err.Error())
+		} else {
+			stageSpan.end("")
+		}
+		stageMS[step.Name] = time.Since(stepStart).Seconds() * 1000
+		obs.OnStageComplete(spec.ID, step.Name, err)
+		if err != nil && stageTimedOut && ctx.Err() == nil {
+			return withStageTimes(Result{
+				SpecID:    spec.ID,
+				RequestID: requestID,
+				Labels:    spec.Labels,
+				Success:   false,
+				TimedOut:  true,
+				Error:     fmt.Sprintf("%v: %s after %v", ErrStageTimeout, step.Name, a.stageTimeouts[step.Name]),
+				Category:  FailTimeout,
+				LatencyMS: time.Since(start).Seconds() * 1000,
+			}, stageMS)
+		}
+		if err != nil && context.Cause(ctx) == ErrSpecTimeout {
+			return withStageTimes(Result{
+				SpecID:    spec.ID,
+				RequestID: requestID,
+				Labels:    spec.Labels,
+				Success:   false,
+				TimedOut:  true,
+				Error:     fmt.Sprintf("%v after %v in %s", ErrSpecTimeout, spec.Timeout, step.Name),
+				Category:  FailTimeout,
+				LatencyMS: time.Since(start).Seconds() * 1000,
+			}, stageMS)
+		}
+		if err != nil && ctx.Err() != nil {
+			return withStageTimes(cancelledResult(spec, requestID, ctx.Err(), time.Since(start)), stageMS)
+		}
+		if err != nil {
+			return withStageTimes(Result{
+				SpecID:         spec.ID,
+				RequestID:      requestID,
+				Labels:         spec.Labels,
+				Success:        false,
+				Error:          err.Error(),
+				Category:       stageFailure(step.Name, err),
+				LatencyMS:      time.Since(start).Seconds() * 1000,
+				FailedTestCase: st.FailedTestCase,
+				VerifyMethod:   st.VerifyMethod,
+			}, stageMS)
+		}
+	}
+
+	return withStageTimes(Result{
+		SpecID:    spec.ID,
+		RequestID: requestID,
+		Labels:    spec.Labels,
+		Success:   true,
+		Code:      st.Code,
+		Tests:     st.Tests,
+		TestCount: len(st.Tests),
+		LatencyMS: time.Since(start).Seconds() * 1000,
+		FromCache: st.FromCache,
+		Shared:    st.Shared,
+
+		VerifyMethod: st.VerifyMethod,
+	}, stageMS)
+}
+
+// withStageTimes copies the default stages' durations into r
+func withStageTimes(r Result, stageMS map[string]float64) Result {
+	r.ValidateMS = stageMS["validate"]
+	r.GenerateMS = stageMS["generate"]
+	r.VerifyMS = stageMS["verify"]
+	return r
+}
+
+// ErrSpecTimeout is the cause of a spec context that ran past Specification.Timeout
+var ErrSpecTimeout = errors.New("spec timeout exceeded")
+
+// ErrStageTimeout is the cause of a stage context that ran past its
+// WithStageTimeouts limit
+var ErrStageTimeout = errors.New("stage timeout exceeded")
+
+// cancelledResult reports a spec aborted by its context
+func cancelledResult(spec Specification, requestID string, err error, elapsed time.Duration) Result {
+	return Result{
+		SpecID:    spec.ID,
+		RequestID: requestID,
+		Success:   false,
+		Cancelled: true,
+		Error:     err.Error(),
+		Category:  FailCancelled,
+		LatencyMS: elapsed.Seconds() * 1000,
+		Labels:    spec.Labels,
+	}
+}
+
+// rpcTransportCodes are gRPC statuses that blame the agent, not the spec
+var rpcTransportCodes = []agentpb.Code{
+	agentpb.Unavailable, agentpb.Unimplemented, agentpb.ResourceExhausted, agentpb.Internal,
+}
+
+// stageFailure categorizes an error from the named pipeline stage:
+// transport problems first, so a flaky agent during validate is not
+// blamed on the spec, then by stage. Custom stages fall under FailOther.
+func stageFailure(stage string, err error) FailureCategory {
+	var (
+		netErr    net.Error
+		urlErr    *url.Error
+		statusErr *StatusError
+		rpcErr    *agentpb.Status
+	)
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout(),
+		errors.As(err, &rpcErr) && rpcErr.Code == agentpb.DeadlineExceeded:
+		return FailTimeout
+	case errors.As(err, &urlErr), errors.As(err, &statusErr), errors.Is(err, ErrResponseTooLarge),
+		errors.As(err, &rpcErr) && slices.Contains(rpcTransportCodes, rpcErr.Code):
+		return FailNetwork
+	}
+	switch stage {
+	case "validate":
+		return FailInvalidSpec
+	case "generate":
+		return FailGeneration
+	case "verify":
+		return FailStackMismatch
+	case "test":
+		return FailTest
+	}
+	return FailOther
+}
+
+// Verification methods recorded in Result.VerifyMethod
+const (
+	VerifyAgent = "agent"
+	VerifyLocal = "local"
+)
+
+// verifyWithFallback tries the agent's /verify and, when the agent is
+// configured for it, falls back to local verification on failure.
+// With WithLocalVerify the order is reversed.
+func (a *FastForthAgent) verifyWithFallback(ctx context.Context, code, effect string) (bool, string, error) {
+	if a.localFirst {
+		ok, diags, err := verify.StackEffect(code, effect)
+		switch {
+		case err == nil && ok:
+			return true, VerifyLocal, nil
+		case err == nil:
+			msgs := make([]string, len(diags))
+			for i, d := range diags {
+				msgs[i] = d.String()
+			}
+			return false, VerifyLocal, errors.New(strings.Join(msgs, "; "))
+		}
+	}
+	if !a.localFallback {
+		ok, err := a.VerifyStackEffect(ctx, code, effect)
+		return ok, VerifyAgent, err
+	}
+
+	vctx := ctx
+	if a.verifyTimeout > 0 {
+		var cancel context.CancelFunc
+		vctx, cancel = context.WithTimeout(ctx, a.verifyTimeout)
+		defer cancel()
+	}
+
+	ok, err := a.VerifyStackEffect(vctx, code, effect)
+	if err == nil {
+		return ok, VerifyAgent, nil
+	}
+	if ctx.Err() != nil {
+		return false, VerifyAgent, err // The caller gave up; don't mask it
+	}
+
+	ok, localErr := VerifyStackEffectLocal(code, effect)
+	if localErr != nil {
+		return false, VerifyLocal, fmt.Errorf("agent: %v; local: %w", err, localErr)
+	}
+	return ok, VerifyLocal, nil
+}
+
+// StackEffect is a dialect-independent stack effect
+type StackEffect = verify.Effect
+
+// NormalizeStackEffect parses the common stack-effect dialects into a
+// StackEffect: "( n -- n² )", "[ n -> n^2 ]", bare "a b -- c", and
+// arrows "→"/"=>" (see verify.ParseEffect)
+func NormalizeStackEffect(s string) (StackEffect, error) {
+	return verify.ParseEffect(s)
+}
+
+// CheckArity verifies every test case has as many inputs and outputs as
+// the spec's stack effect declares, catching e.g. "( n -- n² )" paired
+// with a two-input test before any agent call
+func CheckArity(spec Specification) error {
+	if len(spec.TestCases) == 0 {
+		return nil
+	}
+	effect, err := NormalizeStackEffect(spec.StackEffect)
+	if err != nil {
+		return err
+	}
+
+	for i, tc := range spec.TestCases {
+		if len(tc.Input) != len(effect.Inputs) {
+			return fmt.Errorf("test case %d: %d inputs, stack effect %s takes %d",
+				i+1, len(tc.Input), effect, len(effect.Inputs))
+		}
+		if len(tc.Output) != len(effect.Outputs) {
+			return fmt.Errorf("test case %d: %d outputs, stack effect %s leaves %d",
+				i+1, len(tc.Output), effect, len(effect.Outputs))
+		}
+	}
+	return nil
+}
+
+// VerifyStackEffectLocal checks code against a stack effect without a
+// network call. It is verify.StackEffect without the diagnostics; words
+// of unknown effect return an error rather than a guess.
+func VerifyStackEffectLocal(code, effect string) (bool, error) {
+	ok, _, err := verify.StackEffect(code, effect)
+	return ok, err
+}
@@ -0,0 +1,197 @@
+package orchestrator
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// FailureReasons counts failed results by category. Results without a
+// Category (e.g. loaded from an older run) are bucketed from Error.
+func FailureReasons(results []Result) map[FailureCategory]int {
+	counts := make(map[FailureCategory]int)
+	for _, r := range results {
+		if !r.Success {
+			counts[failureCategory(r)]++
+		}
+	}
+	return counts
+}
+
+// failureCategory is r.Category, or a best guess from the error text
+func failureCategory(r Result) FailureCategory {
+	switch {
+	case r.Category != "":
+		return r.Category
+	case r.Skipped:
+		return FailSkipped
+	case r.TimedOut:
+		return FailTimeout
+	case r.Cancelled:
+		return FailCancelled
+	case strings.HasPrefix(r.Error, "Invalid specification"):
+		return FailInvalidSpec
+	case strings.HasPrefix(r.Error, "Stack effect mismatch"):
+		return FailStackMismatch
+	case strings.HasPrefix(r.Error, "test case"):
+		return FailTest
+	}
+	return FailOther
+}
+
+// formatReasons renders counts most common first, e.g.
+// "network 3, invalid_spec 1"
+func formatReasons(counts map[FailureCategory]int) string {
+	cats := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(cats, func(a, b FailureCategory) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+	parts := make([]string, len(cats))
+	for i, cat := range cats {
+		parts[i] = fmt.Sprintf("%s %d", cat, counts[cat])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PrintSummary prints results summary for a run across agents agents
+func PrintSummary(results []Result, agents int) {
+	writeSummary(os.Stdout, results, agents)
+}
+
+// writeSummary is PrintSummary to w
+func writeSummary(w io.Writer, results []Result, agents int) error {
+	var buf bytes.Buffer
+	successful := 0
+	cached := 0
+	shared := 0
+	measured := 0 // Successful specs that made their own generate call
+	totalLatency := 0.0
+	var validateMS, generateMS, verifyMS float64
+	withTests := 0
+	var fastest, slowest Result
+
+	for _, r := range results {
+		if !r.Success {
+			continue
+		}
+		successful++
+		if r.TestCount > 0 {
+			withTests++
+		}
+		if r.FromCache {
+			cached++
+			continue
+		}
+		if r.Shared {
+			shared++
+			continue
+		}
+		if measured == 0 || r.LatencyMS < fastest.LatencyMS {
+			fastest = r
+		}
+		if measured == 0 || r.LatencyMS > slowest.LatencyMS {
+			slowest = r
+		}
+		measured++
+		totalLatency += r.LatencyMS
+		validateMS += r.ValidateMS
+		generateMS += r.GenerateMS
+		verifyMS += r.VerifyMS
+	}
+
+	failed := len(results) - successful
+
+	fmt.Fprintf(&buf, "\n=== Results ===\n")
+	fmt.Fprintf(&buf, "Successful: %d\n", successful)
+	fmt.Fprintf(&buf, "Failed: %d\n", failed)
+	if len(results) > 0 {
+		fmt.Fprintf(&buf, "Success rate: %.1f%%\n", float64(successful)/float64(len(results))*100)
+	}
+	if cached > 0 {
+		fmt.Fprintf(&buf, "Cache hits: %d\n", cached)
+	}
+	if shared > 0 {
+		fmt.Fprintf(&buf, "Deduplicated: %d\n", shared)
+	}
+	if successful > 0 {
+		fmt.Fprintf(&buf, "With tests: %d, without tests: %d\n", withTests, successful-withTests)
+	}
+	if failed > 0 {
+		fmt.Fprintf(&buf, "Failure reasons: %s\n", formatReasons(FailureReasons(results)))
+	}
+
+	// Latency stats cover successful specs that made their own generate call
+	if measured == 0 {
+		fmt.Fprintf(&buf, "\nNo successful specs; latency unavailable\n")
+	} else {
+		fmt.Fprintf(&buf, "\nAverage latency per spec: %.2fms\n", totalLatency/float64(measured))
+		fmt.Fprintf(&buf, "Min latency: %.2fms (%s)\n", fastest.LatencyMS, fastest.SpecID)
+		fmt.Fprintf(&buf, "Max latency: %.2fms (%s)\n", slowest.LatencyMS, slowest.SpecID)
+		n := float64(measured)
+		fmt.Fprintf(&buf, "Per stage: validate %.2fms, generate %.2fms, verify %.2fms\n",
+			validateMS/n, generateMS/n, verifyMS/n)
+	}
+
+	// Performance comparison from the measured average latency, one spec
+	// per agent at a time; skipped when there is nothing measured
+	if measured > 0 && agents > 0 {
+		avg := totalLatency / float64(measured)
+		waves := (len(results) + agents - 1) / agents
+		single := avg * float64(len(results)) / 1000
+		multi := avg * float64(waves) / 1000
+		fmt.Fprintf(&buf, "\n=== Performance Comparison ===\n")
+		fmt.Fprintf(&buf, "Single-agent time: %.1f seconds (%d specs × %.0fms)\n", single, len(results), avg)
+		fmt.Fprintf(&buf, "Multi-agent time: ~%.1f seconds (with %d agents)\n", multi, agents)
+		fmt.Fprintf(&buf, "Speedup: ~%.1fx from parallelism\n", single/multi)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Reporter renders a finished run, keeping output formats out of Run
+type Reporter interface {
+	Report(stats RunStats, results []Result) error
+}
+
+// TextReporter writes the human-readable PrintSummary
+type TextReporter struct {
+	W      io.Writer // Nil means os.Stdout
+	Agents int       // Sizes the performance comparison; 0 skips it
+}
+
+func (r TextReporter) Report(_ RunStats, results []Result) error {
+	w := r.W
+	if w == nil {
+		w = os.Stdout
+	}
+	return writeSummary(w, results, r.Agents)
+}
+
+// JSONReporter writes the RunStats as indented JSON, or with Results set
+// an object holding both: {"stats": {...}, "results": [...]}
+type JSONReporter struct {
+	W       io.Writer // Nil means os.Stdout
+	Results bool
+}
+
+func (r JSONReporter) Report(stats RunStats, results []Result) error {
+	w := r.W
+	if w == nil {
+		w = os.Stdout
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if !r.Results {
+		return enc.Encode(stats)
+	}
+	return enc.Encode(struct {
+		Stats   RunStats `json:"stats"`
+		Results []Result `json:"results"`
+	}{stats, results})
+}
@@ -0,0 +1,342 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DuplicateSpecError lists spec IDs that appear in more than one shard
+type DuplicateSpecError struct {
+	IDs []string
+}
+
+func (e *DuplicateSpecError) Error() string {
+	return fmt.Sprintf("spec IDs in more than one run: %s", strings.Join(e.IDs, ", "))
+}
+
+// MergeResults concatenates results from sharded runs, renumbering Index
+// to the merged position. Every result is kept, but a spec ID that
+// appears in more than one run is reported in a *DuplicateSpecError,
+// since it usually means the shards overlapped.
+func MergeResults(runs ...[]Result) ([]Result, error) {
+	var merged []Result
+	owner := make(map[string]int) // Spec ID to the run that first had it
+	var dups []string
+
+	for run, results := range runs {
+		for _, r := range results {
+			if first, ok := owner[r.SpecID]; !ok {
+				owner[r.SpecID] = run
+			} else if first != run && !slices.Contains(dups, r.SpecID) {
+				dups = append(dups, r.SpecID)
+			}
+			r.Index = len(merged)
+			merged = append(merged, r)
+		}
+	}
+
+	if len(dups) > 0 {
+		sort.Strings(dups)
+		return merged, &DuplicateSpecError{IDs: dups}
+	}
+	return merged, nil
+}
+
+// DedupResults keeps one result per spec ID, for redundant submission of
+// the same spec from several coordinators. Among duplicates:
+//
+//  1. a successful result beats any failure;
+//  2. among successes, the lowest LatencyMS wins;
+//  3. remaining ties, and all-failure groups, keep the earliest result.
+//
+// Output is in order of each ID's first appearance; kept results are
+// returned unchanged, Index included.
+func DedupResults(results []Result) []Result {
+	var kept []Result
+	pos := make(map[string]int, len(results)) // Spec ID to its slot in kept
+
+	for _, r := range results {
+		i, ok := pos[r.SpecID]
+		if !ok {
+			pos[r.SpecID] = len(kept)
+			kept = append(kept, r)
+			continue
+		}
+		cur := kept[i]
+		if r.Success && (!cur.Success || r.LatencyMS < cur.LatencyMS) {
+			kept[i] = r
+		}
+	}
+	return kept
+}
+
+// ResultWriter appends results to a stream as newline-delimited JSON.
+// Safe for concurrent use.
+type ResultWriter struct {
+	mu  sync.Mutex
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewNDJSONResultWriter writes one JSON object per line to w
+func NewNDJSONResultWriter(w io.Writer) *ResultWriter {
+	buf := bufio.NewWriter(w)
+	return &ResultWriter{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+// Write buffers one result line; call Flush to push it to the writer
+func (rw *ResultWriter) Write(r Result) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.enc.Encode(r)
+}
+
+// Flush writes any buffered lines to the underlying writer
+func (rw *ResultWriter) Flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.buf.Flush()
+}
+
+// SortKey selects the ordering applied by SortResults
+type SortKey int
+
+const (
+	BySubmission SortKey = iota // Order specs were passed to Run
+	BySpecID                    // Lexicographic spec ID
+	ByLatency                   // Fastest first
+)
+
+// SortResults orders results in place; ties keep their submission order
+func SortResults(results []Result, by SortKey) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		switch by {
+		case BySpecID:
+			if a.SpecID != b.SpecID {
+				return a.SpecID < b.SpecID
+			}
+		case ByLatency:
+			if a.LatencyMS != b.LatencyMS {
+				return a.LatencyMS < b.LatencyMS
+			}
+		}
+		return a.Index < b.Index
+	})
+}
+
+// DiffKind classifies a difference found by CompareResults
+type DiffKind string
+
+const (
+	DiffAdded       DiffKind = "added"
+	DiffRemoved     DiffKind = "removed"
+	DiffCodeChanged DiffKind = "code_changed"
+	DiffFixed       DiffKind = "fixed"
+	DiffRegressed   DiffKind = "regressed"
+)
+
+// Diff is one spec whose outcome differs between two runs
+type Diff struct {
+	SpecID       string   `json:"spec_id"`
+	Kind         DiffKind `json:"kind"`
+	BaselineCode string   `json:"baseline_code,omitempty"`
+	CurrentCode  string   `json:"current_code,omitempty"`
+	BaselineErr  string   `json:"baseline_error,omitempty"`
+	CurrentErr   string   `json:"current_error,omitempty"`
+}
+
+func (d Diff) String() string {
+	switch d.Kind {
+	case DiffCodeChanged:
+		return fmt.Sprintf("%s: code changed\n  - %s\n  + %s", d.SpecID, d.BaselineCode, d.CurrentCode)
+	case DiffRegressed:
+		return fmt.Sprintf("%s: regressed: %s", d.SpecID, d.CurrentErr)
+	default:
+		return fmt.Sprintf("%s: %s", d.SpecID, d.Kind)
+	}
+}
+
+// CompareResults joins two runs on SpecID and reports specs that appeared,
+// disappeared, flipped success, or produced different code. Diffs are
+// ordered by SpecID so the output is stable for golden comparisons.
+func CompareResults(baseline, current []Result) []Diff {
+	before := make(map[string]Result, len(baseline))
+	for _, r := range baseline {
+		before[r.SpecID] = r
+	}
+	seen := make(map[string]bool, len(current))
+
+	var diffs []Diff
+	for _, cur := range current {
+		seen[cur.SpecID] = true
+		base, ok := before[cur.SpecID]
+		d := Diff{
+			SpecID:       cur.SpecID,
+			BaselineCode: base.Code,
+			CurrentCode:  cur.Code,
+			BaselineErr:  base.Error,
+			CurrentErr:   cur.Error,
+		}
+		switch {
+		case !ok:
+			d.Kind = DiffAdded
+		case base.Success && !cur.Success:
+			d.Kind = DiffRegressed
+		case !base.Success && cur.Success:
+			d.Kind = DiffFixed
+		case base.Code != cur.Code:
+			d.Kind = DiffCodeChanged
+		default:
+			continue
+		}
+		diffs = append(diffs, d)
+	}
+	for _, base := range baseline {
+		if !seen[base.SpecID] {
+			seen[base.SpecID] = true
+			diffs = append(diffs, Diff{
+				SpecID:       base.SpecID,
+				Kind:         DiffRemoved,
+				BaselineCode: base.Code,
+				BaselineErr:  base.Error,
+			})
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].SpecID < diffs[j].SpecID })
+	return diffs
+}
+
+// ResultSet holds a run's results: in memory up to a limit, with the
+// rest spilled to an NDJSON temp file so memory stays bounded however
+// large the batch. Held-in-memory results are in submission order; once
+// anything spills, All yields in completion order instead, since sorting
+// would need the whole set in memory. Close removes the temp file.
+type ResultSet struct {
+	Stats RunStats
+
+	limit int    // In-memory cap; 0 means unlimited
+	dir   string // Temp file directory; "" means os.TempDir
+	mem   []Result
+	file  *os.File
+	w     *ResultWriter
+	n     int
+	err   error // First spill write error
+}
+
+func (s *ResultSet) add(r Result) {
+	s.n++
+	if s.limit <= 0 || len(s.mem) < s.limit {
+		s.mem = append(s.mem, r)
+		return
+	}
+	if s.err != nil {
+		return
+	}
+	if s.file == nil {
+		if s.file, s.err = os.CreateTemp(s.dir, "fastforth-results-*.ndjson"); s.err != nil {
+			return
+		}
+		s.w = NewNDJSONResultWriter(s.file)
+	}
+	s.err = s.w.Write(r)
+}
+
+// Len is the number of results, in memory and spilled
+func (s *ResultSet) Len() int { return s.n }
+
+// Spilled reports whether any results went to disk
+func (s *ResultSet) Spilled() bool { return s.file != nil }
+
+// All iterates every result, reading spilled ones back from disk. It
+// may be ranged over more than once; a read error ends the iteration.
+func (s *ResultSet) All() iter.Seq2[Result, error] {
+	return func(yield func(Result, error) bool) {
+		for _, r := range s.mem {
+			if !yield(r, nil) {
+				return
+			}
+		}
+		if s.file == nil {
+			return
+		}
+
+		f, err := os.Open(s.file.Name())
+		if err != nil {
+			yield(Result{}, err)
+			return
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for {
+			var r Result
+			if err := dec.Decode(&r); err == io.EOF {
+				return
+			} else if err != nil {
+				yield(Result{}, err)
+				return
+			}
+			if !yield(r, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Close removes the spill file, if any
+func (s *ResultSet) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// WithSpillover makes RunResults keep at most maxInMemory results in
+// memory and spill the rest to a temp file in dir ("" for the system
+// default). Spilling trades result ordering and disk I/O for flat memory.
+func WithSpillover(maxInMemory int, dir string) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.spillAfter = max(maxInMemory, 0)
+		c.spillDir = dir
+	}
+}
+
+// RunResults is Run for batches too large to hold every result in
+// memory (see WithSpillover). The caller must Close the returned set.
+// Like Run, a cancelled run returns its partial results with ctx.Err();
+// if spilling fails the temp file is removed and the set is nil.
+func (c *Coordinator) RunResults(ctx context.Context, specs []Specification) (*ResultSet, error) {
+	if err := c.preflight(ctx); err != nil && len(specs) > 0 {
+		return nil, err
+	}
+	dependents, pending, err := buildDependencyGraph(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &ResultSet{limit: c.spillAfter, dir: c.spillDir}
+	set.Stats, err = c.run(ctx, specs, dependents, pending, c.workerCount(), set.add)
+	if set.err == nil && set.w != nil {
+		set.err = set.w.Flush()
+	}
+	if set.err != nil {
+		set.Close()
+		return nil, fmt.Errorf("spill results: %w", set.err)
+	}
+	if !set.Spilled() {
+		SortResults(set.mem, BySubmission)
+	}
+	return set, err
+}
@@ -0,0 +1,295 @@
+package orchestrator
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how failed agent requests are retried
+type RetryPolicy struct {
+	MaxAttempts int           // Total tries per request; 1 disables retries
+	Backoff     time.Duration // Base delay, doubled after each attempt
+	MaxBackoff  time.Duration // Caps the doubled delay; 0 means uncapped
+
+	// Strategy, when set, replaces the exponential Backoff/MaxBackoff delay
+	Strategy Backoff
+
+	// IdempotentAttempts, when above MaxAttempts, is the try limit for
+	// GET requests, which are safe to repeat; their transport errors are
+	// always retried regardless of ShouldRetry
+	IdempotentAttempts int
+
+	// MaxRetryAfter caps how long a 429's Retry-After header may delay the
+	// next attempt; 0 means DefaultMaxRetryAfter
+	MaxRetryAfter time.Duration
+
+	// ShouldRetry decides whether attempt (1-based) is retried. err is the
+	// transport error, if any; statusCode is 0 when no response arrived.
+	// Nil means DefaultShouldRetry.
+	ShouldRetry func(attempt int, err error, statusCode int) bool
+}
+
+// DefaultShouldRetry retries transport errors, 429, and gateway/availability 5xx
+func DefaultShouldRetry(attempt int, err error, statusCode int) bool {
+	if err != nil {
+		return true
+	}
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// delay returns the wait before retrying attempt. Without a Strategy
+// this is ExponentialBackoff drawing jitter from the agent's rng.
+func (p RetryPolicy) delay(attempt int, rng *lockedRand) time.Duration {
+	if p.Strategy != nil {
+		return p.Strategy.Next(attempt)
+	}
+	return ExponentialBackoff{Base: p.Backoff, Max: p.MaxBackoff, rng: rng}.Next(attempt)
+}
+
+// Backoff computes the wait before retrying attempt (1-based).
+// Implementations must be safe for concurrent use.
+type Backoff interface {
+	Next(attempt int) time.Duration
+}
+
+// WithBackoff sets the agent's retry delay strategy. Apply it after
+// WithRetry, which replaces the whole policy.
+func WithBackoff(b Backoff) AgentOption {
+	return func(a *FastForthAgent) {
+		a.retry.Strategy = b
+	}
+}
+
+// ConstantBackoff waits the same time before every retry
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) Next(int) time.Duration { return time.Duration(b) }
+
+// ExponentialBackoff is full-jitter exponential backoff: a uniform draw
+// from [0, min(Max, Base*2^(attempt-1))], so specs that failed together
+// do not retry in lockstep. Max 0 means uncapped.
+type ExponentialBackoff struct {
+	Base, Max time.Duration
+	rng       *lockedRand
+}
+
+// NewExponentialBackoff draws jitter from src; nil uses the global source
+func NewExponentialBackoff(base, max time.Duration, src rand.Source) ExponentialBackoff {
+	b := ExponentialBackoff{Base: base, Max: max}
+	if src != nil {
+		b.rng = newLockedRand(src)
+	}
+	return b
+}
+
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	if b.Base <= 0 {
+		return 0
+	}
+	shift := min(max(attempt-1, 0), 62)
+	ceiling := time.Duration(math.MaxInt64 - 1) // Room for the +1 below
+	if b.Base <= math.MaxInt64>>shift {
+		ceiling = min(b.Base<<shift, ceiling)
+	}
+	if b.Max > 0 && ceiling > b.Max {
+		ceiling = max(b.Max, b.Base)
+	}
+	return time.Duration(b.rng.int64N(int64(ceiling) + 1))
+}
+
+// DecorrelatedJitterBackoff spreads retries like AWS's "decorrelated
+// jitter": each wait is drawn from [Base, 3x the previous wait's
+// ceiling], capped at Max. Being stateless, it tracks the ceiling
+// (Base*3^(attempt-1)) rather than the previous draw.
+type DecorrelatedJitterBackoff struct {
+	Base, Max time.Duration
+	rng       *lockedRand
+}
+
+// NewDecorrelatedJitterBackoff draws from src; nil uses the global source
+func NewDecorrelatedJitterBackoff(base, max time.Duration, src rand.Source) DecorrelatedJitterBackoff {
+	b := DecorrelatedJitterBackoff{Base: base, Max: max}
+	if src != nil {
+		b.rng = newLockedRand(src)
+	}
+	return b
+}
+
+func (b DecorrelatedJitterBackoff) Next(attempt int) time.Duration {
+	if b.Base <= 0 {
+		return 0
+	}
+	ceiling := b.Base
+	for i := 1; i < attempt && (b.Max <= 0 || ceiling < b.Max) && ceiling < math.MaxInt64/3; i++ {
+		ceiling *= 3
+	}
+	if b.Max > 0 {
+		ceiling = min(ceiling, max(b.Max, b.Base))
+	}
+	return b.Base + time.Duration(b.rng.int64N(int64(ceiling-b.Base)+1))
+}
+
+// lockedRand serializes a seeded *rand.Rand, which is not safe for
+// concurrent use. A nil *lockedRand draws from the global source,
+// which is randomly seeded.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(src rand.Source) *lockedRand {
+	return &lockedRand{r: rand.New(src)}
+}
+
+func (l *lockedRand) int64N(n int64) int64 {
+	if l == nil {
+		return rand.Int64N(n)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int64N(n)
+}
+
+func (l *lockedRand) float64() float64 {
+	if l == nil {
+		return rand.Float64()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+func (l *lockedRand) perm(n int) []int {
+	if l == nil {
+		return rand.Perm(n)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Perm(n)
+}
+
+// WithJitterSource draws the agent's retry jitter from src, e.g.
+// rand.NewPCG(1, 2) in tests for reproducible timing
+func WithJitterSource(src rand.Source) AgentOption {
+	return func(a *FastForthAgent) {
+		a.rng = newLockedRand(src)
+	}
+}
+
+// DefaultMaxRetryAfter bounds honored Retry-After delays when unset
+const DefaultMaxRetryAfter = 30 * time.Second
+
+// retryAfter returns the delay a 429 response asks for, capped at
+// MaxRetryAfter. ok is false when the header is absent or malformed.
+func (p RetryPolicy) retryAfter(resp *http.Response) (d time.Duration, ok bool) {
+	h := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		d = time.Duration(max(secs, 0)) * time.Second
+	} else if at, err := http.ParseTime(h); err == nil {
+		d = max(time.Until(at), 0)
+	} else {
+		return 0, false
+	}
+
+	limit := p.MaxRetryAfter
+	if limit <= 0 {
+		limit = DefaultMaxRetryAfter
+	}
+	return min(d, limit), true
+}
+
+// RetryBudget is a token bucket capping the total retry rate across every
+// agent that shares it. Each retry spends one token; when the bucket is
+// empty retries fail fast instead of piling onto a recovering fleet.
+type RetryBudget struct {
+	mu       sync.Mutex
+	capacity float64
+	refill   float64 // Tokens per second
+	tokens   float64
+	last     time.Time
+}
+
+// NewRetryBudget returns a full bucket of capacity tokens refilled at
+// perSecond tokens per second
+func NewRetryBudget(capacity int, perSecond float64) *RetryBudget {
+	return &RetryBudget{
+		capacity: float64(capacity),
+		refill:   perSecond,
+		tokens:   float64(capacity),
+		last:     time.Now(),
+	}
+}
+
+// Allow spends a token if one is available; a nil budget always allows
+func (b *RetryBudget) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.refill)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Remaining reports the tokens currently available; a nil budget has
+// unlimited tokens
+func (b *RetryBudget) Remaining() float64 {
+	if b == nil {
+		return math.Inf(1)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return min(b.capacity, b.tokens+time.Since(b.last).Seconds()*b.refill)
+}
+
+// RetryOnStatus is a ShouldRetry that retries transport errors and the
+// given status codes, for agents whose transient failures differ from
+// DefaultShouldRetry's
+func RetryOnStatus(codes ...int) func(attempt int, err error, statusCode int) bool {
+	codes = slices.Clone(codes)
+	return func(_ int, err error, statusCode int) bool {
+		return err != nil || slices.Contains(codes, statusCode)
+	}
+}
+
+// WithRetry sets the agent's retry policy
+func WithRetry(p RetryPolicy) AgentOption {
+	return func(a *FastForthAgent) {
+		if p.ShouldRetry == nil {
+			p.ShouldRetry = DefaultShouldRetry
+		}
+		a.retry = p
+	}
+}
+
+// WithRetryBudget shares one retry token bucket of capacity tokens,
+// refilled at perSecond, across all agents
+func WithRetryBudget(capacity int, perSecond float64) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.budget = NewRetryBudget(capacity, perSecond)
+		for _, agent := range c.agents {
+			agent.budget = c.budget
+		}
+	}
+}
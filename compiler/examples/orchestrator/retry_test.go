@@ -0,0 +1,276 @@
+package orchestrator_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// topSource always draws the top of the requested range, so a jittered
+// backoff returns its ceiling
+type topSource struct{}
+
+func (topSource) Uint64() uint64 { return math.MaxUint64 }
+
+func TestBackoffStrategies(t *testing.T) {
+	const huge = time.Duration(math.MaxInt64 - 1)
+	tests := []struct {
+		name    string
+		backoff orchestrator.Backoff
+		want    map[int]time.Duration // Attempt -> ceiling
+	}{
+		{"constant", orchestrator.ConstantBackoff(time.Second),
+			map[int]time.Duration{1: time.Second, 5: time.Second, 100: time.Second}},
+		{"exponential", orchestrator.NewExponentialBackoff(100*time.Millisecond, 0, topSource{}),
+			map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond}},
+		{"exponential capped", orchestrator.NewExponentialBackoff(100*time.Millisecond, time.Second, topSource{}),
+			map[int]time.Duration{4: 800 * time.Millisecond, 5: time.Second, 40: time.Second, 1000: time.Second}},
+		{"exponential saturates", orchestrator.NewExponentialBackoff(20*time.Second, 0, topSource{}),
+			map[int]time.Duration{29: 20 * time.Second << 28, 30: huge, 31: huge, 64: huge, math.MaxInt: huge}},
+		{"exponential zero base", orchestrator.NewExponentialBackoff(0, time.Second, topSource{}),
+			map[int]time.Duration{1: 0, 10: 0}},
+		{"decorrelated", orchestrator.NewDecorrelatedJitterBackoff(100*time.Millisecond, 0, topSource{}),
+			map[int]time.Duration{1: 100 * time.Millisecond, 2: 300 * time.Millisecond, 3: 900 * time.Millisecond}},
+		{"decorrelated capped", orchestrator.NewDecorrelatedJitterBackoff(100*time.Millisecond, time.Second, topSource{}),
+			map[int]time.Duration{3: 900 * time.Millisecond, 4: time.Second, 1000: time.Second}},
+	}
+	for _, tc := range tests {
+		for attempt, want := range tc.want {
+			if got := tc.backoff.Next(attempt); got != want {
+				t.Errorf("%s: Next(%d) = %v, want %v", tc.name, attempt, got, want)
+			}
+		}
+	}
+
+	// Jittered draws stay within [floor, ceiling]
+	exp := orchestrator.NewExponentialBackoff(time.Second, 0, nil)
+	dec := orchestrator.NewDecorrelatedJitterBackoff(time.Second, 10*time.Second, nil)
+	for attempt := 1; attempt <= 70; attempt++ {
+		if d := exp.Next(attempt); d < 0 {
+			t.Errorf("exponential Next(%d) = %v, want >= 0", attempt, d)
+		}
+		if d := dec.Next(attempt); d < time.Second || d > 10*time.Second {
+			t.Errorf("decorrelated Next(%d) = %v, want within [1s, 10s]", attempt, d)
+		}
+	}
+}
+
+// scriptedAgent serves /spec/validate replies from script in order,
+// repeating the last: "drop" closes the connection unanswered, "200"
+// answers valid, and "429 120" is a status with a Retry-After header.
+// It reports how many requests arrived.
+func scriptedAgent(t *testing.T, script ...string) (*atomic.Int64, string) {
+	t.Helper()
+	var calls atomic.Int64
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		step := script[min(int(calls.Add(1)), len(script))-1]
+		status, retryAfter, _ := strings.Cut(step, " ")
+		if status == "drop" {
+			conn, _, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		code, _ := strconv.Atoi(status)
+		w.WriteHeader(code)
+		io.WriteString(w, `{"valid": true}`)
+	}))
+	return &calls, srv.URL
+}
+
+func TestRetryPolicy(t *testing.T) {
+	never := func(int, error, int) bool { return false }
+	only400 := func(_ int, _ error, code int) bool { return code == http.StatusBadRequest }
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		name      string
+		policy    orchestrator.RetryPolicy
+		get       bool // Validate with an idempotent GET
+		script    []string
+		wantCalls int64
+		wantErr   bool
+		minWait   time.Duration
+	}{
+		{"default retries 503", orchestrator.RetryPolicy{MaxAttempts: 3}, false,
+			[]string{"503", "200"}, 2, false, 0},
+		{"default retries transport errors", orchestrator.RetryPolicy{MaxAttempts: 3}, false,
+			[]string{"drop", "200"}, 2, false, 0},
+		{"default gives up on 400", orchestrator.RetryPolicy{MaxAttempts: 3}, false,
+			[]string{"400", "200"}, 1, true, 0},
+		{"default stops at MaxAttempts", orchestrator.RetryPolicy{MaxAttempts: 3}, false,
+			[]string{"502", "503", "504", "200"}, 3, true, 0},
+		{"MaxAttempts 1 disables retries", orchestrator.RetryPolicy{MaxAttempts: 1}, false,
+			[]string{"503", "200"}, 1, true, 0},
+		{"ShouldRetry can refuse 429", orchestrator.RetryPolicy{MaxAttempts: 3, ShouldRetry: never}, false,
+			[]string{"429", "200"}, 1, true, 0},
+		{"ShouldRetry can allow 400", orchestrator.RetryPolicy{MaxAttempts: 3, ShouldRetry: only400}, false,
+			[]string{"400", "200"}, 2, false, 0},
+		{"RetryOnStatus retries its codes", orchestrator.RetryPolicy{MaxAttempts: 3, ShouldRetry: orchestrator.RetryOnStatus(500)}, false,
+			[]string{"500", "drop", "200"}, 3, false, 0},
+		{"RetryOnStatus ignores other codes", orchestrator.RetryPolicy{MaxAttempts: 3, ShouldRetry: orchestrator.RetryOnStatus(500)}, false,
+			[]string{"503", "200"}, 1, true, 0},
+		{"IdempotentAttempts raises the GET limit", orchestrator.RetryPolicy{MaxAttempts: 1, IdempotentAttempts: 3}, true,
+			[]string{"drop", "drop", "200"}, 3, false, 0},
+		{"IdempotentAttempts leaves POST alone", orchestrator.RetryPolicy{MaxAttempts: 1, IdempotentAttempts: 3}, false,
+			[]string{"drop", "200"}, 1, true, 0},
+		{"GET transport errors bypass ShouldRetry", orchestrator.RetryPolicy{MaxAttempts: 2, ShouldRetry: never}, true,
+			[]string{"drop", "200"}, 2, false, 0},
+		{"GET statuses still ask ShouldRetry", orchestrator.RetryPolicy{MaxAttempts: 2, ShouldRetry: never}, true,
+			[]string{"503", "200"}, 1, true, 0},
+		{"Retry-After replaces the backoff", orchestrator.RetryPolicy{MaxAttempts: 2, Backoff: time.Hour}, false,
+			[]string{"429 0", "200"}, 2, false, 0},
+		{"Retry-After seconds are capped", orchestrator.RetryPolicy{MaxAttempts: 2, MaxRetryAfter: 20 * time.Millisecond}, false,
+			[]string{"429 3600", "200"}, 2, false, 20 * time.Millisecond},
+		{"Retry-After dates are capped", orchestrator.RetryPolicy{MaxAttempts: 2, MaxRetryAfter: 20 * time.Millisecond}, false,
+			[]string{"429 " + future, "200"}, 2, false, 20 * time.Millisecond},
+		{"Retry-After only applies to 429", orchestrator.RetryPolicy{MaxAttempts: 2, Strategy: orchestrator.ConstantBackoff(20 * time.Millisecond)}, false,
+			[]string{"503 0", "200"}, 2, false, 20 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls, url := scriptedAgent(t, tc.script...)
+			opts := []orchestrator.AgentOption{
+				orchestrator.WithRetry(tc.policy),
+				orchestrator.WithJitterSource(rand.NewPCG(1, 2)),
+			}
+			if tc.get {
+				opts = append(opts, orchestrator.WithIdempotentValidate())
+			}
+			agent := newAgent(t, url, opts...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			_, err := agent.ValidateSpec(ctx, square)
+			elapsed := time.Since(start)
+			if (err != nil) != tc.wantErr || errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("ValidateSpec error = %v, want error %v", err, tc.wantErr)
+			}
+			if got := calls.Load(); got != tc.wantCalls {
+				t.Errorf("agent saw %d requests, want %d", got, tc.wantCalls)
+			}
+			if got := agent.Retries(); got != tc.wantCalls-1 {
+				t.Errorf("Retries = %d, want %d", got, tc.wantCalls-1)
+			}
+			if elapsed < tc.minWait || elapsed > tc.minWait+2*time.Second {
+				t.Errorf("took %v, want about %v", elapsed, tc.minWait)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetExhaustion(t *testing.T) {
+	calls, url := scriptedAgent(t, "503")
+	agents := make([]*orchestrator.FastForthAgent, 2)
+	for i := range agents {
+		agents[i] = newAgent(t, url,
+			orchestrator.WithRetry(orchestrator.RetryPolicy{MaxAttempts: 10}),
+			orchestrator.WithJitterSource(rand.NewPCG(1, 2)))
+	}
+	orchestrator.NewCoordinatorWithAgents(agents, orchestrator.WithRetryBudget(3, 0))
+
+	// Both agents draw on the same three tokens, then fail fast
+	want := []int64{4, 5, 6}
+	for i, agent := range []*orchestrator.FastForthAgent{agents[0], agents[1], agents[0]} {
+		var se *orchestrator.StatusError
+		if _, err := agent.ValidateSpec(context.Background(), square); !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("call %d: error = %v, want HTTP 503", i, err)
+		}
+		if got := calls.Load(); got != want[i] {
+			t.Errorf("after call %d the agent saw %d requests, want %d", i, got, want[i])
+		}
+	}
+	if got := agents[0].Retries() + agents[1].Retries(); got != 3 {
+		t.Errorf("retries = %d, want the budget's 3", got)
+	}
+
+	// A budget refills over time
+	budget := orchestrator.NewRetryBudget(1, 1000)
+	if !budget.Allow() {
+		t.Fatal("full budget refused a retry")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if !budget.Allow() {
+		t.Errorf("budget did not refill: %v tokens", budget.Remaining())
+	}
+}
+
+// TestCancelSpendsNoRetryBudget cancels requests in flight and checks
+// that they neither count as retries nor drain the shared budget
+func TestCancelSpendsNoRetryBudget(t *testing.T) {
+	var blocking atomic.Bool
+	blocking.Store(true)
+	var calls atomic.Int64
+	started := make(chan struct{})
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if blocking.Load() {
+			started <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, `{"valid": true}`)
+	}))
+	const inFlight = 4
+	agent := newAgent(t, srv.URL, orchestrator.WithRetry(orchestrator.RetryPolicy{MaxAttempts: 3}))
+	orchestrator.NewCoordinatorWithAgents([]*orchestrator.FastForthAgent{agent},
+		orchestrator.WithRetryBudget(1, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for range inFlight {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := agent.ValidateSpec(ctx, square); !errors.Is(err, context.Canceled) {
+				t.Errorf("cancelled ValidateSpec = %v, want context.Canceled", err)
+			}
+		}()
+	}
+	for range inFlight {
+		<-started
+	}
+	cancel()
+	wg.Wait()
+	if n := agent.Retries(); n != 0 {
+		t.Errorf("cancelled requests counted %d retries", n)
+	}
+
+	// The budget's one token is still there to retry a 503
+	blocking.Store(false)
+	if ok, err := agent.ValidateSpec(context.Background(), square); !ok || err != nil {
+		t.Errorf("ValidateSpec after a 503 = %v, %v; want the budgeted retry to succeed", ok, err)
+	}
+	if n := agent.Retries(); n != 1 {
+		t.Errorf("Retries = %d, want 1", n)
+	}
+}
+
+func TestNilRetryBudget(t *testing.T) {
+	var b *orchestrator.RetryBudget
+	if !b.Allow() || !math.IsInf(b.Remaining(), 1) {
+		t.Errorf("nil budget: Allow = %v, Remaining = %v; want true, +Inf", b.Allow(), b.Remaining())
+	}
+}
@@ -0,0 +1,409 @@
+package orchestrator
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// observeLatency folds a spec's latency into the agent's EMA
+func (a *FastForthAgent) observeLatency(ms, alpha float64) {
+	a.emaMu.Lock()
+	defer a.emaMu.Unlock()
+
+	if a.emaN == 0 {
+		a.ema = ms
+	} else {
+		a.ema = alpha*ms + (1-alpha)*a.ema
+	}
+	a.emaN++
+}
+
+// EMAFailurePenalty scales the latency sample a failed spec feeds into
+// its agent's EMA. The sample is at least the current EMA, so a run of
+// failures keeps raising it however fast they come back, and adaptive
+// routing steers away from the agent until it succeeds again.
+const EMAFailurePenalty = 4
+
+// observeFailure folds a failed spec into the agent's EMA as a slow one
+func (a *FastForthAgent) observeFailure(ms, alpha float64) {
+	ema, _ := a.LatencyEMA()
+	a.observeLatency(max(ms, ema, 1)*EMAFailurePenalty, alpha)
+}
+
+// LatencyEMA returns the agent's moving-average latency in ms and
+// whether any samples have been recorded
+func (a *FastForthAgent) LatencyEMA() (float64, bool) {
+	a.emaMu.Lock()
+	defer a.emaMu.Unlock()
+	return a.ema, a.emaN > 0
+}
+
+// DefaultEMAAlpha weights the newest latency sample in agent EMAs
+const DefaultEMAAlpha = 0.2
+
+// WithAdaptiveRouting sends each spec to an agent chosen at random with
+// probability inversely proportional to its latency EMA, so work shifts
+// toward agents that are currently fast. Failures count as slow specs
+// (see EMAFailurePenalty). alpha in (0, 1] controls how quickly the EMA
+// reacts; 0 keeps DefaultEMAAlpha.
+func WithAdaptiveRouting(alpha float64) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.scheduler = AdaptiveScheduler{rng: c.rng}
+		if alpha > 0 && alpha <= 1 {
+			c.emaAlpha = alpha
+		}
+	}
+}
+
+// WithRandSource seeds all built-in randomness, retry jitter on every
+// agent and adaptive routing, from src so tests are reproducible.
+// Production code can leave it unset to use the global source.
+func WithRandSource(src rand.Source) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.rng = newLockedRand(src)
+		for _, agent := range c.agents {
+			agent.rng = c.rng
+		}
+		if _, ok := c.scheduler.(AdaptiveScheduler); ok {
+			c.scheduler = AdaptiveScheduler{rng: c.rng}
+		}
+	}
+}
+
+// LatencyEMAs returns each agent's current latency EMA in ms, keyed by
+// URL. Agents without successful specs yet are omitted.
+func (c *Coordinator) LatencyEMAs() map[string]float64 {
+	agents := c.Agents()
+	emas := make(map[string]float64, len(agents))
+	for _, agent := range agents {
+		if ema, ok := agent.LatencyEMA(); ok {
+			emas[agent.URL] = ema
+		}
+	}
+	return emas
+}
+
+// Scheduler chooses the agent for each dispatched spec. agents is the
+// live pool and is never empty. Release is called once the spec a
+// picked agent was handed has finished. Implementations must be safe
+// for concurrent use.
+type Scheduler interface {
+	Pick(agents []*FastForthAgent) *FastForthAgent
+	Release(a *FastForthAgent)
+}
+
+// WithScheduler replaces the default round-robin scheduler
+func WithScheduler(s Scheduler) CoordinatorOption {
+	return func(c *Coordinator) {
+		if s != nil {
+			c.scheduler = s
+		}
+	}
+}
+
+// RoundRobinScheduler cycles through agents in order, giving each agent
+// Weight consecutive turns. It is the Coordinator's default.
+type RoundRobinScheduler struct {
+	next atomic.Uint64
+}
+
+func (s *RoundRobinScheduler) Pick(agents []*FastForthAgent) *FastForthAgent {
+	total := 0
+	for _, agent := range agents {
+		total += max(agent.Weight, 1)
+	}
+
+	r := int((s.next.Add(1) - 1) % uint64(total))
+	for _, agent := range agents {
+		if r -= max(agent.Weight, 1); r < 0 {
+			return agent
+		}
+	}
+	return agents[len(agents)-1]
+}
+
+func (*RoundRobinScheduler) Release(*FastForthAgent) {}
+
+// AdaptiveScheduler picks at random with probability proportional to
+// Weight over latency EMA, so work shifts toward agents that are
+// currently fast (see WithAdaptiveRouting)
+type AdaptiveScheduler struct {
+	rng *lockedRand
+}
+
+// NewAdaptiveScheduler draws from src instead of the global source
+func NewAdaptiveScheduler(src rand.Source) AdaptiveScheduler {
+	return AdaptiveScheduler{rng: newLockedRand(src)}
+}
+
+func (s AdaptiveScheduler) Pick(agents []*FastForthAgent) *FastForthAgent {
+	// Unmeasured agents borrow the best known EMA so they get explored
+	emas := make([]float64, len(agents))
+	best := 0.0
+	for i, agent := range agents {
+		emas[i], _ = agent.LatencyEMA()
+		if emas[i] > 0 && (best == 0 || emas[i] < best) {
+			best = emas[i]
+		}
+	}
+
+	weights := make([]float64, len(agents))
+	total := 0.0
+	for i, agent := range agents {
+		ema := emas[i]
+		if ema <= 0 {
+			ema = max(best, 1)
+		}
+		weights[i] = float64(max(agent.Weight, 1)) / ema
+		total += weights[i]
+	}
+
+	r := s.rng.float64() * total
+	for i, w := range weights {
+		if r < w {
+			return agents[i]
+		}
+		r -= w
+	}
+	return agents[len(agents)-1]
+}
+
+func (AdaptiveScheduler) Release(*FastForthAgent) {}
+
+// LeastLoadedScheduler sends each spec to the agent with the fewest
+// outstanding specs relative to its Weight, so a slow agent stops
+// receiving work while it is backed up. Ties rotate round-robin.
+type LeastLoadedScheduler struct {
+	mu          sync.Mutex
+	outstanding map[*FastForthAgent]int
+	next        int
+}
+
+// NewLeastLoadedScheduler returns an empty LeastLoadedScheduler
+func NewLeastLoadedScheduler() *LeastLoadedScheduler {
+	return &LeastLoadedScheduler{outstanding: make(map[*FastForthAgent]int)}
+}
+
+func (s *LeastLoadedScheduler) Pick(agents []*FastForthAgent) *FastForthAgent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Compare n/weight as n*other weight to stay in integers
+	start := s.next % len(agents)
+	s.next++
+	best := agents[start]
+	for i := 1; i < len(agents); i++ {
+		agent := agents[(start+i)%len(agents)]
+		if s.outstanding[agent]*max(best.Weight, 1) < s.outstanding[best]*max(agent.Weight, 1) {
+			best = agent
+		}
+	}
+	s.outstanding[best]++
+	return best
+}
+
+func (s *LeastLoadedScheduler) Release(agent *FastForthAgent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outstanding[agent] <= 1 {
+		delete(s.outstanding, agent)
+		return
+	}
+	s.outstanding[agent]--
+}
+
+// SpecScheduler is optionally implemented by a Scheduler that routes on
+// the spec itself; the Coordinator calls PickSpec instead of Pick
+type SpecScheduler interface {
+	Scheduler
+	PickSpec(spec Specification, agents []*FastForthAgent) *FastForthAgent
+}
+
+// DefaultHashReplicas is the number of ring points per agent
+const DefaultHashReplicas = 100
+
+// HashScheduler routes specs with the same key to the same agent via a
+// consistent-hash ring, so agent-local caches stay warm. The ring holds
+// only live agents: when an agent drops out its keys move to the next
+// point on the ring and everyone else's stay put. Specs with an empty
+// key fall back to round-robin.
+type HashScheduler struct {
+	key      func(Specification) string
+	replicas int
+	fallback RoundRobinScheduler
+
+	mu      sync.Mutex
+	members []*FastForthAgent // Agents the ring was built from
+	ring    []hashPoint       // Sorted by hash
+}
+
+type hashPoint struct {
+	hash  uint64
+	agent *FastForthAgent
+}
+
+// NewHashScheduler hashes key(spec) onto the ring; nil key uses
+// PatternID. replicas <= 0 means DefaultHashReplicas.
+func NewHashScheduler(key func(Specification) string, replicas int) *HashScheduler {
+	if key == nil {
+		key = func(s Specification) string { return s.PatternID }
+	}
+	if replicas <= 0 {
+		replicas = DefaultHashReplicas
+	}
+	return &HashScheduler{key: key, replicas: replicas}
+}
+
+// hash64 is FNV-1a with a splitmix64 finalizer; raw FNV clusters
+// near-identical strings like "url#1", "url#2" on the ring
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (s *HashScheduler) Pick(agents []*FastForthAgent) *FastForthAgent {
+	return s.fallback.Pick(agents)
+}
+
+func (s *HashScheduler) PickSpec(spec Specification, agents []*FastForthAgent) *FastForthAgent {
+	key := s.key(spec)
+	if key == "" {
+		return s.fallback.Pick(agents)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Equal(s.members, agents) {
+		s.members = slices.Clone(agents)
+		s.ring = s.ring[:0]
+		for _, agent := range agents {
+			for r := 0; r < s.replicas; r++ {
+				s.ring = append(s.ring, hashPoint{hash64(agent.URL + "#" + strconv.Itoa(r)), agent})
+			}
+		}
+		sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	}
+
+	h := hash64(key)
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].agent
+}
+
+func (*HashScheduler) Release(*FastForthAgent) {}
+
+// pick asks the scheduler for spec's agent; pair each call with release.
+// Returns nil when the pool is empty.
+func (c *Coordinator) pick(spec Specification) *FastForthAgent {
+	c.agentsMu.RLock()
+	agents := c.live
+	if len(agents) == 0 {
+		// With every agent down, keep routing rather than stall the run
+		agents = c.agents
+	}
+	c.agentsMu.RUnlock()
+
+	if len(agents) == 0 {
+		return nil
+	}
+	if s, ok := c.scheduler.(SpecScheduler); ok {
+		return s.PickSpec(spec, agents)
+	}
+	return c.scheduler.Pick(agents)
+}
+
+func (c *Coordinator) release(agent *FastForthAgent) {
+	if agent != nil {
+		c.scheduler.Release(agent)
+	}
+}
+
+// specHeap orders spec indices by descending Priority, then ascending
+// index, or ascending rank when shuffled
+type specHeap struct {
+	specs []Specification
+	items []int
+	rank  []int // Tie-break order by spec index; nil means index order
+}
+
+func (h *specHeap) Len() int { return len(h.items) }
+
+func (h *specHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if pa, pb := h.specs[a].Priority, h.specs[b].Priority; pa != pb {
+		return pa > pb
+	}
+	if h.rank != nil {
+		return h.rank[a] < h.rank[b]
+	}
+	return a < b
+}
+
+func (h *specHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *specHeap) Push(x any) { h.items = append(h.items, x.(int)) }
+
+func (h *specHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// specQueue is a blocking priority queue feeding Run's workers
+type specQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	heap   specHeap
+	closed bool
+}
+
+func newSpecQueue(specs []Specification) *specQueue {
+	q := &specQueue{heap: specHeap{specs: specs}}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// put marks spec i ready to dispatch
+func (q *specQueue) put(i int) {
+	q.mu.Lock()
+	heap.Push(&q.heap, i)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// take blocks for the highest-priority ready spec; false once closed
+func (q *specQueue) take() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.heap.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.heap.Len() == 0 {
+		return 0, false
+	}
+	return heap.Pop(&q.heap).(int), true
+}
+
+// close releases idle workers
+func (q *specQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
@@ -0,0 +1,219 @@
+package orchestrator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LoadSpecs reads a JSON array of specifications
+func LoadSpecs(path string) ([]Specification, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []Specification
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return specs, nil
+}
+
+// syntheticTemplates are the workloads SyntheticSpecs can generate
+var syntheticTemplates = map[string]Specification{
+	"square": {
+		StackEffect: "( n -- n² )",
+		PatternID:   "DUP_TRANSFORM_001",
+		TestCases: []TestCase{
+			{Input: []int{5}, Output: []int{25}},
+			{Input: []int{0}, Output: []int{0}},
+		},
+	},
+	"factorial": {
+		StackEffect: "( n -- n! )",
+		PatternID:   "RECURSIVE_004",
+		TestCases: []TestCase{
+			{Input: []int{5}, Output: []int{120}},
+			{Input: []int{0}, Output: []int{1}},
+		},
+	},
+	"drop": {
+		StackEffect: "( a b -- a )",
+		PatternID:   "DROP_EXCESS_001",
+		TestCases: []TestCase{
+			{Input: []int{1, 2}, Output: []int{1}},
+		},
+	},
+}
+
+// SyntheticSpecs builds n benchmark specs, func_0 to func_<n-1>, from
+// template: square, factorial, drop, or mixed to cycle through all three
+func SyntheticSpecs(template string, n int) ([]Specification, error) {
+	names := []string{template}
+	if template == "mixed" {
+		names = slices.Sorted(maps.Keys(syntheticTemplates))
+	} else if _, ok := syntheticTemplates[template]; !ok {
+		return nil, fmt.Errorf("unknown template %q: want square, factorial, drop, or mixed", template)
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("need at least 1 spec, got %d", n)
+	}
+
+	specs := make([]Specification, n)
+	for i := range specs {
+		specs[i] = syntheticTemplates[names[i%len(names)]]
+		specs[i].TestCases = slices.Clone(specs[i].TestCases)
+		specs[i].ID = fmt.Sprintf("func_%d", i)
+		specs[i].Word = fmt.Sprintf("function_%d", i)
+	}
+	return specs, nil
+}
+
+// LoadSpecsCSV reads specs from CSV with a header row naming the
+// columns, in any order (unknown columns are ignored):
+//
+//	id,word,stack_effect,pattern_id,input,output
+//	,square,( n -- n² ),DUP_TRANSFORM_001,5;0,25;0
+//	,add,( a b -- c ),,3 4,7
+//
+// word and stack_effect are required. Missing ids become spec_<row>,
+// counting data rows from 0. input and output hold one test case per
+// ";"-separated group of space-separated integers; both must have the
+// same number of groups. Errors name the CSV line.
+func LoadSpecsCSV(r io.Reader) ([]Specification, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"word", "stack_effect"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("csv header: missing %q column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := col[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var specs []Specification
+	for row := 0; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return specs, nil
+		}
+		if err != nil {
+			return nil, err // *csv.ParseError carries the line
+		}
+		line, _ := cr.FieldPos(0)
+
+		spec := Specification{
+			ID:          field(record, "id"),
+			Word:        field(record, "word"),
+			StackEffect: field(record, "stack_effect"),
+			PatternID:   field(record, "pattern_id"),
+		}
+		if spec.ID == "" {
+			spec.ID = fmt.Sprintf("spec_%d", row)
+		}
+		if spec.Word == "" || spec.StackEffect == "" {
+			return nil, fmt.Errorf("line %d: word and stack_effect are required", line)
+		}
+		spec.TestCases, err = parseCSVTestCases(field(record, "input"), field(record, "output"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		specs = append(specs, spec)
+	}
+}
+
+// parseCSVTestCases pairs ";"-separated input and output groups
+func parseCSVTestCases(input, output string) ([]TestCase, error) {
+	if input == "" && output == "" {
+		return nil, nil
+	}
+	ins, outs := strings.Split(input, ";"), strings.Split(output, ";")
+	if len(ins) != len(outs) {
+		return nil, fmt.Errorf("%d input groups but %d output groups", len(ins), len(outs))
+	}
+
+	cases := make([]TestCase, len(ins))
+	for i := range ins {
+		var err error
+		if cases[i].Input, err = parseInts(ins[i]); err != nil {
+			return nil, fmt.Errorf("test case %d input: %w", i+1, err)
+		}
+		if cases[i].Output, err = parseInts(outs[i]); err != nil {
+			return nil, fmt.Errorf("test case %d output: %w", i+1, err)
+		}
+	}
+	return cases, nil
+}
+
+// parseInts parses space-separated integers; blank is an empty stack
+func parseInts(s string) ([]int, error) {
+	nums := []int{}
+	for word := range strings.FieldsSeq(s) {
+		n, err := strconv.Atoi(word)
+		if err != nil {
+			return nil, err
+		}
+		nums = append(nums, n)
+	}
+	return nums, nil
+}
+
+// FailedSpecs returns the specs whose results did not succeed,
+// matched by result Index (falling back to spec ID)
+func FailedSpecs(specs []Specification, results []Result) []Specification {
+	failedIDs := make(map[string]bool)
+	failedIdx := make(map[int]bool)
+	for _, r := range results {
+		if r.Success {
+			continue
+		}
+		if r.Index >= 0 && r.Index < len(specs) && specs[r.Index].ID == r.SpecID {
+			failedIdx[r.Index] = true
+		} else {
+			failedIDs[r.SpecID] = true
+		}
+	}
+
+	var failed []Specification
+	for i, spec := range specs {
+		if failedIdx[i] || failedIDs[spec.ID] {
+			failed = append(failed, spec)
+		}
+	}
+	return failed
+}
+
+// WriteFailedSpecs saves the failed specs in the format LoadSpecs reads,
+// so a retry pass can feed the file straight back into Run
+func WriteFailedSpecs(path string, specs []Specification, results []Result) error {
+	failed := FailedSpecs(specs, results)
+	if failed == nil {
+		failed = []Specification{}
+	}
+
+	data, err := json.MarshalIndent(failed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,313 @@
+package orchestrator
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// StatsBy groups results by the value of label and computes stats for
+// each group. Results without the label fall under "". Elapsed and
+// Throughput are left zero since groups share one wall clock.
+func StatsBy(results []Result, label string) map[string]RunStats {
+	groups := make(map[string]*statsBuilder)
+	for _, r := range results {
+		value := r.Labels[label]
+		b, ok := groups[value]
+		if !ok {
+			b = new(statsBuilder)
+			groups[value] = b
+		}
+		b.add(r)
+	}
+
+	stats := make(map[string]RunStats, len(groups))
+	for value, b := range groups {
+		stats[value] = b.finish(0)
+	}
+	return stats
+}
+
+// RunStats summarizes a completed batch
+type RunStats struct {
+	Total        int           `json:"total"`
+	Succeeded    int           `json:"succeeded"`
+	Failed       int           `json:"failed"`    // Includes skipped specs
+	Skipped      int           `json:"skipped"`   // Never dispatched: dependency failed or run cancelled
+	Cancelled    int           `json:"cancelled"` // Aborted mid-flight
+	CacheHits    int           `json:"cache_hits"`
+	Deduplicated int           `json:"deduplicated"` // Shared another spec's generate call
+	Elapsed      time.Duration `json:"elapsed_ns"`
+	Throughput   float64       `json:"throughput"`     // Specs per second
+	AvgLatencyMS float64       `json:"avg_latency_ms"` // Successful specs that made their own generate call
+	P50LatencyMS float64       `json:"p50_latency_ms"` // Percentiles are read from LatencySketch
+	P95LatencyMS float64       `json:"p95_latency_ms"`
+	P99LatencyMS float64       `json:"p99_latency_ms"`
+
+	// LatencySketch holds the latency distribution so stats from shards
+	// can be merged with correct percentiles (see MergeStats)
+	LatencySketch LatencySketch `json:"latency_sketch,omitempty"`
+}
+
+// sketchGamma is the bucket growth factor: quantiles read back from a
+// LatencySketch are within 1% of a true sample value
+const sketchGamma = 1.02
+
+// LatencySketch is a mergeable latency histogram in the style of
+// DDSketch. A sample of ms milliseconds is counted in bucket
+// ceil(log(ms)/log(sketchGamma)), so merging is just adding counts and
+// the size grows with the spread of latencies, not the sample count.
+type LatencySketch map[int]int
+
+func (s LatencySketch) add(ms float64) {
+	s[int(math.Ceil(math.Log(max(ms, 1e-3))/math.Log(sketchGamma)))]++
+}
+
+// Merge adds o's counts into s
+func (s LatencySketch) Merge(o LatencySketch) {
+	for bucket, n := range o {
+		s[bucket] += n
+	}
+}
+
+// Count is the number of samples in the sketch
+func (s LatencySketch) Count() int {
+	total := 0
+	for _, n := range s {
+		total += n
+	}
+	return total
+}
+
+// Quantile returns the nearest-rank p-th percentile in ms, or 0 if empty
+func (s LatencySketch) Quantile(p float64) float64 {
+	total := s.Count()
+	if total == 0 {
+		return 0
+	}
+	buckets := slices.Sorted(maps.Keys(s))
+	rank := min(max(int(math.Ceil(p/100*float64(total))), 1), total)
+	for _, b := range buckets {
+		if rank -= s[b]; rank <= 0 {
+			// Midpoint of (γ^(b-1), γ^b] in relative terms
+			return 2 * math.Pow(sketchGamma, float64(b)) / (sketchGamma + 1)
+		}
+	}
+	return 0
+}
+
+// ComputeStats aggregates results from a batch that took elapsed
+func ComputeStats(results []Result, elapsed time.Duration) RunStats {
+	var b statsBuilder
+	for _, r := range results {
+		b.add(r)
+	}
+	return b.finish(elapsed)
+}
+
+// statsBuilder folds results into RunStats one at a time. Latencies go
+// into a LatencySketch, so its size depends on their spread, not on how
+// many results it has seen.
+type statsBuilder struct {
+	stats        RunStats
+	samples      int
+	totalLatency float64
+}
+
+func (b *statsBuilder) add(r Result) {
+	b.stats.Total++
+	switch {
+	case r.Success:
+		b.stats.Succeeded++
+		switch {
+		case r.FromCache:
+			b.stats.CacheHits++
+		case r.Shared:
+			b.stats.Deduplicated++
+		default:
+			if b.stats.LatencySketch == nil {
+				b.stats.LatencySketch = make(LatencySketch)
+			}
+			b.stats.LatencySketch.add(r.LatencyMS)
+			b.samples++
+			b.totalLatency += r.LatencyMS
+		}
+	case r.Skipped:
+		b.stats.Skipped++
+	case r.Cancelled:
+		b.stats.Cancelled++
+	}
+}
+
+func (b *statsBuilder) finish(elapsed time.Duration) RunStats {
+	stats := b.stats
+	stats.Elapsed = elapsed
+	stats.Failed = stats.Total - stats.Succeeded
+	if b.samples > 0 {
+		stats.AvgLatencyMS = b.totalLatency / float64(b.samples)
+		stats.P50LatencyMS = stats.LatencySketch.Quantile(50)
+		stats.P95LatencyMS = stats.LatencySketch.Quantile(95)
+		stats.P99LatencyMS = stats.LatencySketch.Quantile(99)
+	}
+	if elapsed > 0 {
+		stats.Throughput = float64(stats.Total) / elapsed.Seconds()
+	}
+	return stats
+}
+
+// EstimateReport compares a pre-run duration estimate with the real run
+type EstimateReport struct {
+	Estimate time.Duration `json:"estimate_ns"`
+	Actual   time.Duration `json:"actual_ns"`
+	Ratio    float64       `json:"ratio"` // Actual / Estimate; >1 means slower than predicted
+}
+
+// ActualVsEstimate scores an EstimateDuration prediction against stats
+func ActualVsEstimate(estimate time.Duration, stats RunStats) EstimateReport {
+	report := EstimateReport{Estimate: estimate, Actual: stats.Elapsed}
+	if estimate > 0 {
+		report.Ratio = float64(stats.Elapsed) / float64(estimate)
+	}
+	return report
+}
+
+// MergeStats combines stats from shards that ran side by side. Counts
+// add up; Elapsed is the longest shard's, and Throughput is recomputed
+// from it. The average latency is weighted by each shard's sample count
+// rather than averaging the averages. Percentiles are read from the
+// merged LatencySketches, since exact percentiles would need every
+// sample; shards without a sketch contribute counts but not percentiles.
+func MergeStats(shards ...RunStats) RunStats {
+	var merged RunStats
+	sketch := make(LatencySketch)
+	samples, totalLatency := 0, 0.0
+
+	for _, s := range shards {
+		merged.Total += s.Total
+		merged.Succeeded += s.Succeeded
+		merged.Failed += s.Failed
+		merged.Skipped += s.Skipped
+		merged.Cancelled += s.Cancelled
+		merged.CacheHits += s.CacheHits
+		merged.Deduplicated += s.Deduplicated
+		merged.Elapsed = max(merged.Elapsed, s.Elapsed)
+
+		n := s.Succeeded - s.CacheHits - s.Deduplicated
+		samples += n
+		totalLatency += s.AvgLatencyMS * float64(n)
+		sketch.Merge(s.LatencySketch)
+	}
+
+	if samples > 0 {
+		merged.AvgLatencyMS = totalLatency / float64(samples)
+	}
+	if len(sketch) > 0 {
+		merged.LatencySketch = sketch
+		merged.P50LatencyMS = sketch.Quantile(50)
+		merged.P95LatencyMS = sketch.Quantile(95)
+		merged.P99LatencyMS = sketch.Quantile(99)
+	}
+	if merged.Elapsed > 0 {
+		merged.Throughput = float64(merged.Total) / merged.Elapsed.Seconds()
+	}
+	return merged
+}
+
+// ThroughputSample is one bucket of a throughput series
+type ThroughputSample struct {
+	Offset time.Duration `json:"offset_ns"` // Bucket start relative to run start
+	Count  int           `json:"count"`     // Specs completed in the bucket
+	Rate   float64       `json:"rate"`      // Specs per second
+}
+
+// ThroughputTracker counts completed specs in fixed-width time buckets.
+// Only the most recent buckets are kept, in a ring buffer, so memory
+// stays flat however long the run. Safe for concurrent use.
+type ThroughputTracker struct {
+	mu     sync.Mutex
+	start  time.Time
+	width  time.Duration
+	counts []int // Ring indexed by bucket number
+	latest int   // Highest bucket number advanced to
+}
+
+// NewThroughputTracker keeps size buckets of the given width
+func NewThroughputTracker(width time.Duration, size int) *ThroughputTracker {
+	t := &ThroughputTracker{
+		width:  max(width, time.Millisecond),
+		counts: make([]int, max(size, 1)),
+	}
+	t.Reset(time.Now())
+	return t
+}
+
+// Reset clears all buckets and restarts the series at start
+func (t *ThroughputTracker) Reset(start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.start = start
+	clear(t.counts)
+	t.latest = 0
+}
+
+// advance zeroes buckets between the latest recorded one and now.
+// Caller holds mu.
+func (t *ThroughputTracker) advance(now time.Time) int {
+	b := int(now.Sub(t.start) / t.width)
+	for n := max(t.latest+1, b-len(t.counts)+1); n <= b; n++ {
+		t.counts[n%len(t.counts)] = 0
+	}
+	t.latest = max(t.latest, b)
+	return b
+}
+
+// Record counts one completion at now
+func (t *ThroughputTracker) Record(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.advance(now)
+	if b < 0 || b <= t.latest-len(t.counts) {
+		return // Older than the ring holds
+	}
+	t.counts[b%len(t.counts)]++
+}
+
+// Series returns retained buckets oldest first, through the current one.
+// Trailing zero buckets show a stall as it happens.
+func (t *ThroughputTracker) Series() []ThroughputSample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.advance(time.Now())
+	first := max(0, b-len(t.counts)+1)
+	samples := make([]ThroughputSample, 0, b-first+1)
+	for n := first; n <= b; n++ {
+		count := t.counts[n%len(t.counts)]
+		samples = append(samples, ThroughputSample{
+			Offset: time.Duration(n) * t.width,
+			Count:  count,
+			Rate:   float64(count) / t.width.Seconds(),
+		})
+	}
+	return samples
+}
+
+// RollingRate returns specs per second over the most recent window,
+// excluding the bucket still in progress
+func (t *ThroughputTracker) RollingRate(window time.Duration) float64 {
+	samples := t.Series()
+	if len(samples) < 2 {
+		return 0
+	}
+	samples = samples[:len(samples)-1]
+
+	n := max(1, min(len(samples), int(window/t.width)))
+	total := 0
+	for _, s := range samples[len(samples)-n:] {
+		total += s.Count
+	}
+	return float64(total) / (time.Duration(n) * t.width).Seconds()
+}
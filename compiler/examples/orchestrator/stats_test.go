@@ -0,0 +1,90 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// within reports whether got is within 1% of want, the sketch's accuracy
+func within(got, want float64) bool {
+	return math.Abs(got-want) <= want/100
+}
+
+func TestComputeStatsSketch(t *testing.T) {
+	const n = 100000
+	results := make([]orchestrator.Result, n, n+3)
+	for i := range results {
+		results[i] = orchestrator.Result{Success: true, LatencyMS: float64(i + 1)}
+	}
+	results = append(results,
+		orchestrator.Result{Success: true, FromCache: true, LatencyMS: 1e9},
+		orchestrator.Result{Success: true, Shared: true, LatencyMS: 1e9},
+		orchestrator.Result{Skipped: true},
+	)
+	stats := orchestrator.ComputeStats(results, 10*time.Second)
+
+	if stats.Total != n+3 || stats.Succeeded != n+2 || stats.Failed != 1 || stats.Skipped != 1 ||
+		stats.CacheHits != 1 || stats.Deduplicated != 1 {
+		t.Errorf("counts = %+v", stats)
+	}
+	if stats.AvgLatencyMS != (n+1)/2.0 {
+		t.Errorf("AvgLatencyMS = %v, want %v", stats.AvgLatencyMS, (n+1)/2.0)
+	}
+	for _, q := range []struct{ got, want float64 }{
+		{stats.P50LatencyMS, n * 0.50},
+		{stats.P95LatencyMS, n * 0.95},
+		{stats.P99LatencyMS, n * 0.99},
+	} {
+		if !within(q.got, q.want) {
+			t.Errorf("percentile = %v, want %v ±1%%", q.got, q.want)
+		}
+	}
+	// Bounded by the latencies' spread (1ms to 100s), not their number
+	if buckets, count := len(stats.LatencySketch), stats.LatencySketch.Count(); buckets > 600 || count != n {
+		t.Errorf("sketch has %d buckets holding %d samples, want at most 600 holding %d", buckets, count, n)
+	}
+
+	// Stats for one shard merge to themselves
+	merged := orchestrator.MergeStats(stats)
+	if merged.P99LatencyMS != stats.P99LatencyMS || merged.AvgLatencyMS != stats.AvgLatencyMS {
+		t.Errorf("MergeStats(stats) = %+v, want %+v", merged, stats)
+	}
+}
+
+func TestRunStream(t *testing.T) {
+	c := orchestrator.NewCoordinatorWithAgents([]*orchestrator.FastForthAgent{
+		orchestrator.NewAgent("mock", &orchestrator.MockAgent{}),
+	})
+	in := make(chan orchestrator.Specification)
+	go func() {
+		defer close(in)
+		for _, spec := range specsN(50) {
+			in <- spec
+		}
+	}()
+	var out bytes.Buffer
+	stats, err := c.RunStream(context.Background(), in, orchestrator.NewNDJSONResultWriter(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 50 || stats.Succeeded != 50 || stats.LatencySketch.Count() != 50 {
+		t.Errorf("stats = %+v", stats)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 50 {
+		t.Fatalf("wrote %d lines, want 50", len(lines))
+	}
+	for _, line := range lines {
+		var r orchestrator.Result
+		if err := json.Unmarshal([]byte(line), &r); err != nil || !r.Success {
+			t.Errorf("line %q: %+v, %v", line, r, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package orchestrator_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+	"github.com/quivent/fifth/compiler/examples/server"
+)
+
+// pki is a throwaway CA with a server certificate for 127.0.0.1 and a
+// client certificate, written as PEM files
+type pki struct {
+	ca, serverCert, serverKey, clientCert, clientKey string
+}
+
+func newPKI(t *testing.T) pki {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	serial := int64(0)
+	issue := func(tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial++
+		tmpl.SerialNumber = big.NewInt(serial)
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key, der
+	}
+	keyPEM := func(name string, key *ecdsa.PrivateKey) string {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return write(name, "EC PRIVATE KEY", der)
+	}
+
+	ca, caKey, caDER := issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, srvKey, srvDER := issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "agent"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+	_, cliKey, cliDER := issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "orchestrator"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+
+	return pki{
+		ca:         write("ca.pem", "CERTIFICATE", caDER),
+		serverCert: write("server.pem", "CERTIFICATE", srvDER),
+		serverKey:  keyPEM("server-key.pem", srvKey),
+		clientCert: write("client.pem", "CERTIFICATE", cliDER),
+		clientKey:  keyPEM("client-key.pem", cliKey),
+	}
+}
+
+// newMTLSAgentServer serves the Go agent over HTTPS (h2 and HTTP/1.1),
+// requiring client certificates signed by p's CA
+func newMTLSAgentServer(t *testing.T, p pki) *httptest.Server {
+	t.Helper()
+	cfg, err := server.LoadTLSConfig(p.serverCert, p.serverKey, p.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(server.New())
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // Rejected handshakes are expected
+	srv.TLS = cfg
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMutualTLS(t *testing.T) {
+	p := newPKI(t)
+	srv := newMTLSAgentServer(t, p)
+	ctx := context.Background()
+
+	clientTLS, err := orchestrator.LoadClientTLSConfig(p.clientCert, p.clientKey, p.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := newAgent(t, srv.URL, orchestrator.WithTLSConfig(clientTLS)).ProcessSpec(ctx, square); !r.Success {
+		t.Errorf("with a client certificate: %s", r.Error)
+	}
+
+	// The same through the individual options
+	pool, err := orchestrator.LoadCABundle(p.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(p.clientCert, p.clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := newAgent(t, srv.URL, orchestrator.WithRootCAs(pool), orchestrator.WithClientCertificate(cert))
+	if r := agent.ProcessSpec(ctx, square); !r.Success {
+		t.Errorf("with WithRootCAs and WithClientCertificate: %s", r.Error)
+	}
+
+	// And over gRPC, which needs h2
+	grpcAgent, err := orchestrator.NewGRPCAgentTLS(srv.URL, clientTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := grpcAgent.ProcessSpec(ctx, square); !r.Success {
+		t.Errorf("gRPC with a client certificate: %s", r.Error)
+	}
+
+	// Trusting the server is not enough without a certificate to present
+	caOnly, err := orchestrator.LoadClientTLSConfig("", "", p.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newAgent(t, srv.URL, orchestrator.WithTLSConfig(caOnly)).Ping(ctx); err == nil {
+		t.Error("Ping without a client certificate succeeded")
+	}
+
+	// Nor is a client certificate without trusting the server's CA
+	noCA, err := orchestrator.LoadClientTLSConfig(p.clientCert, p.clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newAgent(t, srv.URL, orchestrator.WithTLSConfig(noCA)).Ping(ctx); err == nil {
+		t.Error("Ping to a server signed by an untrusted CA succeeded")
+	}
+}
+
+func TestLoadClientTLSConfigNeedsKeyPair(t *testing.T) {
+	p := newPKI(t)
+	if _, err := orchestrator.LoadClientTLSConfig(p.clientCert, "", p.ca); err == nil {
+		t.Error("certificate without key accepted")
+	}
+}
@@ -0,0 +1,329 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceContext identifies a span across processes, as carried by the
+// W3C traceparent header
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// Traceparent renders tc as a sampled traceparent header value
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-01", tc.TraceID, tc.SpanID)
+}
+
+// ParseTraceparent reads a traceparent header value, e.g. to continue a
+// caller's trace with WithTraceContext
+func ParseTraceparent(s string) (TraceContext, bool) {
+	var tc TraceContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.TraceID[:], []byte(parts[1])); err != nil {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(parts[2])); err != nil {
+		return tc, false
+	}
+	return tc, tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// traceKey carries the current TraceContext
+type traceKey struct{}
+
+// WithTraceContext makes spans started under ctx children of tc
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// TraceContextFrom returns the current span's context, if any
+func TraceContextFrom(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
+
+// Span is a finished, timed operation
+type Span struct {
+	Name       string
+	Context    TraceContext
+	ParentID   [8]byte // Zero for a root span
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        string // Why the operation failed; empty on success
+}
+
+// SpanExporter receives finished spans; it must be safe for concurrent use
+type SpanExporter interface {
+	ExportSpan(s Span)
+}
+
+// Tracer records a span per ProcessSpec call with a child per pipeline
+// stage, and puts the current span in each agent request's traceparent
+// header so agents can join the trace. A nil *Tracer records nothing.
+type Tracer struct {
+	exporter SpanExporter
+}
+
+// NewTracer returns a Tracer handing finished spans to exp
+func NewTracer(exp SpanExporter) *Tracer {
+	return &Tracer{exporter: exp}
+}
+
+// activeSpan is a span that has not ended
+type activeSpan struct {
+	tracer *Tracer
+	span   Span
+}
+
+// start opens a span as a child of ctx's current span, or as a new trace
+func (t *Tracer) start(ctx context.Context, name string, attrs map[string]string) (context.Context, *activeSpan) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &activeSpan{tracer: t, span: Span{Name: name, Start: time.Now(), Attributes: attrs}}
+	if parent, ok := TraceContextFrom(ctx); ok {
+		s.span.Context.TraceID, s.span.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		binary.BigEndian.PutUint64(s.span.Context.TraceID[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(s.span.Context.TraceID[8:], rand.Uint64())
+	}
+	binary.BigEndian.PutUint64(s.span.Context.SpanID[:], rand.Uint64()|1) // Never all zero
+	return WithTraceContext(ctx, s.span.Context), s
+}
+
+// end finishes the span, marking it failed when errMsg is set
+func (s *activeSpan) end(errMsg string) {
+	if s == nil {
+		return
+	}
+	s.span.End = time.Now()
+	s.span.Err = errMsg
+	s.tracer.exporter.ExportSpan(s.span)
+}
+
+// setTraceparent propagates ctx's current span to an agent request
+func setTraceparent(ctx context.Context, req *http.Request) {
+	if tc, ok := TraceContextFrom(ctx); ok {
+		req.Header.Set("traceparent", tc.Traceparent())
+	}
+}
+
+// WriterExporter writes each span as a line of JSON, e.g. to a file or
+// stderr for a quick look without a collector
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterExporter returns a WriterExporter writing to w
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *WriterExporter) ExportSpan(s Span) {
+	line := struct {
+		Name       string            `json:"name"`
+		TraceID    string            `json:"trace_id"`
+		SpanID     string            `json:"span_id"`
+		ParentID   string            `json:"parent_id,omitempty"`
+		Start      time.Time         `json:"start"`
+		DurationMS float64           `json:"duration_ms"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Err        string            `json:"error,omitempty"`
+	}{
+		Name:       s.Name,
+		TraceID:    hex.EncodeToString(s.Context.TraceID[:]),
+		SpanID:     hex.EncodeToString(s.Context.SpanID[:]),
+		Start:      s.Start,
+		DurationMS: s.End.Sub(s.Start).Seconds() * 1000,
+		Attributes: s.Attributes,
+		Err:        s.Err,
+	}
+	if s.ParentID != [8]byte{} {
+		line.ParentID = hex.EncodeToString(s.ParentID[:])
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(line)
+}
+
+// OTLP export batching
+const (
+	DefaultOTLPBatchSize     = 512
+	DefaultOTLPFlushInterval = 5 * time.Second
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP/HTTP
+// with JSON encoding, batching them by size and time. Spans that fail to
+// send are dropped. Call Shutdown to send the last batch.
+type OTLPExporter struct {
+	endpoint string // Full URL of the traces endpoint
+	service  string
+	client   *http.Client
+
+	mu    sync.Mutex
+	batch []Span
+
+	kick     chan struct{} // Batch is full
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewOTLPExporter exports to endpoint, the collector's base URL (as in
+// OTEL_EXPORTER_OTLP_ENDPOINT, e.g. http://localhost:4318) or its full
+// /v1/traces URL, naming the process service in the resource
+func NewOTLPExporter(endpoint, service string) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("OTLP endpoint %q: want http(s)://host[:port][/v1/traces]", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	e := &OTLPExporter{
+		endpoint: u.String(),
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.loop()
+	return e, nil
+}
+
+func (e *OTLPExporter) ExportSpan(s Span) {
+	e.mu.Lock()
+	e.batch = append(e.batch, s)
+	full := len(e.batch) >= DefaultOTLPBatchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// loop sends a batch when it fills up or the flush interval passes
+func (e *OTLPExporter) loop() {
+	defer close(e.done)
+	ticker := time.NewTicker(DefaultOTLPFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.kick:
+		case <-e.stop:
+			return
+		}
+		e.Flush(context.Background())
+	}
+}
+
+// Flush sends the spans collected so far
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	batch := e.batch
+	e.batch = nil
+	e.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpRequest(e.service, batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newStatusError(e.endpoint, resp)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Shutdown stops the background sender and flushes what is left. Later
+// calls only flush.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	<-e.done
+	return e.Flush(ctx)
+}
+
+// otlpRequest builds an OTLP ExportTraceServiceRequest in its JSON form
+func otlpRequest(service string, spans []Span) map[string]any {
+	attrs := func(m map[string]string) []map[string]any {
+		out := make([]map[string]any, 0, len(m))
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			out = append(out, map[string]any{"key": k, "value": map[string]string{"stringValue": m[k]}})
+		}
+		return out
+	}
+	out := make([]map[string]any, len(spans))
+	for i, s := range spans {
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.Context.TraceID[:]),
+			"spanId":            hex.EncodeToString(s.Context.SpanID[:]),
+			"name":              s.Name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+			"attributes":        attrs(s.Attributes),
+			"status":            map[string]any{"code": 1}, // STATUS_CODE_OK
+		}
+		if s.ParentID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.ParentID[:])
+		}
+		if s.Err != "" {
+			span["status"] = map[string]any{"code": 2, "message": s.Err} // STATUS_CODE_ERROR
+		}
+		out[i] = span
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource":   map[string]any{"attributes": attrs(map[string]string{"service.name": service})},
+		"scopeSpans": []any{map[string]any{"scope": map[string]string{"name": "fifth/orchestrator"}, "spans": out}},
+	}}}
+}
+
+// WithTracing traces every agent's specs with t, including agents added
+// later and fallbacks (see Tracer)
+func WithTracing(t *Tracer) CoordinatorOption {
+	return func(c *Coordinator) {
+		c.tracer = t
+		for _, agent := range slices.Concat(c.agents, c.fallbacks) {
+			agent.tracer = t
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package orchestrator_test
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+	"github.com/quivent/fifth/compiler/examples/server"
+)
+
+// spanRecorder keeps exported spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []orchestrator.Span
+}
+
+func (r *spanRecorder) ExportSpan(s orchestrator.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+// TestTracing runs a spec under a caller's trace and checks the span
+// tree: ProcessSpec under the caller, a child per stage, and each agent
+// request carrying its stage span as traceparent
+func TestTracing(t *testing.T) {
+	agentServer := server.New()
+	var mu sync.Mutex
+	traceparents := make(map[string]string) // By request path
+	srv, _ := newAgentServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents[r.URL.Path] = r.Header.Get("traceparent")
+		mu.Unlock()
+		agentServer.ServeHTTP(w, r)
+	}))
+	rec := &spanRecorder{}
+	c := orchestrator.NewCoordinatorWithAgents(
+		[]*orchestrator.FastForthAgent{newAgent(t, srv.URL)},
+		orchestrator.WithTracing(orchestrator.NewTracer(rec)),
+	)
+
+	caller, ok := orchestrator.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("ParseTraceparent rejected the W3C example")
+	}
+	ctx := orchestrator.WithTraceContext(context.Background(), caller)
+	results, err := c.Run(ctx, []orchestrator.Specification{square})
+	if err != nil || !results[0].Success {
+		t.Fatalf("Run = %+v, %v", results, err)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	byName := make(map[string]orchestrator.Span)
+	for _, s := range rec.spans {
+		byName[s.Name] = s
+	}
+	root, ok := byName["ProcessSpec"]
+	if !ok {
+		t.Fatalf("no ProcessSpec span in %+v", rec.spans)
+	}
+	if root.Context.TraceID != caller.TraceID || root.ParentID != caller.SpanID {
+		t.Errorf("ProcessSpec is not the caller's child: %+v", root)
+	}
+	if root.Attributes["spec.id"] != square.ID || root.Attributes["agent.url"] != srv.URL || root.Err != "" {
+		t.Errorf("ProcessSpec attributes %v, error %q", root.Attributes, root.Err)
+	}
+	for stage, path := range map[string]string{"validate": "/spec/validate", "generate": "/generate", "verify": "/verify"} {
+		s, ok := byName[stage]
+		if !ok {
+			t.Errorf("no %s span", stage)
+			continue
+		}
+		if s.Context.TraceID != caller.TraceID || s.ParentID != root.Context.SpanID {
+			t.Errorf("%s span is not a child of ProcessSpec: %+v", stage, s)
+		}
+		if s.Start.Before(root.Start) || s.End.After(root.End) {
+			t.Errorf("%s span [%v, %v] outside ProcessSpec [%v, %v]", stage, s.Start, s.End, root.Start, root.End)
+		}
+		mu.Lock()
+		got := traceparents[path]
+		mu.Unlock()
+		if want := s.Context.Traceparent(); got != want {
+			t.Errorf("%s traceparent = %q, want its stage span's %q", path, got, want)
+		}
+	}
+}
+
+// otlpBody is the part of an OTLP/JSON export request the test reads
+type otlpBody struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpAttr `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string     `json:"traceId"`
+				SpanID       string     `json:"spanId"`
+				ParentSpanID string     `json:"parentSpanId"`
+				Name         string     `json:"name"`
+				Start        string     `json:"startTimeUnixNano"`
+				End          string     `json:"endTimeUnixNano"`
+				Attributes   []otlpAttr `json:"attributes"`
+				Status       struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpAttr struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func TestOTLPExporter(t *testing.T) {
+	posts := make(chan otlpBody, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s (%s), want a JSON POST to /v1/traces", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var body otlpBody
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("body %s: %v", data, err)
+		}
+		posts <- body
+	}))
+	defer collector.Close()
+
+	exp, err := orchestrator.NewOTLPExporter(collector.URL, "fifth-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	parent := orchestrator.Span{Name: "ProcessSpec", Start: start, End: start.Add(time.Second),
+		Attributes: map[string]string{"spec.id": "square"}}
+	parent.Context.TraceID[0], parent.Context.SpanID[0] = 1, 2
+	child := orchestrator.Span{Name: "generate", Start: start, End: start.Add(time.Millisecond), Err: "model refused",
+		Context: orchestrator.TraceContext{TraceID: parent.Context.TraceID}, ParentID: parent.Context.SpanID}
+	child.Context.SpanID[0] = 3
+	exp.ExportSpan(parent)
+	exp.ExportSpan(child)
+
+	// Well under the flush interval: only Shutdown sends them
+	select {
+	case <-posts:
+		t.Fatal("spans sent before the batch filled or the interval passed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var body otlpBody
+	select {
+	case body = <-posts:
+	default:
+		t.Fatal("Shutdown sent nothing")
+	}
+
+	if len(body.ResourceSpans) != 1 || len(body.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("body = %+v, want one resource and scope", body)
+	}
+	rs := body.ResourceSpans[0]
+	if attrs := rs.Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue != "fifth-test" {
+		t.Errorf("resource attributes = %+v", attrs)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want 2", len(spans))
+	}
+	for _, s := range spans {
+		id, _ := hex.DecodeString(s.TraceID)
+		sid, _ := hex.DecodeString(s.SpanID)
+		if len(id) != 16 || len(sid) != 8 {
+			t.Errorf("%s: trace ID %q, span ID %q; want 16 and 8 hex bytes", s.Name, s.TraceID, s.SpanID)
+		}
+		startNS, err1 := strconv.ParseInt(s.Start, 10, 64)
+		endNS, err2 := strconv.ParseInt(s.End, 10, 64)
+		if err1 != nil || err2 != nil || endNS <= startNS {
+			t.Errorf("%s: times %q to %q", s.Name, s.Start, s.End)
+		}
+	}
+	p, c := spans[0], spans[1]
+	if p.ParentSpanID != "" || p.Status.Code != 1 || len(p.Attributes) != 1 || p.Attributes[0].Value.StringValue != "square" {
+		t.Errorf("parent span = %+v", p)
+	}
+	if c.ParentSpanID != p.SpanID || c.TraceID != p.TraceID || c.Status.Code != 2 || c.Status.Message != "model refused" {
+		t.Errorf("child span = %+v, want an errored child of %s", c, p.SpanID)
+	}
+
+	// A second Shutdown neither panics nor sends again
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown = %v", err)
+	}
+	select {
+	case body := <-posts:
+		t.Errorf("second Shutdown sent %+v", body)
+	default:
+	}
+}
+
+// A full batch goes out without waiting for the interval
+func TestOTLPExporterBatchSize(t *testing.T) {
+	sizes := make(chan int, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body otlpBody
+		json.NewDecoder(r.Body).Decode(&body)
+		sizes <- len(body.ResourceSpans[0].ScopeSpans[0].Spans)
+	}))
+	defer collector.Close()
+	exp, err := orchestrator.NewOTLPExporter(collector.URL+"/v1/traces", "fifth-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exp.Shutdown(context.Background())
+
+	now := time.Now()
+	for range orchestrator.DefaultOTLPBatchSize {
+		exp.ExportSpan(orchestrator.Span{Name: "s", Start: now, End: now})
+	}
+	select {
+	case n := <-sizes:
+		if n != orchestrator.DefaultOTLPBatchSize {
+			t.Errorf("batch of %d spans, want %d", n, orchestrator.DefaultOTLPBatchSize)
+		}
+	case <-time.After(orchestrator.DefaultOTLPFlushInterval / 2):
+		t.Error("full batch not sent")
+	}
+}
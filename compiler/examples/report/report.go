@@ -0,0 +1,202 @@
+// Package report serializes orchestrator results to machine-readable
+// formats, so batch runs can feed CI dashboards and spreadsheets:
+// JSON lines, CSV, and JUnit XML. Each format has a Write function and
+// a Reporter for Coordinator users.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// WriteJSONLines writes one JSON object per result, in order
+func WriteJSONLines(w io.Writer, results []orchestrator.Result) error {
+	rw := orchestrator.NewNDJSONResultWriter(w)
+	for _, r := range results {
+		if err := rw.Write(r); err != nil {
+			return err
+		}
+	}
+	return rw.Flush()
+}
+
+// CSVHeader names the columns WriteCSV writes
+var CSVHeader = []string{
+	"index", "spec_id", "request_id", "agent", "success", "category", "error",
+	"latency_ms", "validate_ms", "generate_ms", "verify_ms", "test_count",
+	"skipped", "cancelled", "timed_out", "from_cache", "fallback", "code",
+}
+
+// WriteCSV writes a header row and one row per result. Code keeps its
+// newlines inside a quoted field; Tests and Labels are left out.
+func WriteCSV(w io.Writer, results []orchestrator.Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+	ms := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	for _, r := range results {
+		cw.Write([]string{
+			strconv.Itoa(r.Index), r.SpecID, r.RequestID, r.Agent,
+			strconv.FormatBool(r.Success), string(r.Category), r.Error,
+			ms(r.LatencyMS), ms(r.ValidateMS), ms(r.GenerateMS), ms(r.VerifyMS),
+			strconv.Itoa(r.TestCount),
+			strconv.FormatBool(r.Skipped), strconv.FormatBool(r.Cancelled),
+			strconv.FormatBool(r.TimedOut), strconv.FormatBool(r.FromCache),
+			strconv.FormatBool(r.Fallback), r.Code,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// junitSuites and below are the JUnit XML schema understood by Jenkins,
+// GitLab and GitHub test reporters
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitProblem `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// infrastructure marks categories reported as JUnit errors rather than
+// failures: the agent, not the spec, is at fault
+var infrastructure = map[orchestrator.FailureCategory]bool{
+	orchestrator.FailNetwork: true,
+	orchestrator.FailTimeout: true,
+}
+
+// WriteJUnit writes results as one JUnit test suite named suite, a test
+// case per spec. Skipped and cancelled specs are skipped cases; network
+// and timeout failures are errors, other failures are failures.
+func WriteJUnit(w io.Writer, suite string, results []orchestrator.Result) error {
+	s := junitSuite{Name: suite, Tests: len(results), Cases: make([]junitCase, 0, len(results))}
+	var totalMS float64
+	for _, r := range results {
+		totalMS += r.LatencyMS
+		c := junitCase{
+			Name:      r.SpecID,
+			Classname: suite,
+			Time:      seconds(r.LatencyMS),
+			SystemOut: r.Code,
+		}
+		problem := &junitProblem{Message: firstLine(r.Error), Type: string(r.Category), Text: r.Error}
+		switch {
+		case r.Success:
+		case r.Skipped || r.Cancelled:
+			c.Skipped = problem
+			s.Skipped++
+		case infrastructure[r.Category]:
+			c.Error = problem
+			s.Errors++
+		default:
+			c.Failure = problem
+			s.Failures++
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Time = seconds(totalMS)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{s}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(ms float64) string {
+	return strconv.FormatFloat(ms/1000, 'f', 3, 64)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// JSONLines is an orchestrator.Reporter for WriteJSONLines
+type JSONLines struct {
+	W io.Writer // Nil means os.Stdout
+}
+
+func (r JSONLines) Report(_ orchestrator.RunStats, results []orchestrator.Result) error {
+	return WriteJSONLines(orStdout(r.W), results)
+}
+
+// CSV is an orchestrator.Reporter for WriteCSV
+type CSV struct {
+	W io.Writer // Nil means os.Stdout
+}
+
+func (r CSV) Report(_ orchestrator.RunStats, results []orchestrator.Result) error {
+	return WriteCSV(orStdout(r.W), results)
+}
+
+// JUnit is an orchestrator.Reporter for WriteJUnit
+type JUnit struct {
+	W     io.Writer // Nil means os.Stdout
+	Suite string    // Test suite name; "" means "fifth"
+}
+
+func (r JUnit) Report(_ orchestrator.RunStats, results []orchestrator.Result) error {
+	suite := r.Suite
+	if suite == "" {
+		suite = "fifth"
+	}
+	return WriteJUnit(orStdout(r.W), suite, results)
+}
+
+func orStdout(w io.Writer) io.Writer {
+	if w == nil {
+		return os.Stdout
+	}
+	return w
+}
+
+// New returns the Reporter for a format name: jsonl, csv or junit
+func New(format string, w io.Writer) (orchestrator.Reporter, error) {
+	switch format {
+	case "jsonl", "ndjson":
+		return JSONLines{W: w}, nil
+	case "csv":
+		return CSV{W: w}, nil
+	case "junit":
+		return JUnit{W: w}, nil
+	}
+	return nil, fmt.Errorf("unknown report format %q (want jsonl, csv or junit)", format)
+}
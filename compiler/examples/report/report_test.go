@@ -0,0 +1,159 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+var results = []orchestrator.Result{
+	{Index: 0, SpecID: "square", Agent: "http://a:8080", Success: true, Code: ": square\n  dup * ;", LatencyMS: 1500, TestCount: 1},
+	{Index: 1, SpecID: "quoted", Success: false, Error: "bad \"quote\", comma\nsecond line", Category: orchestrator.FailGeneration, LatencyMS: 250},
+	{Index: 2, SpecID: "mismatch", Category: orchestrator.FailStackMismatch, Error: "stack mismatch"},
+	{Index: 3, SpecID: "test", Category: orchestrator.FailTest, Error: "4 square -> 15"},
+	{Index: 4, SpecID: "down", Category: orchestrator.FailNetwork, Error: "connection refused"},
+	{Index: 5, SpecID: "slow", Category: orchestrator.FailTimeout, TimedOut: true, Error: "spec timeout exceeded"},
+	{Index: 6, SpecID: "skipped", Category: orchestrator.FailSkipped, Skipped: true, Error: "dependency failed"},
+	{Index: 7, SpecID: "cancelled", Category: orchestrator.FailCancelled, Cancelled: true, Error: "context canceled"},
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\": square\n  dup * ;\"") {
+		t.Errorf("multi-line Code is not a quoted field:\n%s", buf.String())
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(results)+1 || !slices.Equal(rows[0], CSVHeader) {
+		t.Fatalf("%d rows, header %q; want %d rows under CSVHeader", len(rows), rows[0], len(results)+1)
+	}
+	col := func(row []string, name string) string { return row[slices.Index(CSVHeader, name)] }
+	square, quoted := rows[1], rows[2]
+	for name, want := range map[string]string{
+		"index": "0", "spec_id": "square", "agent": "http://a:8080", "success": "true",
+		"latency_ms": "1500.000", "test_count": "1", "code": ": square\n  dup * ;",
+	} {
+		if got := col(square, name); got != want {
+			t.Errorf("square %s = %q, want %q", name, got, want)
+		}
+	}
+	if got := col(quoted, "error"); got != results[1].Error {
+		t.Errorf("quoted error = %q, want %q", got, results[1].Error)
+	}
+	if got := col(rows[7], "skipped"); got != "true" {
+		t.Errorf("skipped column = %q", got)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, "nightly", results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Errorf("missing XML header:\n%s", buf.String())
+	}
+	var doc junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Suites) != 1 {
+		t.Fatalf("%d suites, want 1", len(doc.Suites))
+	}
+	s := doc.Suites[0]
+	if s.Name != "nightly" || s.Tests != 8 || s.Failures != 3 || s.Errors != 2 || s.Skipped != 2 || s.Time != "1.750" {
+		t.Errorf("suite %s: tests=%d failures=%d errors=%d skipped=%d time=%s; want nightly 8 3 2 2 1.750",
+			s.Name, s.Tests, s.Failures, s.Errors, s.Skipped, s.Time)
+	}
+
+	want := map[string]string{
+		"square": "pass", "quoted": "failure", "mismatch": "failure", "test": "failure",
+		"down": "error", "slow": "error", "skipped": "skipped", "cancelled": "skipped",
+	}
+	for _, c := range s.Cases {
+		got := "pass"
+		var p *junitProblem
+		switch {
+		case c.Failure != nil:
+			got, p = "failure", c.Failure
+		case c.Error != nil:
+			got, p = "error", c.Error
+		case c.Skipped != nil:
+			got, p = "skipped", c.Skipped
+		}
+		if got != want[c.Name] {
+			t.Errorf("%s reported as %s, want %s", c.Name, got, want[c.Name])
+		}
+		if c.Classname != "nightly" {
+			t.Errorf("%s classname = %q", c.Name, c.Classname)
+		}
+		if p != nil && strings.Contains(p.Message, "\n") {
+			t.Errorf("%s message %q spans lines", c.Name, p.Message)
+		}
+	}
+	if c := s.Cases[1]; c.Failure.Message != `bad "quote", comma` || c.Failure.Text != results[1].Error || c.Failure.Type != "generation" {
+		t.Errorf("quoted failure = %+v", *c.Failure)
+	}
+	if c := s.Cases[0]; c.SystemOut != results[0].Code || c.Time != "1.500" {
+		t.Errorf("square case = %+v", c)
+	}
+}
+
+func TestWriteJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSONLines(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(results) {
+		t.Fatalf("%d lines, want %d", len(lines), len(results))
+	}
+	for i, line := range lines {
+		var r orchestrator.Result
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(r, results[i]) {
+			t.Errorf("line %d = %+v, want %+v", i, r, results[i])
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	for format, want := range map[string]orchestrator.Reporter{
+		"jsonl":  JSONLines{W: &buf},
+		"ndjson": JSONLines{W: &buf},
+		"csv":    CSV{W: &buf},
+		"junit":  JUnit{W: &buf},
+	} {
+		if got, err := New(format, &buf); err != nil || got != want {
+			t.Errorf("New(%q) = %#v, %v; want %#v", format, got, err, want)
+		}
+	}
+	for _, format := range []string{"", "xml", "JSONL", "text"} {
+		if r, err := New(format, &buf); err == nil {
+			t.Errorf("New(%q) = %#v, want an error", format, r)
+		}
+	}
+
+	// JUnit defaults its suite name
+	r, _ := New("junit", &buf)
+	buf.Reset()
+	if err := r.Report(orchestrator.RunStats{}, results[:1]); err != nil || !strings.Contains(buf.String(), `<testsuite name="fifth"`) {
+		t.Errorf("JUnit report = %v:\n%s", err, buf.String())
+	}
+}
@@ -0,0 +1,1255 @@
+// Package server is a Fast Forth agent written in Go: it answers
+// /spec/validate, /generate (and /generate/stream), /verify and /run
+// itself, so an agent needs no external runtime. The same operations
+// are served as the gRPC service in agentpb/agent.proto on the same
+// port, over HTTP/2. Code comes from a pattern table mirroring the
+// compiler's pattern library, falling back to a small search over
+// core words checked against the spec's test cases.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/agentpb"
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+	"github.com/quivent/fifth/compiler/examples/stackeffect"
+	"github.com/quivent/fifth/compiler/examples/verify"
+)
+
+// Version is reported by /version
+const Version = "go-0.1.0"
+
+// Patterns maps pattern IDs to definition bodies, after the compiler's
+// default pattern library
+var Patterns = map[string]string{
+	"DUP_TRANSFORM_001":    "dup *",
+	"DUP_TRANSFORM_002":    "dup 1+",
+	"CONDITIONAL_001":      "dup 0 < if negate then",
+	"CONDITIONAL_002":      "2dup < if swap then drop",
+	"ACCUMULATOR_LOOP_001": "0 swap 1+ 1 ?do i + loop",
+	"ACCUMULATOR_LOOP_002": "1 swap 1+ 1 ?do i * loop",
+	"RECURSIVE_001":        "dup 2 < if drop 1 else dup 1- recurse * then",
+	"RECURSIVE_002":        "dup 2 < if else dup 1- recurse swap 2 - recurse + then",
+	"RECURSIVE_004":        "dup 2 < if drop 1 else dup 1- recurse * then",
+	"TAIL_RECURSIVE_001":   "over 1 <= if nip else over * swap 1- swap recurse then",
+	"BINARY_OP_001":        "+",
+	"BINARY_OP_002":        "+ 2 /",
+	"UNARY_OP_001":         "negate",
+	"UNARY_OP_002":         "2 *",
+	"STACK_MANIP_001":      "swap rot",
+	"STACK_MANIP_002":      "tuck",
+	"OPTIMIZATION_001":     "3 lshift",
+	"OPTIMIZATION_002":     "1 and 0=",
+	"DROP_EXCESS_001":      "drop",
+}
+
+// searchWords are tried, in sequences of up to DefaultSearchDepth, for
+// specs whose pattern is unknown
+var searchWords = []string{
+	"dup", "drop", "swap", "over", "rot", "nip", "tuck",
+	"+", "-", "*", "/", "mod", "negate", "abs", "min", "max",
+	"1+", "1-", "2*", "2/", "0=", "=", "<", ">", "and", "or",
+	"0", "1", "2",
+}
+
+// DefaultSearchDepth is the longest word sequence Generate searches
+const DefaultSearchDepth = 3
+
+// Server is an agent's HTTP handler
+type Server struct {
+	mux         *http.ServeMux
+	logger      *slog.Logger
+	searchDepth int
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithLogger logs each request to l
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithSearchDepth bounds the fallback search; 0 disables it
+func WithSearchDepth(n int) Option {
+	return func(s *Server) {
+		s.searchDepth = max(n, 0)
+	}
+}
+
+// LoadTLSConfig builds the agent's server TLS from PEM files. With
+// clientCAFile set, clients must present a certificate signed by one of
+// its CAs (mutual TLS).
+func LoadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("server certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pool, err := orchestrator.LoadCABundle(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// New returns a Server ready to be passed to http.Serve
+func New(opts ...Option) *Server {
+	s := &Server{
+		mux:         http.NewServeMux(),
+		logger:      slog.New(slog.DiscardHandler),
+		searchDepth: DefaultSearchDepth,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("/spec/validate", s.handleValidate)
+	s.mux.HandleFunc("POST /spec/validate/batch", s.handleValidateBatch)
+	s.mux.HandleFunc("POST /generate", s.handleGenerate)
+	s.mux.HandleFunc("POST /generate/stream", s.handleGenerateStream)
+	s.mux.HandleFunc("POST /verify", s.handleVerify)
+	s.mux.HandleFunc("POST /verify/batch", s.handleVerifyBatch)
+	s.mux.HandleFunc("POST /run", s.handleRun)
+	s.mux.HandleFunc("POST /"+agentpb.Service+"/{method}", s.handleGRPC)
+	s.mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+	s.mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, orchestrator.AgentVersion{
+			Version:      Version,
+			Protocol:     orchestrator.ProtocolVersion,
+			Capabilities: []string{"validate/batch", "verify/batch", "run", "grpc", "generate/stream"},
+		})
+	})
+	return s
+}
+
+// ShutdownTimeout bounds how long ListenAndServe drains in-flight
+// requests once its context is done
+const ShutdownTimeout = 5 * time.Second
+
+// ListenAndServe runs a Server built from opts on addr until ctx is
+// done, then drains in-flight requests before returning. With
+// tlsConfig set it serves HTTPS; either way HTTP/2 carries the gRPC
+// service and JSON clients keep HTTP/1.1.
+func ListenAndServe(ctx context.Context, addr string, tlsConfig *tls.Config, opts ...Option) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := New(opts...)
+	return serve(ctx, ln, tlsConfig, s, s.logger)
+}
+
+// serve is ListenAndServe for any handler on an open listener
+func serve(ctx context.Context, ln net.Listener, tlsConfig *tls.Config, h http.Handler, logger *slog.Logger) error {
+	srv := &http.Server{
+		Handler:           h,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+
+	drained := make(chan error, 1)
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		drained <- srv.Shutdown(shutdownCtx)
+	})
+	defer stop()
+
+	logger.Info("agent listening", "addr", ln.Addr().String(), "version", Version,
+		"tls", tlsConfig != nil, "mtls", tlsConfig != nil && tlsConfig.ClientCAs != nil)
+	var err error
+	if tlsConfig != nil {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// Serve returns as soon as Shutdown starts; wait for the drain
+	if err := <-drained; err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	s.mux.ServeHTTP(w, r)
+	attrs := []any{"method", r.Method, "path", r.URL.Path, "elapsed", time.Since(start)}
+	if tc, ok := orchestrator.ParseTraceparent(r.Header.Get("traceparent")); ok {
+		// Join the orchestrator's trace in the logs
+		attrs = append(attrs, "trace_id", hex.EncodeToString(tc.TraceID[:]), "parent_span_id", hex.EncodeToString(tc.SpanID[:]))
+	}
+	s.logger.Debug("request", attrs...)
+}
+
+// writeJSON encodes v as the response body
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // Keep "->" in test lines readable
+	enc.Encode(v)
+}
+
+// MaxBodySize bounds a JSON request body
+const MaxBodySize = 4 << 20
+
+// decode reads a JSON request body into v, answering 413 past
+// MaxBodySize and 400 on any other failure
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodySize)).Decode(v)
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooBig):
+		http.Error(w, fmt.Sprintf("request body exceeds %d bytes", tooBig.Limit), http.StatusRequestEntityTooLarge)
+		return false
+	case err != nil:
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// sinceMS is the elapsed time in fractional milliseconds
+func sinceMS(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
+
+// Validate reports why spec cannot be worked on, or nil
+func Validate(spec orchestrator.Specification) error {
+	if strings.TrimSpace(spec.Word) == "" {
+		return errors.New("spec has no word")
+	}
+	if _, err := stackeffect.Parse(spec.StackEffect); err != nil {
+		return err
+	}
+	return orchestrator.CheckArity(spec)
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var spec orchestrator.Specification
+	switch r.Method {
+	case http.MethodGet:
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("spec")), &spec); err != nil {
+			http.Error(w, "bad spec parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if !decode(w, r, &spec) {
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := struct {
+		Valid     bool    `json:"valid"`
+		Error     string  `json:"error,omitempty"`
+		LatencyMS float64 `json:"latency_ms"`
+	}{Valid: true}
+	if err := Validate(spec); err != nil {
+		resp.Valid, resp.Error = false, err.Error()
+	}
+	resp.LatencyMS = sinceMS(start)
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleValidateBatch(w http.ResponseWriter, r *http.Request) {
+	var specs []orchestrator.Specification
+	if !decode(w, r, &specs) {
+		return
+	}
+	valid := make([]bool, len(specs))
+	for i, spec := range specs {
+		valid[i] = Validate(spec) == nil
+	}
+	writeJSON(w, map[string][]bool{"valid": valid})
+}
+
+func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var spec orchestrator.Specification
+	if !decode(w, r, &spec) {
+		return
+	}
+
+	resp := struct {
+		Code      string   `json:"code,omitempty"`
+		Tests     []string `json:"tests,omitempty"`
+		Error     string   `json:"error,omitempty"`
+		LatencyMS float64  `json:"latency_ms"`
+	}{}
+	code, err := s.GenerateContext(r.Context(), spec)
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Code, resp.Tests = code, testLines(spec)
+	}
+	resp.LatencyMS = sinceMS(start)
+	writeJSON(w, resp)
+}
+
+// handleGenerateStream answers with server-sent events: the
+// definition's first line as soon as the spec validates, status events
+// while the body is looked for, then the body and "event: done"
+func (s *Server) handleGenerateStream(w http.ResponseWriter, r *http.Request) {
+	var spec orchestrator.Specification
+	if !decode(w, r, &spec) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	rc := http.NewResponseController(w)
+	send := func(event, data string) {
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		for _, line := range strings.Split(data, "\n") {
+			fmt.Fprintf(w, "data: %s\n", line)
+		}
+		fmt.Fprint(w, "\n")
+		rc.Flush()
+	}
+
+	if err := Validate(spec); err != nil {
+		send("error", err.Error())
+		return
+	}
+	send("", header(spec))
+	body, err := s.generate(r.Context(), spec, func(status string) {
+		if r.Context().Err() == nil {
+			send("status", status)
+		}
+	})
+	if err != nil {
+		send("error", err.Error())
+		return
+	}
+	send("", "  "+body+" ;")
+	send("done", "")
+}
+
+// verifyResponse is one /verify answer
+type verifyResponse struct {
+	Valid       bool                `json:"valid"`
+	Diagnostics []verify.Diagnostic `json:"diagnostics,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	LatencyMS   float64             `json:"latency_ms"`
+}
+
+func verifyPair(p orchestrator.CodeEffectPair) verifyResponse {
+	start := time.Now()
+	ok, diags, err := verify.StackEffect(p.Code, p.Effect)
+	resp := verifyResponse{Valid: ok, Diagnostics: diags}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	resp.LatencyMS = sinceMS(start)
+	return resp
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var p orchestrator.CodeEffectPair
+	if !decode(w, r, &p) {
+		return
+	}
+	writeJSON(w, verifyPair(p))
+}
+
+func (s *Server) handleVerifyBatch(w http.ResponseWriter, r *http.Request) {
+	var pairs []orchestrator.CodeEffectPair
+	if !decode(w, r, &pairs) {
+		return
+	}
+	valid := make([]bool, len(pairs))
+	for i, p := range pairs {
+		valid[i] = verifyPair(p).Valid
+	}
+	writeJSON(w, map[string][]bool{"valid": valid})
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code  string `json:"code"`
+		Input []int  `json:"input"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+
+	resp := struct {
+		Output []int  `json:"output"`
+		Error  string `json:"error,omitempty"`
+	}{Output: []int{}}
+	out, err := Run(req.Code, req.Input)
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Output = out
+	}
+	writeJSON(w, resp)
+}
+
+// handleGRPC answers a unary call to the gRPC service. The reply is
+// always HTTP 200; the outcome travels in the grpc-status trailer.
+func (s *Server) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	// 1. gRPC needs HTTP/2 and its own content type
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), agentpb.ContentType) {
+		http.Error(w, "gRPC requires HTTP/2 and content-type "+agentpb.ContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	ctx := r.Context()
+	if d, ok := agentpb.ParseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	// 2. Run the method; generation stops when ctx ends, and a deadline
+	// that passed meanwhile discards the reply
+	reply, err := s.grpcCall(ctx, r.PathValue("method"), r.Body)
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = agentpb.Errorf(agentpb.DeadlineExceeded, "deadline exceeded")
+	case ctx.Err() != nil:
+		err = agentpb.Errorf(agentpb.Canceled, "request cancelled")
+	}
+
+	// 3. Reply message, then status trailers
+	w.Header().Set("Content-Type", agentpb.ContentType)
+	w.WriteHeader(http.StatusOK)
+	st := &agentpb.Status{Code: agentpb.OK}
+	if err == nil {
+		w.Write(agentpb.Frame(reply.Marshal()))
+	} else if !errors.As(err, &st) {
+		st = agentpb.Errorf(agentpb.Internal, "%v", err)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(st.Code)))
+	w.Header().Set(http.TrailerPrefix+"Grpc-Message", agentpb.EncodeStatusMessage(st.Message))
+}
+
+// grpcCall reads the request message from body and runs method on it
+func (s *Server) grpcCall(ctx context.Context, method string, body io.Reader) (agentpb.Message, error) {
+	msg, err := agentpb.ReadFrame(body, agentpb.DefaultMaxMessageSize)
+	var st *agentpb.Status
+	if errors.As(err, &st) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, agentpb.Errorf(agentpb.InvalidArgument, "read request: %v", err)
+	}
+
+	switch method {
+	case "Validate":
+		spec, err := decodeSpec(msg)
+		if err != nil {
+			return nil, err
+		}
+		reply := &agentpb.ValidateReply{Valid: true}
+		if err := Validate(spec); err != nil {
+			reply.Valid, reply.Reason = false, err.Error()
+		}
+		return reply, nil
+	case "Generate":
+		spec, err := decodeSpec(msg)
+		if err != nil {
+			return nil, err
+		}
+		if err := Validate(spec); err != nil {
+			return nil, agentpb.Errorf(agentpb.InvalidArgument, "%v", err)
+		}
+		code, err := s.GenerateContext(ctx, spec)
+		if err != nil {
+			return nil, agentpb.Errorf(agentpb.NotFound, "%v", err)
+		}
+		return &agentpb.GenerateReply{Code: code, Tests: testLines(spec)}, nil
+	case "Verify":
+		var req agentpb.VerifyRequest
+		if err := req.Unmarshal(msg); err != nil {
+			return nil, agentpb.Errorf(agentpb.InvalidArgument, "%v", err)
+		}
+		ok, diags, err := verify.StackEffect(req.Code, req.Effect)
+		if err != nil {
+			return nil, agentpb.Errorf(agentpb.InvalidArgument, "%v", err)
+		}
+		reply := &agentpb.VerifyReply{Valid: ok}
+		for _, d := range diags {
+			reply.Diagnostics = append(reply.Diagnostics, agentpb.Diagnostic{Word: d.Word, Index: d.Index, Message: d.Message})
+		}
+		return reply, nil
+	case "Process":
+		spec, err := decodeSpec(msg)
+		if err != nil {
+			return nil, err
+		}
+		return s.process(ctx, spec), nil
+	}
+	return nil, agentpb.Errorf(agentpb.Unimplemented, "unknown method %s", method)
+}
+
+// decodeSpec unmarshals a Spec message
+func decodeSpec(msg []byte) (orchestrator.Specification, error) {
+	var pb agentpb.Spec
+	if err := pb.Unmarshal(msg); err != nil {
+		return orchestrator.Specification{}, agentpb.Errorf(agentpb.InvalidArgument, "%v", err)
+	}
+	spec := orchestrator.Specification{
+		ID:          pb.ID,
+		Word:        pb.Word,
+		StackEffect: pb.StackEffect,
+		PatternID:   pb.PatternID,
+		Definitions: pb.Definitions,
+		RequestID:   pb.RequestID,
+	}
+	for _, tc := range pb.TestCases {
+		spec.TestCases = append(spec.TestCases, orchestrator.TestCase{Input: tc.Input, Output: tc.Output})
+	}
+	return spec, nil
+}
+
+// process validates, generates and verifies spec, reporting failures
+// with the categories the orchestrator's pipeline would give them
+func (s *Server) process(ctx context.Context, spec orchestrator.Specification) *agentpb.Result {
+	start := time.Now()
+	r := &agentpb.Result{SpecID: spec.ID}
+	fail := func(category orchestrator.FailureCategory, err error) *agentpb.Result {
+		r.Error, r.Category, r.LatencyMS = err.Error(), string(category), sinceMS(start)
+		return r
+	}
+
+	if err := Validate(spec); err != nil {
+		return fail(orchestrator.FailInvalidSpec, err)
+	}
+	code, err := s.GenerateContext(ctx, spec)
+	if err != nil {
+		return fail(orchestrator.FailGeneration, err)
+	}
+	ok, diags, err := verify.StackEffect(strings.Join(append(slices.Clip(spec.Definitions), code), "\n"), spec.StackEffect)
+	switch {
+	case err != nil:
+		return fail(orchestrator.FailStackMismatch, fmt.Errorf("Stack effect mismatch: %w", err))
+	case !ok:
+		return fail(orchestrator.FailStackMismatch, fmt.Errorf("Stack effect mismatch: %s", diags[0]))
+	}
+
+	r.Success, r.Code, r.Tests, r.LatencyMS = true, code, testLines(spec), sinceMS(start)
+	return r
+}
+
+// testLines renders spec's test cases in Forth's T{ ... -> ... }T form
+func testLines(spec orchestrator.Specification) []string {
+	lines := make([]string, len(spec.TestCases))
+	for i, tc := range spec.TestCases {
+		lines[i] = fmt.Sprintf("T{ %s%s -> %s }T", ints(tc.Input), spec.Word, strings.TrimSpace(ints(tc.Output)))
+	}
+	return lines
+}
+
+// ints renders xs space-separated with a trailing space
+func ints(xs []int) string {
+	var b strings.Builder
+	for _, x := range xs {
+		b.WriteString(strconv.Itoa(x))
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// Generate returns a colon definition of spec.Word: the body of its
+// pattern if the test cases pass on it, or else the shortest sequence
+// of core words, and of words from spec.Definitions, that passes them
+// and matches the stack effect
+func (s *Server) Generate(spec orchestrator.Specification) (string, error) {
+	return s.GenerateContext(context.Background(), spec)
+}
+
+// GenerateContext is Generate with a search that gives up once ctx ends
+func (s *Server) GenerateContext(ctx context.Context, spec orchestrator.Specification) (string, error) {
+	if err := Validate(spec); err != nil {
+		return "", err
+	}
+	body, err := s.generate(ctx, spec, func(string) {})
+	if err != nil {
+		return "", err
+	}
+	return header(spec) + "  " + body + " ;", nil
+}
+
+// header is the first line of spec's colon definition
+func header(spec orchestrator.Specification) string {
+	return fmt.Sprintf(": %s %s\n", spec.Word, spec.StackEffect)
+}
+
+// generate finds the body of spec's definition, reporting progress
+// through status
+func (s *Server) generate(ctx context.Context, spec orchestrator.Specification, status func(string)) (string, error) {
+	prelude := strings.Join(spec.Definitions, "\n")
+	defs, err := compile(prelude)
+	if err != nil {
+		return "", fmt.Errorf("definitions: %w", err)
+	}
+	define := func(body string) string {
+		return header(spec) + "  " + body + " ;"
+	}
+
+	body, ok := Patterns[spec.PatternID]
+	switch {
+	case ok && passes(defs, define(body), spec):
+		return body, nil
+	case ok:
+		status(fmt.Sprintf("pattern %s fails the test cases", spec.PatternID))
+	default:
+		status(fmt.Sprintf("unknown pattern %q", spec.PatternID))
+	}
+	if len(spec.TestCases) == 0 || s.searchDepth == 0 {
+		return "", fmt.Errorf("unknown pattern %q", spec.PatternID)
+	}
+
+	// Search breadth-first so the shortest program wins
+	words := append(slices.Clone(searchWords), defs.names...)
+	seqs := [][]string{nil}
+	for depth := 1; depth <= s.searchDepth; depth++ {
+		status(fmt.Sprintf("searching %d-word programs", depth))
+		var next [][]string
+		for _, seq := range seqs {
+			for _, w := range words {
+				if err := ctx.Err(); err != nil {
+					return "", err
+				}
+				cand := append(slices.Clip(seq), w)
+				body := strings.Join(cand, " ")
+				code := define(body)
+				if passes(defs, code, spec) {
+					if ok, _, err := verify.StackEffect(prelude+"\n"+code, spec.StackEffect); err != nil || ok {
+						return body, nil
+					}
+				}
+				if depth < s.searchDepth {
+					next = append(next, cand)
+				}
+			}
+		}
+		seqs = next
+	}
+	return "", fmt.Errorf("unknown pattern %q and no program of up to %d words passes the test cases",
+		spec.PatternID, s.searchDepth)
+}
+
+// passes runs every test case of spec against code compiled after defs
+func passes(defs *program, code string, spec orchestrator.Specification) bool {
+	p, err := compileAfter(defs, code)
+	if err != nil {
+		return false
+	}
+	for _, tc := range spec.TestCases {
+		out, err := p.run(tc.Input)
+		if err != nil || !slices.Equal(out, tc.Output) {
+			return false
+		}
+	}
+	return true
+}
+
+// Interpreter limits, so generated code cannot hang or exhaust the agent
+const (
+	maxSteps = 1_000_000
+	maxCalls = 1_000
+	maxStack = 10_000
+)
+
+// ErrStepLimit is returned by Run for code that runs too long
+var ErrStepLimit = errors.New("step limit exceeded")
+
+type opcode int
+
+const (
+	opLit      opcode = iota
+	opPrim            // Built-in word
+	opCall            // Colon definition arg
+	opBranch          // Jump to arg
+	opBranch0         // Pop; jump to arg if zero
+	opDo              // ( limit start -- ) enter a loop
+	opQDo             // As opDo, but jump to arg when limit = start
+	opLoop            // Step by 1; back to arg until done
+	opPlusLoop        // Step by the popped value; back to arg until done
+	opLeave           // Drop the loop and jump to arg
+	opExit
+)
+
+type op struct {
+	code opcode
+	arg  int
+	prim func(*machine) error
+}
+
+// program is compiled code: each colon definition plus the words
+// outside any definition
+type program struct {
+	names  []string // Definition names, in order
+	bodies [][]op   // One per name, then main's if it is not a definition
+	top    []string // Words outside definitions
+	main   int      // Body Run executes: top level if any, else the last definition
+}
+
+// control is an open control structure during compilation
+type control struct {
+	word   string // if, else, do, begin, while
+	at     int    // Op to patch, or loop start
+	leaves []int  // do: leave ops to patch at loop
+	while  int    // begin: while op to patch at repeat
+}
+
+// tokens splits code into lowercase words, dropping comments and ." strings
+func tokens(code string) []string {
+	var fields, out []string
+	for _, line := range strings.Split(code, "\n") {
+		lineFields := strings.Fields(line)
+		if i := slices.Index(lineFields, "\\"); i >= 0 {
+			lineFields = lineFields[:i]
+		}
+		fields = append(fields, lineFields...)
+	}
+	for i := 0; i < len(fields); i++ {
+		switch w := strings.ToLower(fields[i]); w {
+		case "(":
+			for i < len(fields) && !strings.HasSuffix(fields[i], ")") {
+				i++
+			}
+		case ".\"":
+			for i++; i < len(fields) && !strings.HasSuffix(fields[i], "\""); i++ {
+			}
+		default:
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// compile translates code into a program
+func compile(code string) (*program, error) {
+	return compileAfter(&program{}, code)
+}
+
+// compileAfter compiles code as if it followed the code of defs, which
+// is left as it was, so shared definitions are compiled only once
+func compileAfter(defs *program, code string) (*program, error) {
+	p := &program{
+		names:  slices.Clip(defs.names),
+		bodies: slices.Clip(defs.bodies[:len(defs.names)]),
+		top:    slices.Clip(defs.top),
+	}
+	index := make(map[string]int, len(p.names))
+	for i, name := range p.names {
+		index[name] = i
+	}
+
+	toks := tokens(code)
+	for i := 0; i < len(toks); i++ {
+		if toks[i] != ":" {
+			p.top = append(p.top, toks[i])
+			continue
+		}
+		if i+1 >= len(toks) {
+			return nil, errors.New(": without a name")
+		}
+		name := toks[i+1]
+		self := len(p.bodies)
+		p.bodies = append(p.bodies, nil)
+		end := slices.Index(toks[i+2:], ";")
+		if end < 0 {
+			return nil, fmt.Errorf("definition of %q has no ;", name)
+		}
+		body, err := compileBody(p, index, self, toks[i+2:i+2+end])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		p.bodies[self] = body
+		p.names = append(p.names, name)
+		index[name] = self
+		i += 2 + end
+	}
+
+	switch {
+	case len(p.top) > 0:
+		body, err := compileBody(p, index, -1, p.top)
+		if err != nil {
+			return nil, err
+		}
+		p.main = len(p.bodies)
+		p.bodies = append(p.bodies, body)
+	case len(p.bodies) > 0:
+		p.main = len(p.bodies) - 1
+	default:
+		p.main = len(p.bodies)
+		p.bodies = append(p.bodies, nil)
+	}
+	return p, nil
+}
+
+// compileWord compiles a word with no control-flow role
+func compileWord(p *program, index map[string]int, self int, w string) (op, error) {
+	if f, ok := prims[w]; ok {
+		return op{code: opPrim, prim: f}, nil
+	}
+	if n, err := strconv.Atoi(w); err == nil {
+		return op{code: opLit, arg: n}, nil
+	}
+	if w == "recurse" && self >= 0 {
+		return op{code: opCall, arg: self}, nil
+	}
+	if def, ok := index[w]; ok {
+		return op{code: opCall, arg: def}, nil
+	}
+	if w == "exit" {
+		return op{code: opExit}, nil
+	}
+	return op{}, fmt.Errorf("undefined word %q", w)
+}
+
+// compileBody compiles a definition body, resolving control flow
+func compileBody(p *program, index map[string]int, self int, words []string) ([]op, error) {
+	var (
+		ops   []op
+		stack []control
+	)
+	emit := func(o op) int {
+		ops = append(ops, o)
+		return len(ops) - 1
+	}
+	pop := func(w string, openers ...string) (control, error) {
+		if len(stack) == 0 || !slices.Contains(openers, stack[len(stack)-1].word) {
+			return control{}, fmt.Errorf("unbalanced %s", w)
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return c, nil
+	}
+
+	for _, w := range words {
+		switch w {
+		case "if":
+			stack = append(stack, control{word: "if", at: emit(op{code: opBranch0})})
+		case "else":
+			c, err := pop(w, "if")
+			if err != nil {
+				return nil, err
+			}
+			at := emit(op{code: opBranch})
+			ops[c.at].arg = len(ops)
+			stack = append(stack, control{word: "else", at: at})
+		case "then":
+			c, err := pop(w, "if", "else")
+			if err != nil {
+				return nil, err
+			}
+			ops[c.at].arg = len(ops)
+		case "do", "?do":
+			code := opDo
+			if w == "?do" {
+				code = opQDo
+			}
+			at := emit(op{code: code})
+			stack = append(stack, control{word: "do", at: at})
+		case "loop", "+loop":
+			c, err := pop(w, "do")
+			if err != nil {
+				return nil, err
+			}
+			code := opLoop
+			if w == "+loop" {
+				code = opPlusLoop
+			}
+			emit(op{code: code, arg: c.at + 1})
+			ops[c.at].arg = len(ops)
+			for _, l := range c.leaves {
+				ops[l].arg = len(ops)
+			}
+		case "leave":
+			i := len(stack) - 1
+			for i >= 0 && stack[i].word != "do" {
+				i--
+			}
+			if i < 0 {
+				return nil, errors.New("leave outside a loop")
+			}
+			stack[i].leaves = append(stack[i].leaves, emit(op{code: opLeave}))
+		case "begin":
+			stack = append(stack, control{word: "begin", at: len(ops), while: -1})
+		case "while":
+			if len(stack) == 0 || stack[len(stack)-1].word != "begin" {
+				return nil, errors.New("unbalanced while")
+			}
+			stack[len(stack)-1].while = emit(op{code: opBranch0})
+		case "until", "again", "repeat":
+			c, err := pop(w, "begin")
+			if err != nil {
+				return nil, err
+			}
+			code := opBranch0
+			if w != "until" {
+				code = opBranch
+			}
+			emit(op{code: code, arg: c.at})
+			if w == "repeat" && c.while >= 0 {
+				ops[c.while].arg = len(ops)
+			}
+		default:
+			o, err := compileWord(p, index, self, w)
+			if err != nil {
+				return nil, err
+			}
+			emit(o)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("unterminated %s", stack[len(stack)-1].word)
+	}
+	return ops, nil
+}
+
+// loopFrame is one active do loop
+type loopFrame struct {
+	index, limit int
+}
+
+// machine runs a program
+type machine struct {
+	prog   *program
+	stack  []int
+	rstack []int
+	loops  []loopFrame
+	steps  int
+}
+
+var errUnderflow = errors.New("stack underflow")
+
+func (m *machine) push(v int) error {
+	if len(m.stack) >= maxStack {
+		return errors.New("stack overflow")
+	}
+	m.stack = append(m.stack, v)
+	return nil
+}
+
+// pop removes and returns the top n items, deepest first
+func (m *machine) pop(n int) ([]int, error) {
+	if len(m.stack) < n {
+		return nil, errUnderflow
+	}
+	top := slices.Clone(m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:len(m.stack)-n]
+	return top, nil
+}
+
+// Run executes code with input on the stack and returns the final
+// stack, bottom first. When code holds colon definitions and nothing
+// else, the last definition runs.
+func Run(code string, input []int) ([]int, error) {
+	p, err := compile(code)
+	if err != nil {
+		return nil, err
+	}
+	return p.run(input)
+}
+
+// run executes p's main body as Run does
+func (p *program) run(input []int) ([]int, error) {
+	m := &machine{prog: p, stack: slices.Clone(input)}
+	if err := m.exec(p.main, 0); err != nil {
+		return nil, err
+	}
+	if m.stack == nil {
+		return []int{}, nil
+	}
+	return m.stack, nil
+}
+
+func (m *machine) exec(body, calls int) error {
+	if calls > maxCalls {
+		return errors.New("call depth exceeded")
+	}
+	ops := m.prog.bodies[body]
+	for pc := 0; pc < len(ops); {
+		if m.steps++; m.steps > maxSteps {
+			return ErrStepLimit
+		}
+		o := ops[pc]
+		pc++
+
+		switch o.code {
+		case opLit:
+			if err := m.push(o.arg); err != nil {
+				return err
+			}
+		case opPrim:
+			if err := o.prim(m); err != nil {
+				return err
+			}
+		case opCall:
+			if err := m.exec(o.arg, calls+1); err != nil {
+				return err
+			}
+		case opBranch:
+			pc = o.arg
+		case opBranch0:
+			v, err := m.pop(1)
+			if err != nil {
+				return err
+			}
+			if v[0] == 0 {
+				pc = o.arg
+			}
+		case opDo, opQDo:
+			v, err := m.pop(2)
+			if err != nil {
+				return err
+			}
+			if o.code == opQDo && v[0] == v[1] {
+				pc = o.arg
+				continue
+			}
+			m.loops = append(m.loops, loopFrame{index: v[1], limit: v[0]})
+		case opLoop, opPlusLoop:
+			if len(m.loops) == 0 {
+				return errors.New("loop without do")
+			}
+			step := 1
+			if o.code == opPlusLoop {
+				v, err := m.pop(1)
+				if err != nil {
+					return err
+				}
+				step = v[0]
+			}
+			f := &m.loops[len(m.loops)-1]
+			before := f.index - f.limit
+			f.index += step
+			// Done once the index crosses the limit-1/limit boundary
+			if (before ^ (f.index - f.limit)) >= 0 {
+				pc = o.arg
+			} else {
+				m.loops = m.loops[:len(m.loops)-1]
+			}
+		case opLeave:
+			if len(m.loops) > 0 {
+				m.loops = m.loops[:len(m.loops)-1]
+			}
+			pc = o.arg
+		case opExit:
+			return nil
+		}
+	}
+	return nil
+}
+
+// flag converts a Go bool to a Forth flag
+func flag(b bool) int {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+// binary makes a ( a b -- f(a,b) ) primitive
+func binary(f func(a, b int) (int, error)) func(*machine) error {
+	return func(m *machine) error {
+		v, err := m.pop(2)
+		if err != nil {
+			return err
+		}
+		r, err := f(v[0], v[1])
+		if err != nil {
+			return err
+		}
+		return m.push(r)
+	}
+}
+
+// unary makes a ( a -- f(a) ) primitive
+func unary(f func(a int) int) func(*machine) error {
+	return func(m *machine) error {
+		v, err := m.pop(1)
+		if err != nil {
+			return err
+		}
+		return m.push(f(v[0]))
+	}
+}
+
+// shuffle makes a primitive that pops n items and pushes them back in
+// the order given by the indexes in out
+func shuffle(n int, out ...int) func(*machine) error {
+	return func(m *machine) error {
+		v, err := m.pop(n)
+		if err != nil {
+			return err
+		}
+		for _, i := range out {
+			if err := m.push(v[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// pure lifts an infallible binary operation
+func pure(f func(a, b int) int) func(*machine) error {
+	return binary(func(a, b int) (int, error) { return f(a, b), nil })
+}
+
+var errDivZero = errors.New("division by zero")
+
+// prims are the words Run knows besides control flow
+var prims = map[string]func(*machine) error{
+	"dup": shuffle(1, 0, 0), "drop": shuffle(1), "swap": shuffle(2, 1, 0),
+	"over": shuffle(2, 0, 1, 0), "rot": shuffle(3, 1, 2, 0), "-rot": shuffle(3, 2, 0, 1),
+	"nip": shuffle(2, 1), "tuck": shuffle(2, 1, 0, 1),
+	"2dup": shuffle(2, 0, 1, 0, 1), "2drop": shuffle(2), "2swap": shuffle(4, 2, 3, 0, 1),
+	"2over": shuffle(4, 0, 1, 2, 3, 0, 1),
+
+	"+": pure(func(a, b int) int { return a + b }),
+	"-": pure(func(a, b int) int { return a - b }),
+	"*": pure(func(a, b int) int { return a * b }),
+	"/": binary(func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errDivZero
+		}
+		return a / b, nil
+	}),
+	"mod": binary(func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errDivZero
+		}
+		return a % b, nil
+	}),
+	"min":    pure(func(a, b int) int { return min(a, b) }),
+	"max":    pure(func(a, b int) int { return max(a, b) }),
+	"lshift": pure(func(a, b int) int { return int(uint(a) << uint(b)) }),
+	"rshift": pure(func(a, b int) int { return int(uint(a) >> uint(b)) }),
+	"and":    pure(func(a, b int) int { return a & b }),
+	"or":     pure(func(a, b int) int { return a | b }),
+	"xor":    pure(func(a, b int) int { return a ^ b }),
+	"=":      pure(func(a, b int) int { return flag(a == b) }),
+	"<>":     pure(func(a, b int) int { return flag(a != b) }),
+	"<":      pure(func(a, b int) int { return flag(a < b) }),
+	">":      pure(func(a, b int) int { return flag(a > b) }),
+	"<=":     pure(func(a, b int) int { return flag(a <= b) }),
+	">=":     pure(func(a, b int) int { return flag(a >= b) }),
+
+	"negate": unary(func(a int) int { return -a }),
+	"abs":    unary(func(a int) int { return max(a, -a) }),
+	"invert": unary(func(a int) int { return ^a }),
+	"1+":     unary(func(a int) int { return a + 1 }),
+	"1-":     unary(func(a int) int { return a - 1 }),
+	"2*":     unary(func(a int) int { return a * 2 }),
+	"2/":     unary(func(a int) int { return a >> 1 }),
+	"0=":     unary(func(a int) int { return flag(a == 0) }),
+	"0<":     unary(func(a int) int { return flag(a < 0) }),
+	"0>":     unary(func(a int) int { return flag(a > 0) }),
+	"0<>":    unary(func(a int) int { return flag(a != 0) }),
+
+	"/mod": func(m *machine) error {
+		v, err := m.pop(2)
+		if err != nil {
+			return err
+		}
+		if v[1] == 0 {
+			return errDivZero
+		}
+		if err := m.push(v[0] % v[1]); err != nil {
+			return err
+		}
+		return m.push(v[0] / v[1])
+	},
+	"*/": func(m *machine) error {
+		v, err := m.pop(3)
+		if err != nil {
+			return err
+		}
+		if v[2] == 0 {
+			return errDivZero
+		}
+		return m.push(v[0] * v[1] / v[2])
+	},
+	"true":  func(m *machine) error { return m.push(-1) },
+	"false": func(m *machine) error { return m.push(0) },
+
+	// Output is discarded; only the stack is reported
+	".":    shuffle(1),
+	"emit": shuffle(1),
+	"cr":   func(*machine) error { return nil },
+
+	">r": func(m *machine) error {
+		v, err := m.pop(1)
+		if err != nil {
+			return err
+		}
+		m.rstack = append(m.rstack, v[0])
+		return nil
+	},
+	"r>": func(m *machine) error {
+		if len(m.rstack) == 0 {
+			return errors.New("return stack underflow")
+		}
+		v := m.rstack[len(m.rstack)-1]
+		m.rstack = m.rstack[:len(m.rstack)-1]
+		return m.push(v)
+	},
+	"r@": func(m *machine) error {
+		if len(m.rstack) == 0 {
+			return errors.New("return stack underflow")
+		}
+		return m.push(m.rstack[len(m.rstack)-1])
+	},
+	"i": func(m *machine) error {
+		if len(m.loops) < 1 {
+			return errors.New("i outside a loop")
+		}
+		return m.push(m.loops[len(m.loops)-1].index)
+	},
+	"j": func(m *machine) error {
+		if len(m.loops) < 2 {
+			return errors.New("j outside a nested loop")
+		}
+		return m.push(m.loops[len(m.loops)-2].index)
+	},
+	"unloop": func(m *machine) error {
+		if len(m.loops) > 0 {
+			m.loops = m.loops[:len(m.loops)-1]
+		}
+		return nil
+	},
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+var square = orchestrator.Specification{
+	ID:          "square",
+	Word:        "square",
+	StackEffect: "( n -- n² )",
+	PatternID:   "DUP_TRANSFORM_001",
+	TestCases:   []orchestrator.TestCase{{Input: []int{4}, Output: []int{16}}},
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		code       string
+		input, out []int
+	}{
+		{"dup *", []int{7}, []int{49}},
+		{": sq dup * ; : quad sq sq ;", []int{2}, []int{16}},
+		{"0 swap 1+ 1 ?do i + loop", []int{4}, []int{10}},
+		{": fact dup 2 < if drop 1 else dup 1- recurse * then ;", []int{5}, []int{120}},
+		{"drop", []int{1}, []int{}},
+	}
+	for _, tc := range tests {
+		out, err := Run(tc.code, tc.input)
+		if err != nil || !slices.Equal(out, tc.out) {
+			t.Errorf("Run(%q, %v) = %v, %v; want %v", tc.code, tc.input, out, err, tc.out)
+		}
+	}
+
+	for _, code := range []string{"+", "frobnicate", "begin 0 until"} {
+		if out, err := Run(code, []int{1}); err == nil {
+			t.Errorf("Run(%q) = %v, want an error", code, out)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	code, err := New().Generate(square)
+	if want := ": square ( n -- n² )\n  dup * ;"; err != nil || code != want {
+		t.Errorf("Generate(square) = %q, %v; want %q", code, err, want)
+	}
+
+	// An unknown pattern falls back to the shortest passing program
+	double := orchestrator.Specification{
+		Word:        "double",
+		StackEffect: "( n -- n )",
+		PatternID:   "NO_SUCH_PATTERN",
+		TestCases:   []orchestrator.TestCase{{Input: []int{3}, Output: []int{6}}, {Input: []int{0}, Output: []int{0}}},
+	}
+	code, err = New().Generate(double)
+	if want := ": double ( n -- n )\n  2* ;"; err != nil || code != want {
+		t.Errorf("Generate(double) = %q, %v; want %q", code, err, want)
+	}
+	if code, err := New(WithSearchDepth(0)).Generate(double); err == nil {
+		t.Errorf("Generate without search = %q, want an error", code)
+	}
+
+	if _, err := New().Generate(orchestrator.Specification{StackEffect: "( n -- n )"}); err == nil {
+		t.Error("Generate accepted a spec with no word")
+	}
+
+	// The search may call words from Definitions
+	fourth := orchestrator.Specification{
+		Word:        "fourth",
+		StackEffect: "( n -- n )",
+		Definitions: []string{": sq ( n -- n ) dup * ;"},
+		TestCases:   []orchestrator.TestCase{{Input: []int{3}, Output: []int{81}}},
+	}
+	code, err = New().Generate(fourth)
+	if want := ": fourth ( n -- n )\n  sq sq ;"; err != nil || code != want {
+		t.Errorf("Generate(fourth) = %q, %v; want %q", code, err, want)
+	}
+	fourth.Definitions = []string{": sq dup * "}
+	if _, err := New().Generate(fourth); err == nil || !strings.Contains(err.Error(), "definitions") {
+		t.Errorf("Generate with an unterminated definition = %v, want a definitions error", err)
+	}
+}
+
+// A search stops once its request is cancelled
+func TestGenerateCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	unsolvable := orchestrator.Specification{
+		Word:        "unsolvable",
+		StackEffect: "( n -- n )",
+		TestCases:   []orchestrator.TestCase{{Input: []int{3}, Output: []int{12345}}},
+	}
+	if code, err := New(WithSearchDepth(10)).GenerateContext(ctx, unsolvable); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateContext = %q, %v; want %v", code, err, context.Canceled)
+	}
+	// Patterns need no search
+	if _, err := New().GenerateContext(ctx, square); err != nil {
+		t.Errorf("GenerateContext(square) = %v", err)
+	}
+
+	body, _ := json.Marshal(unsolvable)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequestWithContext(ctx, "POST", "/generate", strings.NewReader(string(body)))
+	New(WithSearchDepth(10)).ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), context.Canceled.Error()) {
+		t.Errorf("/generate after cancel = %s, want %q", w.Body, context.Canceled)
+	}
+}
+
+// request sends a request to a new Server and returns the recorded reply
+func request(method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	New().ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestHandlers(t *testing.T) {
+	specJSON, err := json.Marshal(square)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, target, body string
+		want                 string // Substring of the reply body
+	}{
+		{"GET", "/health", "", `"status":"ok"`},
+		{"GET", "/version", "", `"version":"` + Version + `"`},
+		{"GET", "/spec/validate?spec=" + url.QueryEscape(string(specJSON)), "", `"valid":true`},
+		{"POST", "/spec/validate", `{"word":"x","stack_effect":"( n n )"}`, `"valid":false`},
+		{"POST", "/spec/validate/batch", "[" + string(specJSON) + `,{}]`, `"valid":[true,false]`},
+		{"POST", "/generate", string(specJSON), `"tests":["T{ 4 square -> 16 }T"]`},
+		{"POST", "/generate/stream", string(specJSON), "data:   dup * ;\n\nevent: done\n"},
+		{"POST", "/verify", `{"code":"dup *","effect":"( n -- n )"}`, `"valid":true`},
+		{"POST", "/verify/batch", `[{"code":"dup","effect":"( n -- n )"}]`, `"valid":[false]`},
+		{"POST", "/run", `{"code":"dup *","input":[3]}`, `"output":[9]`},
+		{"POST", "/run", `{"code":"+","input":[]}`, `"error":"stack underflow"`},
+	}
+	for _, tc := range tests {
+		w := request(tc.method, tc.target, tc.body)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s %s = %d %q, want 200 with %q", tc.method, tc.target, w.Code, w.Body, tc.want)
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	if w := request("POST", "/run", `{"code":`); w.Code != http.StatusBadRequest {
+		t.Errorf("truncated JSON: status %d, want 400", w.Code)
+	}
+	big := `{"code":"` + strings.Repeat("dup drop ", MaxBodySize/9+1) + `"}`
+	for _, target := range []string{"/run", "/generate", "/spec/validate", "/verify/batch"} {
+		if w := request("POST", target, big); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("POST %s with %d bytes: status %d, want 413", target, len(big), w.Code)
+		}
+	}
+}
+
+func TestServeDrains(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, ln, nil, h, slog.New(slog.DiscardHandler)) }()
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	<-started
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if got := <-body; got != "done" {
+		t.Errorf("in-flight request got %q, want done", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve = %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("server still accepting after shutdown")
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	// Borrow httptest's certificate for 127.0.0.1 and a client trusting it
+	ts := httptest.NewTLSServer(nil)
+	cfg, client := ts.TLS.Clone(), ts.Client()
+	ts.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, ln, cfg, New(), slog.New(slog.DiscardHandler)) }()
+
+	resp, err := client.Get("https://" + ln.Addr().String() + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("GET /health over TLS: status %d, TLS %v", resp.StatusCode, resp.TLS != nil)
+	}
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("serve = %v", err)
+	}
+}
+
+func TestListenAndServeBadAddr(t *testing.T) {
+	if err := ListenAndServe(context.Background(), "127.0.0.1:-1", nil); err == nil {
+		t.Error("ListenAndServe on an invalid port succeeded")
+	}
+}
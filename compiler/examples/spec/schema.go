@@ -0,0 +1,478 @@
+package spec
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// schema is the subset of JSON Schema (draft 2020-12) that Schema
+// writes and the strict validator reads
+type schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Ref         string             `json:"$ref,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        any                `json:"type,omitempty"` // A type name, or names when null is allowed
+	Properties  map[string]*schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Additional  any                `json:"additionalProperties,omitempty"` // false, or the schema of map values
+	Items       *schema            `json:"items,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	AnyOf       []*schema          `json:"anyOf,omitempty"` // Alternatives of distinct JSON types
+	Defs        map[string]*schema `json:"$defs,omitempty"`
+
+	fields []string       // Property names in struct order, for suggestions
+	re     *regexp.Regexp // Compiled Pattern
+}
+
+var (
+	specType         = reflect.TypeFor[orchestrator.Specification]()
+	testCaseType     = reflect.TypeFor[orchestrator.TestCase]()
+	durationType     = reflect.TypeFor[time.Duration]()
+	specDurationType = reflect.TypeFor[orchestrator.Duration]()
+	categoryType     = reflect.TypeFor[orchestrator.FailureCategory]()
+)
+
+// optional lists fields that may be left out even though their json
+// tag has no omitempty; other fields without omitempty are required
+var optional = map[reflect.Type][]string{
+	specType: {"id", "pattern_id", "test_cases"},
+}
+
+// fileExtras adds the fields spec files may carry to the types that
+// Load reads them into (see fileFields)
+var fileExtras = map[reflect.Type]reflect.Type{
+	specType:     reflect.TypeFor[fileFields](),
+	testCaseType: reflect.TypeFor[testCaseFields](),
+}
+
+// annotations adds descriptions, patterns and enums to chosen fields
+var annotations = map[string]schema{
+	"Specification.id":           {Description: "Unique within a run; defaults to word when loaded from a file"},
+	"Specification.word":         {Description: "Name of the Forth word to generate"},
+	"Specification.stack_effect": {Description: `Stack effect such as "( n -- n² )"; "->", "→" and "=>" also separate inputs from outputs`, Pattern: `(--|->|→|=>)`},
+	"Specification.timeout":      {Description: `Pipeline deadline as a Go duration such as "5s"; omit for none`},
+	"TestCase.input":             {Description: "Stack contents before the word runs, bottom first"},
+	"TestCase.output":            {Description: "Expected stack afterwards, bottom first"},
+	"effectItem.type":            {Enum: []string{"int", "uint", "bool", "char", "addr", "any"}},
+}
+
+// categories are the FailureCategory values
+var categories = []orchestrator.FailureCategory{
+	orchestrator.FailInvalidSpec, orchestrator.FailGeneration, orchestrator.FailStackMismatch,
+	orchestrator.FailTest, orchestrator.FailNetwork, orchestrator.FailTimeout,
+	orchestrator.FailCancelled, orchestrator.FailSkipped, orchestrator.FailOther,
+}
+
+// Schema returns a JSON Schema (draft 2020-12) for the type of v, such
+// as orchestrator.Specification{} or orchestrator.Result{}, built from
+// its json tags. Unknown fields are disallowed; fields without
+// omitempty are required, except a Specification's id, pattern_id and
+// test_cases. A Specification's schema describes spec files as Load
+// reads them, so it also admits the specs/*.json layout: a structured
+// stack_effect, implementation.pattern, and documentation fields.
+func Schema(v any) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, errors.New("schema of nil")
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // Keep "->" readable in descriptions
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rootSchema(t)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// rootSchema describes t, with its nested struct types under $defs
+func rootSchema(t reflect.Type) *schema {
+	defs := make(map[string]*schema)
+	s := schemaFor(t, defs)
+	if s.Ref != "" {
+		// Inline the root type rather than pointing at it
+		name := strings.TrimPrefix(s.Ref, "#/$defs/")
+		s = defs[name]
+		delete(defs, name)
+		s.Title = name
+	}
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	if len(defs) > 0 {
+		s.Defs = defs
+	}
+	return s
+}
+
+func schemaFor(t reflect.Type, defs map[string]*schema) *schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == durationType:
+		return &schema{Type: "integer"}
+	case t == specDurationType:
+		return &schema{Type: "string", Pattern: `^([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^0$`}
+	case t == categoryType:
+		enum := make([]string, len(categories))
+		for i, c := range categories {
+			enum[i] = string(c)
+		}
+		return &schema{Type: "string", Enum: enum}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: []string{"array", "null"}, Items: schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return &schema{Type: []string{"object", "null"}, Additional: schemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		name := t.Name()
+		if !token.IsExported(name) {
+			return structSchema(t, defs) // Inline file-format helpers
+		}
+		if _, done := defs[name]; !done {
+			defs[name] = nil // Placeholder against recursive types
+			defs[name] = structSchema(t, defs)
+		}
+		return &schema{Ref: "#/$defs/" + name}
+	}
+	return &schema{} // Anything
+}
+
+func structSchema(t reflect.Type, defs map[string]*schema) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema), Additional: false}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		prop := schemaFor(f.Type, defs)
+		if a, ok := annotations[t.Name()+"."+name]; ok {
+			prop.Description, prop.Pattern = a.Description, cmp.Or(a.Pattern, prop.Pattern)
+			if a.Enum != nil {
+				prop.Enum = a.Enum
+			}
+		}
+		s.Properties[name] = prop
+		s.fields = append(s.fields, name)
+		if !strings.Contains(opts, "omitempty") && !slices.Contains(optional[t], name) {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	// File-only fields are optional; one that shares a name widens the
+	// field to accept either form
+	if extra, ok := fileExtras[t]; ok {
+		es := structSchema(extra, defs)
+		for _, name := range es.fields {
+			prop := es.Properties[name]
+			if old, ok := s.Properties[name]; ok {
+				s.Properties[name] = &schema{AnyOf: []*schema{old, prop}}
+				continue
+			}
+			s.Properties[name] = prop
+			s.fields = append(s.fields, name)
+		}
+	}
+	return s
+}
+
+// ValidateJSON checks a JSON spec document, an array of specs or a
+// single spec, against the Specification schema (see Schema) without
+// the leniency of
+// Load: unknown fields, missing word or stack_effect, and values of the
+// wrong type are errors, each an *Error naming the line and the field's
+// path. Stack effects must also parse and fit the test cases (see
+// Check). name labels the errors, usually the file path.
+func ValidateJSON(name string, data []byte) error {
+	v := newValidator(name, data)
+	rootSpec := v.root.Ref
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		v.value(&schema{Type: "array", Items: &schema{Ref: rootSpec}}, "")
+	} else {
+		v.value(&schema{Ref: rootSpec}, "")
+	}
+	if len(v.errs) > 0 {
+		return errors.Join(v.errs...)
+	}
+
+	// The schema holds; now what it cannot say about stack effects
+	entries, err := decodeJSON(data)
+	if err != nil {
+		return &Error{File: name, Line: lineOf(data, err), Err: err}
+	}
+	for i, e := range entries {
+		if err := Check(e.spec); err != nil {
+			path := ""
+			if trimmed[0] == '[' {
+				path = "[" + strconv.Itoa(i) + "]"
+			}
+			v.errs = append(v.errs, &Error{File: name, Line: lineAt(data, e.offset), Err: pathError(path, err)})
+		}
+	}
+	return errors.Join(v.errs...)
+}
+
+func pathError(path string, err error) error {
+	if path == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// validator walks a JSON document token by token so every error keeps
+// its position
+type validator struct {
+	name string
+	data []byte
+	dec  *json.Decoder
+	root *schema // $ref to the Specification definition
+	defs map[string]*schema
+	errs []error
+	bad  bool // Syntax error: stop walking
+}
+
+func newValidator(name string, data []byte) *validator {
+	defs := make(map[string]*schema)
+	ref := schemaFor(specType, defs)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return &validator{name: name, data: data, dec: dec, root: ref, defs: defs}
+}
+
+func (v *validator) fail(offset int64, path, format string, args ...any) {
+	v.errs = append(v.errs, &Error{File: v.name, Line: lineAt(v.data, offset),
+		Err: pathError(path, fmt.Errorf(format, args...))})
+}
+
+// pos is the offset of the next token, past separators
+func (v *validator) pos() int64 {
+	off := v.dec.InputOffset()
+	rest := v.data[off:]
+	return off + int64(len(rest)-len(bytes.TrimLeft(rest, ", \t\r\n:")))
+}
+
+func (v *validator) resolve(s *schema) *schema {
+	if s != nil && s.Ref != "" {
+		return v.defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+	}
+	return s
+}
+
+// value consumes one JSON value, checking it against s; nil accepts
+// anything
+func (v *validator) value(s *schema, path string) {
+	if v.bad {
+		return
+	}
+	s = v.resolve(s)
+	at := v.pos()
+	tok, err := v.dec.Token()
+	if err != nil {
+		v.bad = true
+		v.errs = append(v.errs, &Error{File: v.name, Line: lineOf(v.data, err), Err: err})
+		return
+	}
+
+	got := jsonType(tok)
+	if s != nil && len(s.AnyOf) > 0 {
+		alt := slices.IndexFunc(s.AnyOf, func(a *schema) bool { return typeAllows(v.resolve(a).Type, got) })
+		if alt < 0 {
+			names := make([]string, len(s.AnyOf))
+			for i, a := range s.AnyOf {
+				names[i] = typeName(v.resolve(a).Type)
+			}
+			v.fail(at, path, "want %s, got %s", strings.Join(names, " or "), got)
+			s = nil
+		} else {
+			s = v.resolve(s.AnyOf[alt])
+		}
+	}
+	if s != nil && !typeAllows(s.Type, got) {
+		v.fail(at, path, "want %s, got %s", typeName(s.Type), got)
+		s = nil // Still walk the value to keep the decoder in step
+	}
+
+	switch got {
+	case "object":
+		seen := make(map[string]bool)
+		for v.dec.More() && !v.bad {
+			keyAt := v.pos()
+			keyTok, err := v.dec.Token()
+			if err != nil {
+				v.bad = true
+				v.errs = append(v.errs, &Error{File: v.name, Line: lineOf(v.data, err), Err: err})
+				return
+			}
+			key := keyTok.(string)
+			seen[key] = true
+			var sub *schema
+			if s != nil {
+				if p, ok := s.Properties[key]; ok {
+					sub = p
+				} else if extra, ok := s.Additional.(*schema); ok {
+					sub = extra
+				} else if s.Additional == false {
+					v.fail(keyAt, path, "unknown field %q%s", key, suggest(key, s.fields))
+				}
+			}
+			v.value(sub, joinPath(path, key))
+		}
+		v.dec.Token() // }
+		if s != nil {
+			for _, r := range s.Required {
+				if !seen[r] {
+					v.fail(at, path, "missing required field %q", r)
+				}
+			}
+		}
+	case "array":
+		var items *schema
+		if s != nil {
+			items = s.Items
+		}
+		for i := 0; v.dec.More() && !v.bad; i++ {
+			v.value(items, path+"["+strconv.Itoa(i)+"]")
+		}
+		v.dec.Token() // ]
+	case "number":
+		if s != nil && s.Type == "integer" {
+			if _, err := tok.(json.Number).Int64(); err != nil {
+				v.fail(at, path, "want an integer, got %s", tok)
+			}
+		}
+	case "string":
+		str := tok.(string)
+		if s == nil {
+			break
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			v.fail(at, path, "%q is not one of %s", str, strings.Join(s.Enum, ", "))
+		}
+		if s.Pattern != "" && s.re == nil {
+			s.re = regexp.MustCompile(s.Pattern)
+		}
+		if s.re != nil && !s.re.MatchString(str) {
+			v.fail(at, path, "%q does not match %s: %s", str, s.Pattern, s.Description)
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonType names the JSON type a token starts
+func jsonType(tok json.Token) string {
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			return "object"
+		}
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// typeAllows reports whether a schema type admits got; integers are
+// numbers here and checked separately
+func typeAllows(want any, got string) bool {
+	switch w := want.(type) {
+	case nil:
+		return true
+	case string:
+		return w == got || (w == "integer" && got == "number")
+	case []string:
+		return slices.ContainsFunc(w, func(t string) bool { return typeAllows(t, got) })
+	}
+	return false
+}
+
+func typeName(want any) string {
+	switch w := want.(type) {
+	case []string:
+		return strings.Join(w, " or ")
+	case string:
+		if w == "integer" || w == "array" || w == "object" {
+			return "an " + w
+		}
+		return "a " + w
+	}
+	return fmt.Sprint(want)
+}
+
+// suggest offers the closest known field to a misspelt one
+func suggest(key string, fields []string) string {
+	best, bestDist := "", 3 // Farther than 2 edits is no suggestion
+	for _, f := range fields {
+		if strings.EqualFold(f, key) || strings.EqualFold(strings.ReplaceAll(f, "_", ""), key) {
+			return fmt.Sprintf(" (did you mean %q?)", f)
+		}
+		if d := editDistance(key, f); d < bestDist {
+			best, bestDist = f, d
+		}
+	}
+	if best == "" {
+		return fmt.Sprintf(" (known fields: %s)", strings.Join(fields, ", "))
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,571 @@
+// Package spec loads Specification suites from disk, so they can live in
+// version control rather than be built as structs in Go. Files may hold
+// a JSON array of specs, a single spec object (the specs/*.json layout,
+// with a structured stack_effect), one spec per line (.jsonl, .ndjson),
+// YAML (.yaml, .yml), TOML (.toml), or CSV (see
+// orchestrator.LoadSpecsCSV). YAML and TOML take the same fields as
+// JSON; TOML lists several specs as [[specs]] tables. The module has no
+// dependencies, so both are read by parsers here that cover what a spec
+// file needs rather than the whole language: YAML anchors, aliases and
+// tags are errors, and TOML dates load as strings.
+//
+// Every spec is checked as it loads; problems come back as *Error
+// values naming the file and line, joined so one pass shows them all.
+// A strict Loader also holds every spec to the schema (see ValidateJSON).
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/quivent/fifth/compiler/examples/orchestrator"
+)
+
+// Error is a problem with one spec file, at Line when it is known
+type Error struct {
+	File string
+	Line int // 1-based; 0 when the problem is with the file as a whole
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %v", e.File, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Extensions lists the file types LoadDir picks up
+var Extensions = []string{".json", ".jsonl", ".ndjson", ".yaml", ".yml", ".toml", ".csv"}
+
+// Loader reads spec files; the zero value is lenient
+type Loader struct {
+	// Strict validates each spec with ValidateJSON: unknown fields and
+	// values of the wrong type are errors
+	Strict bool
+}
+
+// Load reads specs from each path in order. A path may be a file, a
+// directory (see LoadDir), or a filepath.Match glob such as
+// "specs/*.json". IDs must be unique across everything loaded.
+func Load(paths ...string) ([]orchestrator.Specification, error) {
+	return Loader{}.Load(paths...)
+}
+
+// LoadFile reads the specs in one file, choosing the format by extension
+func LoadFile(path string) ([]orchestrator.Specification, error) {
+	return Loader{}.LoadFile(path)
+}
+
+// LoadDir reads every spec file under dir, recursing into
+// subdirectories, in lexical order. Files whose extension is not in
+// Extensions, and names starting with "." or "_", are skipped.
+func LoadDir(dir string) ([]orchestrator.Specification, error) {
+	return Loader{}.LoadDir(dir)
+}
+
+// Load is the package Load with this Loader's settings
+func (ld Loader) Load(paths ...string) ([]orchestrator.Specification, error) {
+	l := loader{strict: ld.Strict}
+	for _, path := range paths {
+		if !hasMeta(path) {
+			l.path(path)
+			continue
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(matches) == 0 {
+			l.errs = append(l.errs, &Error{File: path, Err: errors.New("no files match")})
+		}
+		for _, m := range matches {
+			l.path(m)
+		}
+	}
+	return l.result()
+}
+
+// LoadFile is the package LoadFile with this Loader's settings
+func (ld Loader) LoadFile(path string) ([]orchestrator.Specification, error) {
+	l := loader{strict: ld.Strict}
+	l.file(path)
+	return l.result()
+}
+
+// LoadDir is the package LoadDir with this Loader's settings
+func (ld Loader) LoadDir(dir string) ([]orchestrator.Specification, error) {
+	l := loader{strict: ld.Strict}
+	l.dir(dir)
+	return l.result()
+}
+
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// loader accumulates specs and errors across files
+type loader struct {
+	strict bool
+	specs  []orchestrator.Specification
+	errs   []error
+	seen   map[string]string // Spec ID to "file:line" of its first definition
+}
+
+func (l *loader) result() ([]orchestrator.Specification, error) {
+	if len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
+	return l.specs, nil
+}
+
+func (l *loader) path(path string) {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		l.errs = append(l.errs, err)
+	case info.IsDir():
+		l.dir(path)
+	default:
+		l.file(path)
+	}
+}
+
+func (l *loader) dir(dir string) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && slices.Contains(Extensions, strings.ToLower(filepath.Ext(name))) {
+			l.file(path)
+		}
+		return nil
+	})
+	if err != nil {
+		l.errs = append(l.errs, err)
+	}
+}
+
+func (l *loader) file(path string) {
+	fail := func(line int, err error) {
+		l.errs = append(l.errs, &Error{File: path, Line: line, Err: err})
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.errs = append(l.errs, err)
+		return
+	}
+
+	// YAML and TOML specs become JSON objects for the steps below
+	var nodes []node
+	switch ext {
+	case ".yaml", ".yml":
+		nodes, err = parseYAML(data)
+	case ".toml":
+		nodes, err = parseTOML(data)
+	}
+	if err != nil {
+		fail(lineOf(data, err), err)
+		return
+	}
+
+	if l.strict {
+		if err := validateFile(path, ext, data, nodes); err != nil {
+			l.errs = append(l.errs, err)
+			return
+		}
+	}
+
+	// 1. Decode, remembering the line each spec starts on
+	var entries []entry
+	switch ext {
+	case ".csv":
+		specs, err := orchestrator.LoadSpecsCSV(bytes.NewReader(data))
+		if err != nil {
+			// *csv.ParseError and LoadSpecsCSV's errors carry the line
+			fail(0, err)
+			return
+		}
+		for i, s := range specs {
+			// Row i follows the header; assumes no quoted newlines
+			entries = append(entries, entry{spec: s, offset: -1, line: i + 2})
+		}
+	case ".jsonl", ".ndjson":
+		entries, err = decodeLines(data)
+	case ".yaml", ".yml", ".toml":
+		entries, err = decodeNodes(nodes)
+	default:
+		entries, err = decodeJSON(data)
+	}
+	if err != nil {
+		fail(lineOf(data, err), err)
+		return
+	}
+
+	// 2. Check each spec and its ID
+	if l.seen == nil {
+		l.seen = make(map[string]string)
+	}
+	for _, e := range entries {
+		if e.offset >= 0 && e.line == 0 {
+			e.line = lineAt(data, e.offset)
+		}
+		s := e.spec
+		if s.ID == "" {
+			s.ID = s.Word
+		}
+		if err := Check(s); err != nil {
+			fail(e.line, specError(s, err))
+			continue
+		}
+		where := fmt.Sprintf("%s:%d", path, e.line)
+		if first, dup := l.seen[s.ID]; dup {
+			fail(e.line, fmt.Errorf("duplicate spec id %q (first defined at %s)", s.ID, first))
+			continue
+		}
+		l.seen[s.ID] = where
+		l.specs = append(l.specs, s)
+	}
+}
+
+// validateFile runs ValidateJSON over a JSON file, or over each line
+// of a JSON-lines file or each spec of a YAML or TOML one
+func validateFile(path, ext string, data []byte, nodes []node) error {
+	switch ext {
+	case ".csv":
+		return nil // The header is the schema; LoadSpecsCSV enforces it
+	case ".yaml", ".yml", ".toml":
+		// Each node is one line of JSON, so its errors land on line 1
+		var errs []error
+		for _, n := range nodes {
+			if err := ValidateJSON(path, n.json); err != nil {
+				shiftLines(err, n.line-1)
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	case ".jsonl", ".ndjson":
+		var errs []error
+		for i, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			if err := ValidateJSON(path, line); err != nil {
+				shiftLines(err, i)
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return ValidateJSON(path, data)
+}
+
+// shiftLines moves the *Errors in err down by n lines
+func shiftLines(err error, n int) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			shiftLines(e, n)
+		}
+		return
+	}
+	if e, ok := err.(*Error); ok {
+		e.Line += n
+	}
+}
+
+// Check reports the first thing wrong with a spec: a missing word or
+// stack effect, an effect that does not parse, or test cases whose
+// arity disagrees with it (see orchestrator.CheckArity)
+func Check(s orchestrator.Specification) error {
+	if s.Word == "" {
+		return errors.New("missing word")
+	}
+	if s.StackEffect == "" {
+		return errors.New("missing stack_effect")
+	}
+	if _, err := orchestrator.NormalizeStackEffect(s.StackEffect); err != nil {
+		return fmt.Errorf("stack_effect: %w", err)
+	}
+	return orchestrator.CheckArity(s)
+}
+
+func specError(s orchestrator.Specification, err error) error {
+	if s.ID == "" {
+		return err
+	}
+	return fmt.Errorf("spec %q: %w", s.ID, err)
+}
+
+// entry is a decoded spec and where it started in its file
+type entry struct {
+	spec   orchestrator.Specification
+	offset int64 // Byte offset of the spec; -1 when line is set directly
+	line   int
+}
+
+// fileSpec is a Specification as written in a file: stack_effect may be
+// a string or the structured form, and the pattern may sit under
+// implementation. Fields the orchestrator has no use for (description,
+// properties, complexity, metadata) are ignored.
+type fileSpec struct {
+	orchestrator.Specification
+	StackEffect    json.RawMessage `json:"stack_effect"`
+	Implementation struct {
+		Pattern string `json:"pattern"`
+	} `json:"implementation"`
+}
+
+// structuredEffect is the specs/*.json form of a stack effect
+type structuredEffect struct {
+	Inputs  []effectItem `json:"inputs"`
+	Outputs []effectItem `json:"outputs"`
+}
+
+// effectItem is one stack item of a structuredEffect; only Name
+// reaches the Specification
+type effectItem struct {
+	Name       string `json:"name"`
+	Type       string `json:"type,omitempty"`
+	Constraint string `json:"constraint,omitempty"` // Inputs, e.g. "n >= 0"
+	Value      string `json:"value,omitempty"`      // Outputs, e.g. "n!"
+}
+
+// fileFields are what a spec file may hold beyond a Specification's
+// fields, after docs/specification.json: the structured stack_effect
+// and implementation block that fileSpec reads, and documentation it
+// ignores. Schema describes them so strict loading accepts the
+// specs/*.json layout.
+type fileFields struct {
+	StackEffect structuredEffect `json:"stack_effect"`
+	Description string           `json:"description,omitempty"`
+	Properties  []string         `json:"properties,omitempty"`
+	Complexity  *struct {
+		Time  string `json:"time,omitempty"`
+		Space string `json:"space,omitempty"`
+	} `json:"complexity,omitempty"`
+	Implementation *struct {
+		Pattern string   `json:"pattern,omitempty"`
+		Hints   []string `json:"hints,omitempty"`
+	} `json:"implementation,omitempty"`
+	Metadata *struct {
+		Author  string   `json:"author,omitempty"`
+		Version string   `json:"version,omitempty"`
+		Created string   `json:"created,omitempty"`
+		Tags    []string `json:"tags,omitempty"`
+	} `json:"metadata,omitempty"`
+}
+
+// testCaseFields are what a test case in a file may hold beyond a
+// TestCase's fields
+type testCaseFields struct {
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func (f fileSpec) spec() (orchestrator.Specification, error) {
+	s := f.Specification
+	if s.PatternID == "" {
+		s.PatternID = f.Implementation.Pattern
+	}
+	raw := bytes.TrimSpace(f.StackEffect)
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+	case raw[0] == '"':
+		if err := json.Unmarshal(raw, &s.StackEffect); err != nil {
+			return s, err
+		}
+	default:
+		var se structuredEffect
+		if err := json.Unmarshal(raw, &se); err != nil {
+			return s, fmt.Errorf("stack_effect: want a string or {\"inputs\": [...], \"outputs\": [...]}: %w", err)
+		}
+		parts := []string{"("}
+		for _, in := range se.Inputs {
+			parts = append(parts, in.Name)
+		}
+		parts = append(parts, "--")
+		for _, out := range se.Outputs {
+			parts = append(parts, out.Name)
+		}
+		s.StackEffect = strings.Join(append(parts, ")"), " ")
+	}
+	return s, nil
+}
+
+// decodeJSON reads a JSON array of specs or a single spec object
+func decodeJSON(data []byte) ([]entry, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('[') {
+		var f fileSpec
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, err
+		}
+		s, err := f.spec()
+		if err != nil {
+			return nil, &offsetError{0, err}
+		}
+		start := bytes.IndexByte(data, '{')
+		return []entry{{spec: s, offset: int64(max(start, 0))}}, nil
+	}
+
+	var entries []entry
+	for dec.More() {
+		// InputOffset is just past the previous value; skip to this one
+		offset := dec.InputOffset()
+		offset += int64(len(data[offset:]) - len(bytes.TrimLeft(data[offset:], ", \t\r\n")))
+		var f fileSpec
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+		s, err := f.spec()
+		if err != nil {
+			return nil, &offsetError{offset, err}
+		}
+		entries = append(entries, entry{spec: s, offset: offset})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// decodeLines reads one spec object per non-blank line
+func decodeLines(data []byte) ([]entry, error) {
+	var entries []entry
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var f fileSpec
+		err := json.Unmarshal(line, &f)
+		if err == nil {
+			var s orchestrator.Specification
+			if s, err = f.spec(); err == nil {
+				entries = append(entries, entry{spec: s, offset: -1, line: i + 1})
+				continue
+			}
+		}
+		return nil, &lineError{i + 1, err}
+	}
+	return entries, nil
+}
+
+// node is a YAML or TOML spec as a JSON object, and its first line
+type node struct {
+	json []byte
+	line int
+}
+
+// newNode encodes a parsed spec, which must be a mapping
+func newNode(v any, line int) (node, error) {
+	if _, ok := v.(map[string]any); !ok {
+		return node{}, &lineError{line, fmt.Errorf("want a spec mapping, got %s", kindOf(v))}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return node{}, &lineError{line, err}
+	}
+	return node{json: data, line: line}, nil
+}
+
+func kindOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case []any:
+		return "a list"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	}
+	return "a number"
+}
+
+// decodeNodes reads each node as a JSON spec object
+func decodeNodes(nodes []node) ([]entry, error) {
+	var entries []entry
+	for _, n := range nodes {
+		var f fileSpec
+		err := json.Unmarshal(n.json, &f)
+		if err == nil {
+			var s orchestrator.Specification
+			if s, err = f.spec(); err == nil {
+				entries = append(entries, entry{spec: s, offset: -1, line: n.line})
+				continue
+			}
+		}
+		return nil, &lineError{n.line, err}
+	}
+	return entries, nil
+}
+
+// offsetError places an error at a byte offset of the file
+type offsetError struct {
+	offset int64
+	err    error
+}
+
+func (e *offsetError) Error() string { return e.err.Error() }
+func (e *offsetError) Unwrap() error { return e.err }
+
+// lineError places an error on a line of the file
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string { return e.err.Error() }
+func (e *lineError) Unwrap() error { return e.err }
+
+// lineOf finds the line a decode error points at, or 0
+func lineOf(data []byte, err error) int {
+	var (
+		syntax *json.SyntaxError
+		typ    *json.UnmarshalTypeError
+		off    *offsetError
+		line   *lineError
+	)
+	switch {
+	case errors.As(err, &line):
+		return line.line
+	case errors.As(err, &off):
+		return lineAt(data, off.offset)
+	case errors.As(err, &syntax):
+		return lineAt(data, syntax.Offset)
+	case errors.As(err, &typ):
+		return lineAt(data, typ.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return bytes.Count(data, []byte("\n")) + 1
+	}
+	return 0
+}
+
+// lineAt converts a byte offset to a 1-based line number
+func lineAt(data []byte, offset int64) int {
+	offset = min(max(offset, 0), int64(len(data)))
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
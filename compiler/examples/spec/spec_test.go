@@ -0,0 +1,217 @@
+package spec
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The specs/ directory is the layout Load documents; strict loading
+// must accept it as written
+func TestStrictLoadsExamples(t *testing.T) {
+	specs, err := Loader{Strict: true}.Load("../specs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 5 {
+		t.Fatalf("loaded %d specs, want 5", len(specs))
+	}
+	for _, s := range specs {
+		if s.ID != "square" {
+			continue
+		}
+		if s.StackEffect != "( n -- n² )" || s.PatternID == "" || len(s.TestCases) == 0 {
+			t.Errorf("square = %+v", s)
+		}
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		want      string // Error substring; "" for valid
+	}{
+		{"string effect", `{"word": "sq", "stack_effect": "( n -- n² )", "test_cases": [{"input": [3], "output": [9]}]}`, ""},
+		{"specs/ layout", `{
+			"word": "sq",
+			"description": "Squares n",
+			"stack_effect": {"inputs": [{"name": "n", "type": "int", "constraint": "n >= 0"}],
+			                 "outputs": [{"name": "n²", "type": "int", "value": "n*n"}]},
+			"properties": ["sq(n) >= 0"],
+			"test_cases": [{"description": "three", "input": [3], "output": [9], "tags": ["base_case"]}],
+			"complexity": {"time": "O(1)", "space": "O(1)"},
+			"implementation": {"pattern": "DUP_TRANSFORM_001", "hints": ["dup *"]},
+			"metadata": {"author": "me", "version": "1.0.0", "created": "2025-01-14T00:00:00Z", "tags": ["math"]}
+		}`, ""},
+		{"misspelt file field", `{"word": "sq", "stack_effect": "( n -- n )", "descripton": "x"}`,
+			`:1: unknown field "descripton" (did you mean "description"?)`},
+		{"effect of the wrong type", `{"word": "sq", "stack_effect": ["n"]}`,
+			"stack_effect: want a string or an object, got array"},
+		{"effect without separator", `{"word": "sq", "stack_effect": "( n n )"}`,
+			`stack_effect: "( n n )" does not match`},
+		{"structured effect missing outputs", `{"word": "sq", "stack_effect": {"inputs": []}}`,
+			`stack_effect: missing required field "outputs"`},
+		{"unknown item field", `{"word": "sq", "stack_effect": {"inputs": [{"nme": "n"}], "outputs": []}}`,
+			`stack_effect.inputs[0]: unknown field "nme" (did you mean "name"?)`},
+		{"unknown item type", `{"word": "sq", "stack_effect": {"inputs": [{"name": "n", "type": "float"}], "outputs": []}}`,
+			`stack_effect.inputs[0].type: "float" is not one of int, uint, bool, char, addr, any`},
+		{"test case tags", `{"word": "sq", "stack_effect": "( n -- n )", "test_cases": [{"input": [1], "output": [1], "tags": "edge"}]}`,
+			"test_cases[0].tags: want array or null, got string"},
+		{"unknown metadata field", `{"word": "sq", "stack_effect": "( n -- n )", "metadata": {"owner": "me"}}`,
+			`metadata: unknown field "owner"`},
+		{"structured effect arity", `[{"word": "sq", "stack_effect": {"inputs": [{"name": "n"}], "outputs": [{"name": "n"}]},
+			"test_cases": [{"input": [1, 2], "output": [1]}]}]`, "[0]: "},
+	}
+	for _, tc := range tests {
+		err := ValidateJSON("spec.json", []byte(tc.doc))
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%s: error = %v, want %q", tc.name, err, tc.want)
+		}
+		var e *Error
+		if err != nil && !errors.As(err, &e) {
+			t.Errorf("%s: %v is not an *Error", tc.name, err)
+		}
+	}
+}
+
+// write puts data in a file named name under a new temporary directory
+func write(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// YAML and TOML load the same specs as the JSON they transcribe
+func TestLoadYAMLAndTOML(t *testing.T) {
+	want, err := LoadFile(write(t, "specs.json", `[
+		{"id": "sq", "word": "square", "stack_effect": "( n -- n² )",
+		 "test_cases": [{"input": [3], "output": [9]}, {"input": [-2], "output": [4]}]},
+		{"word": "abs", "description": "Absolute value: |n|",
+		 "stack_effect": {"inputs": [{"name": "n", "type": "int"}], "outputs": [{"name": "|n|", "type": "int"}]},
+		 "implementation": {"pattern": "CONDITIONAL_NEGATE_002", "hints": ["0< if negate then"]},
+		 "test_cases": [{"input": [-5], "output": [5], "tags": ["edge"]}],
+		 "metadata": {"created": "2025-01-14T00:00:00Z"}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"specs.yaml": `# Two specs
+- id: sq
+  word: square
+  stack_effect: "( n -- n² )"   # quoted: it has no colon, but reads better
+  test_cases:
+    - {input: [3], output: [9]}
+    - input: [-2]
+      output:
+        - 4
+- word: abs
+  description: >
+    Absolute value:
+    |n|
+  stack_effect:
+    inputs: [{name: n, type: int}]
+    outputs:
+    - name: '|n|'
+      type: int
+  implementation:
+    pattern: CONDITIONAL_NEGATE_002
+    hints:
+      - 0< if negate then
+  test_cases: [
+    {input: [-5], output: [5], tags: [edge]},
+  ]
+  metadata: {created: "2025-01-14T00:00:00Z"}
+`,
+		"specs.toml": `# Two specs
+[[specs]]
+id = "sq"
+word = 'square'
+stack_effect = "( n -- n² )"
+test_cases = [
+  { input = [3], output = [9] },
+  { input = [-2], output = [4] },  # trailing comma
+]
+
+[[specs]]
+word = "abs"
+description = """
+Absolute value: |n|"""
+test_cases = [{input = [-5], output = [5], tags = ["edge"]}]
+metadata.created = 2025-01-14T00:00:00Z
+
+[specs.stack_effect]
+inputs = [{name = "n", type = "int"}]
+
+[[specs.stack_effect.outputs]]
+name = "|n|"
+type = "int"
+
+[specs.implementation]
+pattern = "CONDITIONAL_NEGATE_002"
+hints = ["0< if negate then"]
+`,
+	}
+	for name, data := range files {
+		got, err := Loader{Strict: true}.LoadFile(write(t, name, data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s loaded\n%+v\nwant\n%+v", name, got, want)
+		}
+	}
+
+	// A single spec is the whole document in either format
+	for name, data := range map[string]string{
+		"one.yml":  "word: dup2\nstack_effect: ( a b -- a b a b )\n",
+		"one.toml": "word = \"dup2\"\nstack_effect = \"( a b -- a b a b )\"\n",
+	} {
+		got, err := LoadFile(write(t, name, data))
+		if err != nil || len(got) != 1 || got[0].ID != "dup2" || got[0].StackEffect != "( a b -- a b a b )" {
+			t.Errorf("%s = %+v, %v", name, got, err)
+		}
+	}
+}
+
+// YAML and TOML errors name the line, as JSON's do
+func TestLoadYAMLAndTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name, data string
+		strict     bool
+		want       string
+	}{
+		{"indent.yaml", "word: sq\n  stack_effect: ( n -- n )\n", false, ":2: unexpected indentation"},
+		{"flow.yaml", "- word: sq\n  stack_effect: ( n -- n )\n  test_cases: [{input: [1] output: [1]}]\n", false,
+			`:3: want "," or "}"`},
+		{"anchor.yaml", "- &base\n  word: sq\n", false, ":1: anchors, aliases and tags are not supported"},
+		{"arity.yaml", "- word: ok\n  stack_effect: ( n -- n )\n- word: sq\n  stack_effect: ( n -- n )\n  test_cases: [{input: [1, 2], output: [1]}]\n",
+			false, `:3: spec "sq": `},
+		{"unknown.yaml", "- word: ok\n  stack_effect: ( n -- n )\n- word: sq\n  stack_effect: ( n -- n )\n  descripton: x\n",
+			true, `:3: unknown field "descripton" (did you mean "description"?)`},
+		{"scalar.yaml", "- just a word\n", false, ":1: want a spec mapping, got a string"},
+		{"dup.toml", "word = \"sq\"\nword = \"sq\"\n", false, ":2: word defined twice"},
+		{"string.toml", "word = \"sq\nstack_effect = \"( n -- n )\"\n", false, ":1: unterminated string"},
+		{"table.toml", "[[specs]]\nword = \"a\"\nstack_effect = \"( -- )\"\n[specs.implementation]\npattern = \"A\"\n[specs.implementation]\n", false,
+			":6: table [specs.implementation] defined twice"},
+		{"stray.toml", "word = \"a\"\n[[specs]]\nword = \"b\"\n", false, "keys outside [[specs]] belong in a spec"},
+		{"type.toml", "[[specs]]\nword = \"a\"\nstack_effect = \"( -- )\"\n\n[[specs]]\nword = \"b\"\nstack_effect = \"( n -- n )\"\ntest_cases = [{input = [\"x\"], output = [1]}]\n",
+			true, ":5: test_cases[0].input[0]: want an integer, got string"},
+	}
+	for _, tc := range tests {
+		_, err := Loader{Strict: tc.strict}.LoadFile(write(t, tc.name, tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
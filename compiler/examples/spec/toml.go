@@ -0,0 +1,512 @@
+package spec
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML reads a TOML spec file: either one spec as the whole
+// document, or an array of tables named specs,
+//
+//	[[specs]]
+//	word = "square"
+//	stack_effect = "( n -- n² )"
+//	test_cases = [{input = [3], output = [9]}]
+//
+// with nested tables such as [specs.stack_effect] applying to the last
+// one. Dates and times are read as strings.
+func parseTOML(data []byte) ([]node, error) {
+	p := &tomlParser{s: string(data), root: map[string]any{}, tables: map[string]bool{}}
+	p.cur = p.root
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	specs, ok := p.root["specs"]
+	if !ok {
+		n, err := newNode(p.root, 1)
+		return []node{n}, err
+	}
+	if len(p.root) > 1 {
+		return nil, &lineError{0, errors.New("keys outside [[specs]] belong in a spec")}
+	}
+	list, ok := specs.([]any)
+	if !ok {
+		return nil, &offsetError{int64(p.specsAt), errors.New("specs: want an array of tables")}
+	}
+	nodes := make([]node, len(list))
+	for i, v := range list {
+		at := p.specsAt
+		if i < len(p.specAt) {
+			at = p.specAt[i]
+		}
+		var err error
+		if nodes[i], err = newNode(v, lineAt(data, int64(at))); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// tomlParser scans a TOML document into root; cur is the table that
+// key = value lines fill
+type tomlParser struct {
+	s      string
+	i      int
+	root   map[string]any
+	cur    map[string]any
+	tables map[string]bool // Paths of tables already given a [header]
+	header bool            // Whether any [header] has been read
+
+	specsAt int   // Offset of "specs = [...]" or the first [[specs]]
+	specAt  []int // Offset of each [[specs]] header
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return &offsetError{int64(p.i), fmt.Errorf(format, args...)}
+}
+
+func (p *tomlParser) parse() error {
+	for {
+		p.skip(true)
+		if p.i == len(p.s) {
+			return nil
+		}
+		start := p.i
+		var err error
+		switch {
+		case strings.HasPrefix(p.s[p.i:], "[["):
+			p.i += 2
+			err = p.table(start, true)
+		case p.s[p.i] == '[':
+			p.i++
+			err = p.table(start, false)
+		default:
+			if !p.header {
+				p.specsAt = start // In case this is specs = [...]
+			}
+			err = p.keyValue(p.cur)
+		}
+		if err == nil {
+			err = p.endLine()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// table reads the rest of a [table] or [[array]] line and makes it
+// the current table
+func (p *tomlParser) table(start int, array bool) error {
+	keys, err := p.keys()
+	if err != nil {
+		return err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	p.skip(false)
+	if !strings.HasPrefix(p.s[p.i:], closing) {
+		return p.errorf("want %q", closing)
+	}
+	p.i += len(closing)
+	p.header = true
+
+	t, err := p.walk(p.root, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	path := strings.Join(keys, ".")
+	if array {
+		list, ok := t[last].([]any)
+		if _, exists := t[last]; exists && !ok {
+			return &offsetError{int64(start), fmt.Errorf("%s is not an array of tables", path)}
+		}
+		p.cur = map[string]any{}
+		t[last] = append(list, p.cur)
+		// Tables under the array now belong to its new element
+		for defined := range p.tables {
+			if strings.HasPrefix(defined, path+".") {
+				delete(p.tables, defined)
+			}
+		}
+		if path == "specs" {
+			if p.specAt == nil {
+				p.specsAt = start
+			}
+			p.specAt = append(p.specAt, start)
+		}
+		return nil
+	}
+	if p.tables[path] {
+		return &offsetError{int64(start), fmt.Errorf("table [%s] defined twice", path)}
+	}
+	p.tables[path] = true
+	if p.cur, err = p.walk(t, []string{last}); err != nil {
+		return &offsetError{int64(start), err}
+	}
+	return nil
+}
+
+// walk follows keys from t, creating tables as needed and stepping into
+// the last element of arrays of tables
+func (p *tomlParser) walk(t map[string]any, keys []string) (map[string]any, error) {
+	for _, k := range keys {
+		switch v := t[k].(type) {
+		case nil:
+			next := map[string]any{}
+			t[k] = next
+			t = next
+		case map[string]any:
+			t = v
+		case []any:
+			last, ok := any(nil), false
+			if len(v) > 0 {
+				last = v[len(v)-1]
+			}
+			if t, ok = last.(map[string]any); !ok {
+				return nil, p.errorf("%s is not a table", k)
+			}
+		default:
+			return nil, p.errorf("%s is not a table", k)
+		}
+	}
+	return t, nil
+}
+
+// keyValue reads key = value into t
+func (p *tomlParser) keyValue(t map[string]any) error {
+	start := p.i
+	keys, err := p.keys()
+	if err != nil {
+		return err
+	}
+	p.skip(false)
+	if p.i == len(p.s) || p.s[p.i] != '=' {
+		return p.errorf("want \"=\" after %s", strings.Join(keys, "."))
+	}
+	p.i++
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	if t, err = p.walk(t, keys[:len(keys)-1]); err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, dup := t[last]; dup {
+		return &offsetError{int64(start), fmt.Errorf("%s defined twice", strings.Join(keys, "."))}
+	}
+	t[last] = v
+	return nil
+}
+
+// keys reads a dotted key: bare or quoted parts joined by "."
+func (p *tomlParser) keys() ([]string, error) {
+	var keys []string
+	for {
+		p.skip(false)
+		if p.i == len(p.s) {
+			return nil, p.errorf("want a key")
+		}
+		switch c := p.s[p.i]; {
+		case c == '"' || c == '\'':
+			k, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		default:
+			start := p.i
+			for p.i < len(p.s) && isBareKey(p.s[p.i]) {
+				p.i++
+			}
+			if p.i == start {
+				return nil, p.errorf("want a key, got %q", p.rest())
+			}
+			keys = append(keys, p.s[start:p.i])
+		}
+		p.skip(false)
+		if p.i == len(p.s) || p.s[p.i] != '.' {
+			return keys, nil
+		}
+		p.i++
+	}
+}
+
+func isBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// value reads a string, number, boolean, date, array or inline table
+func (p *tomlParser) value() (any, error) {
+	p.skip(false)
+	if p.i == len(p.s) {
+		return nil, p.errorf("missing value")
+	}
+	switch c := p.s[p.i]; {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		p.i++
+		items := []any{}
+		for {
+			p.skip(true)
+			if p.i < len(p.s) && p.s[p.i] == ']' {
+				p.i++
+				return items, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			p.skip(true)
+			switch {
+			case p.i == len(p.s):
+				return nil, p.errorf("unterminated array")
+			case p.s[p.i] == ',':
+				p.i++
+			case p.s[p.i] != ']':
+				return nil, p.errorf("want \",\" or \"]\", got %q", p.rest())
+			}
+		}
+	case c == '{':
+		p.i++
+		t := map[string]any{}
+		p.skip(false)
+		if p.i < len(p.s) && p.s[p.i] == '}' {
+			p.i++
+			return t, nil
+		}
+		for {
+			if err := p.keyValue(t); err != nil {
+				return nil, err
+			}
+			p.skip(false)
+			switch {
+			case p.i == len(p.s) || p.s[p.i] == '\n':
+				return nil, p.errorf("unterminated inline table")
+			case p.s[p.i] == '}':
+				p.i++
+				return t, nil
+			case p.s[p.i] != ',':
+				return nil, p.errorf("want \",\" or \"}\", got %q", p.rest())
+			}
+			p.i++
+		}
+	}
+
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n,]}#", p.s[p.i]) < 0 {
+		p.i++
+	}
+	tok := p.s[start:p.i]
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	digits := strings.ReplaceAll(tok, "_", "")
+	if n, err := strconv.ParseInt(digits, 10, 64); err == nil {
+		return n, nil
+	}
+	if len(digits) > 2 && digits[0] == '0' && strings.IndexByte("xob", digits[1]) >= 0 {
+		if n, err := strconv.ParseInt(digits, 0, 64); err == nil {
+			return n, nil
+		}
+	}
+	if numeric(digits) {
+		if f, err := strconv.ParseFloat(digits, 64); err == nil {
+			return f, nil
+		}
+	}
+	if isDate(tok) {
+		// A space may separate a date from its time
+		if p.i+1 < len(p.s) && p.s[p.i] == ' ' && isDigit(p.s[p.i+1]) && len(tok) == 10 {
+			p.i++
+			for p.i < len(p.s) && strings.IndexByte(" \t\r\n,]}#", p.s[p.i]) < 0 {
+				p.i++
+			}
+			tok = p.s[start:p.i]
+		}
+		return tok, nil
+	}
+	p.i = start
+	return nil, p.errorf("invalid value %q", tok)
+}
+
+// isDate reports whether tok looks like a TOML date or time:
+// 1979-05-27, 07:32:00, or both
+func isDate(tok string) bool {
+	return len(tok) >= 8 && isDigit(tok[0]) && isDigit(tok[1]) &&
+		(tok[2] == ':' || len(tok) >= 10 && tok[4] == '-' && tok[7] == '-')
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// str reads a basic or literal string, in double or single quotes, or
+// the multi-line form of either between three quotes
+func (p *tomlParser) str() (string, error) {
+	q := p.s[p.i : p.i+1]
+	if strings.HasPrefix(p.s[p.i:], q+q+q) {
+		p.i += 3
+		// A newline straight after the opening quotes is trimmed
+		if strings.HasPrefix(p.s[p.i:], "\r\n") {
+			p.i += 2
+		} else if strings.HasPrefix(p.s[p.i:], "\n") {
+			p.i++
+		}
+		end := -1
+		for j := p.i; j < len(p.s); j++ {
+			if q == `"` && p.s[j] == '\\' {
+				j++
+				continue
+			}
+			if strings.HasPrefix(p.s[j:], q+q+q) {
+				end = j
+				break
+			}
+		}
+		if end < 0 {
+			return "", p.errorf("unterminated multi-line string")
+		}
+		// Up to two quotes before the closing three are content
+		for n := 0; n < 2 && end+3 < len(p.s) && p.s[end+3] == q[0]; n++ {
+			end++
+		}
+		raw, start := p.s[p.i:end], p.i
+		p.i = end + 3
+		if q == "'" {
+			return raw, nil
+		}
+		s, err := unescape(raw, true)
+		if err != nil {
+			return "", &offsetError{int64(start), err}
+		}
+		return s, nil
+	}
+
+	start := p.i
+	p.i++
+	for ; p.i < len(p.s) && p.s[p.i] != '\n'; p.i++ {
+		switch p.s[p.i] {
+		case '\\':
+			if q == `"` {
+				p.i++
+			}
+		case q[0]:
+			raw := p.s[start+1 : p.i]
+			p.i++
+			if q == "'" {
+				return raw, nil
+			}
+			s, err := unescape(raw, false)
+			if err != nil {
+				return "", &offsetError{int64(start), err}
+			}
+			return s, nil
+		}
+	}
+	p.i = start
+	return "", p.errorf("unterminated string")
+}
+
+// unescape expands the escapes of a basic string; in a multi-line one a
+// backslash at the end of a line also drops the line break and the
+// whitespace after it
+func unescape(s string, multiline bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("trailing backslash in string")
+		}
+		switch c = s[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case '"', '\\':
+			b.WriteByte(c)
+		case 'u', 'U':
+			n := 4
+			if c == 'U' {
+				n = 8
+			}
+			if i+n >= len(s) {
+				return "", fmt.Errorf("short \\%c escape", c)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", fmt.Errorf("bad \\%c escape %q", c, s[i+1:i+1+n])
+			}
+			b.WriteRune(rune(r))
+			i += n
+		default:
+			rest := strings.TrimLeft(s[i:], " \t\r")
+			if !multiline || !strings.HasPrefix(rest, "\n") {
+				return "", fmt.Errorf("bad escape \\%c", c)
+			}
+			rest = strings.TrimLeft(rest, " \t\r\n")
+			i = len(s) - len(rest) - 1
+		}
+	}
+	return b.String(), nil
+}
+
+// skip passes spaces and comments, and newlines too when lines is set
+func (p *tomlParser) skip(lines bool) {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t', '\r':
+			p.i++
+		case '\n':
+			if !lines {
+				return
+			}
+			p.i++
+		case '#':
+			for p.i < len(p.s) && p.s[p.i] != '\n' {
+				p.i++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endLine checks that nothing but a comment follows on the line
+func (p *tomlParser) endLine() error {
+	p.skip(false)
+	if p.i < len(p.s) && p.s[p.i] != '\n' {
+		return p.errorf("unexpected %q at end of line", p.rest())
+	}
+	return nil
+}
+
+// rest is the remainder of the current line, for errors
+func (p *tomlParser) rest() string {
+	end := strings.IndexByte(p.s[p.i:], '\n')
+	if end < 0 {
+		return p.s[p.i:]
+	}
+	return p.s[p.i : p.i+end]
+}
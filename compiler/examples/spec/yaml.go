@@ -0,0 +1,550 @@
+package spec
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the YAML a spec file needs: block mappings and
+// sequences, flow collections ([3, 4], {input: [3]}), plain, quoted and
+// block (| and >) scalars, comments, and "---" between documents. Each
+// document is a spec or a sequence of specs. Anchors, aliases, tags and
+// complex keys are errors.
+func parseYAML(data []byte) ([]node, error) {
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")}
+	var nodes []node
+	add := func(v any, line int) error {
+		n, err := newNode(v, line)
+		nodes = append(nodes, n)
+		return err
+	}
+	for {
+		ind, text, ok, err := p.peek()
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok:
+			return nodes, nil
+		case text == "---" || text == "...":
+			p.i++
+			continue
+		case strings.HasPrefix(text, "--- "):
+			return nil, p.errorf("content after \"---\" is not supported; start it on the next line")
+		case ind != 0:
+			return nil, p.errorf("unexpected indentation")
+		}
+
+		line := p.i + 1
+		if isSeqItem(text) {
+			items, lines, err := p.seq(0)
+			if err != nil {
+				return nil, err
+			}
+			for i, v := range items {
+				if err := add(v, lines[i]); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		v, err := p.block(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(v, line); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// yamlParser walks the lines of a YAML file; i is the current one
+type yamlParser struct {
+	lines []string
+	i     int
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	return &lineError{p.i + 1, fmt.Errorf(format, args...)}
+}
+
+// peek skips blank and comment lines and returns the next line's
+// indentation and text, or ok=false at the end of the file
+func (p *yamlParser) peek() (indent int, text string, ok bool, err error) {
+	for ; p.i < len(p.lines); p.i++ {
+		line := strings.TrimRight(p.lines[p.i], " \t")
+		text = strings.TrimLeft(line, " ")
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if text[0] == '\t' {
+			return 0, "", false, p.errorf("tab in indentation")
+		}
+		return len(line) - len(text), text, true, nil
+	}
+	return 0, "", false, nil
+}
+
+// next is peek for a collection at indent: ok=false when the line
+// belongs to an enclosing collection or the next document
+func (p *yamlParser) next(indent int) (text string, ok bool, err error) {
+	ind, text, ok, err := p.peek()
+	switch {
+	case err != nil || !ok || ind < indent:
+		return "", false, err
+	case ind == 0 && (text == "---" || text == "..." || strings.HasPrefix(text, "--- ")):
+		return "", false, nil
+	case ind > indent:
+		return "", false, p.errorf("unexpected indentation")
+	}
+	return text, true, nil
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block reads the collection or scalar that starts on the current line,
+// indented by indent
+func (p *yamlParser) block(indent int) (any, error) {
+	_, text, _, _ := p.peek()
+	if isSeqItem(text) {
+		items, _, err := p.seq(indent)
+		return items, err
+	}
+	if _, _, isKey, err := splitKey(text); err != nil {
+		return nil, p.errorf("%v", err)
+	} else if isKey {
+		return p.mapping(indent)
+	}
+	return p.inline(text)
+}
+
+// seq reads "- item" lines at indent, returning the items and the line
+// each starts on
+func (p *yamlParser) seq(indent int) ([]any, []int, error) {
+	items, lines := []any{}, []int{}
+	for {
+		text, ok, err := p.next(indent)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok || !isSeqItem(text) {
+			return items, lines, nil
+		}
+		lines = append(lines, p.i+1)
+		rest := strings.TrimLeft(text[1:], " ")
+		var v any
+		if rest == "" || rest[0] == '#' {
+			p.i++
+			v, err = p.nested(indent, false)
+		} else {
+			// Read the rest as if it began its own line, so "- word: sq"
+			// starts a mapping that continues on the lines below
+			col := indent + len(text) - len(rest)
+			p.lines[p.i] = strings.Repeat(" ", col) + rest
+			v, err = p.block(col)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, v)
+	}
+}
+
+// mapping reads "key: value" lines at indent
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for {
+		text, ok, err := p.next(indent)
+		if err != nil || !ok {
+			return m, err
+		}
+		key, rest, isKey, err := splitKey(text)
+		if err != nil || !isKey {
+			return nil, p.errorf("want \"key: value\", got %q", text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		var v any
+		switch rest = stripComment(rest); {
+		case rest == "":
+			p.i++
+			v, err = p.nested(indent, true)
+		case rest[0] == '|' || rest[0] == '>':
+			v, err = p.blockScalar(indent, rest)
+		default:
+			v, err = p.inline(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+}
+
+// nested reads the value of a key or item whose line held nothing after
+// the ":" or "-": a more indented block, a sequence at the key's own
+// indent, or null
+func (p *yamlParser) nested(indent int, inMapping bool) (any, error) {
+	ind, text, ok, err := p.peek()
+	switch {
+	case err != nil || !ok:
+		return nil, err
+	case ind > indent:
+		return p.block(ind)
+	case ind == indent && inMapping && isSeqItem(text):
+		items, _, err := p.seq(indent)
+		return items, err
+	}
+	return nil, nil
+}
+
+// blockScalar reads a | or > scalar whose header ends the current line
+func (p *yamlParser) blockScalar(indent int, header string) (string, error) {
+	folded, chomp := header[0] == '>', header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return "", p.errorf("block scalar header %q is not supported", header)
+	}
+	p.i++
+	var lines []string
+	content := -1 // Indentation of the first line, which the rest share
+	for ; p.i < len(p.lines); p.i++ {
+		line := strings.TrimRight(p.lines[p.i], " \t")
+		text := strings.TrimLeft(line, " ")
+		if text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		ind := len(line) - len(text)
+		if content < 0 {
+			content = ind
+		}
+		if ind <= indent || ind < content {
+			break
+		}
+		lines = append(lines, line[content:])
+	}
+	body := len(lines)
+	for body > 0 && lines[body-1] == "" {
+		body--
+	}
+	trailing := len(lines) - body
+
+	var s string
+	if folded {
+		var b strings.Builder
+		for i, line := range lines[:body] {
+			// A blank line is a newline; other breaks fold to a space
+			switch {
+			case line == "":
+				b.WriteByte('\n')
+			case i > 0 && lines[i-1] != "":
+				b.WriteByte(' ')
+			}
+			b.WriteString(line)
+		}
+		s = b.String()
+	} else {
+		s = strings.Join(lines[:body], "\n")
+	}
+	switch {
+	case body == 0 || chomp == "-":
+	case chomp == "+":
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		s += "\n"
+	}
+	return s, nil
+}
+
+// inline reads a scalar or flow collection that starts on the current
+// line; a flow collection may continue onto the lines below
+func (p *yamlParser) inline(text string) (any, error) {
+	text = stripComment(text)
+	start := p.i
+	p.i++
+	if text != "" && (text[0] == '[' || text[0] == '{') {
+		for flowDepth(text) > 0 && p.i < len(p.lines) {
+			text += " " + stripComment(strings.TrimSpace(p.lines[p.i]))
+			p.i++
+		}
+		f := &flow{s: text}
+		v, err := f.value()
+		if err == nil && f.space() < len(f.s) {
+			err = fmt.Errorf("unexpected %q after the value", f.s[f.i:])
+		}
+		if err != nil {
+			return nil, &lineError{start + 1, err}
+		}
+		return v, nil
+	}
+	v, err := scalar(text)
+	if err != nil {
+		return nil, &lineError{start + 1, err}
+	}
+	return v, nil
+}
+
+// splitKey splits "key: rest" into its parts; isKey is false for a line
+// that is not a mapping entry
+func splitKey(text string) (key, rest string, isKey bool, err error) {
+	if text == "" || strings.ContainsRune("[{#|>", rune(text[0])) {
+		return "", "", false, nil
+	}
+	if text[0] == '?' {
+		return "", "", false, errors.New("complex keys are not supported")
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := quoteEnd(text)
+		if end < 0 {
+			return "", "", false, nil
+		}
+		after := text[end:]
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", false, nil
+		}
+		k, err := scalar(text[:end])
+		if err != nil {
+			return "", "", false, err
+		}
+		return k.(string), strings.TrimSpace(after[1:]), true, nil
+	}
+	i := strings.Index(text+" ", ": ")
+	if i < 0 || strings.HasPrefix(text, "- ") {
+		return "", "", false, nil
+	}
+	if c := text[0]; c == '&' || c == '*' || c == '!' {
+		return "", "", false, errors.New("anchors, aliases and tags are not supported")
+	}
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[min(i+1, len(text)):]), true, nil
+}
+
+// quoteEnd returns the index just past the quoted string at the start
+// of s, or -1 if it is not closed
+func quoteEnd(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q && q == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// stripComment drops a " #" comment and surrounding space, ignoring
+// "#" inside quotes
+func stripComment(s string) string {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\'':
+			if i > 0 && s[i-1] != ' ' && s[i-1] != '[' && s[i-1] != '{' && s[i-1] != ',' {
+				continue // A quote inside a plain scalar
+			}
+			end := quoteEnd(s[i:])
+			if end < 0 {
+				return strings.TrimSpace(s)
+			}
+			i += end - 1
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// flowDepth counts the brackets s leaves open, ignoring quoted ones
+func flowDepth(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case '"', '\'':
+			end := quoteEnd(s[i:])
+			if end < 0 {
+				return depth
+			}
+			i += end - 1
+		}
+	}
+	return depth
+}
+
+// scalar converts a plain or quoted scalar to a string, int64, float64,
+// bool or nil
+func scalar(s string) (any, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	switch s[0] {
+	case '"':
+		if quoteEnd(s) != len(s) {
+			return nil, fmt.Errorf("unterminated or trailing text after string %s", s)
+		}
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s: %w", s, err)
+		}
+		return v, nil
+	case '\'':
+		if quoteEnd(s) != len(s) {
+			return nil, fmt.Errorf("unterminated or trailing text after string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case '&', '*', '!':
+		return nil, errors.New("anchors, aliases and tags are not supported")
+	case '@', '`', '%', '|', '>':
+		return nil, fmt.Errorf("%q cannot start a plain scalar; quote it", s[0])
+	}
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if !numeric(s) {
+		return s, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0o") {
+		if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return n, nil
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
+
+// numeric reports whether s starts like a number: a digit, or a sign or
+// "." before one
+func numeric(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	s = strings.TrimPrefix(s, ".")
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+// flow parses a flow collection: [a, b] or {k: v}
+type flow struct {
+	s string
+	i int
+}
+
+// space skips spaces and returns the new position
+func (f *flow) space() int {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+	return f.i
+}
+
+func (f *flow) value() (any, error) {
+	if f.space() == len(f.s) {
+		return nil, errors.New("unexpected end of flow collection")
+	}
+	switch f.s[f.i] {
+	case '[':
+		f.i++
+		items := []any{}
+		for {
+			if f.space() < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return items, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			if err := f.sep(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.i++
+		m := map[string]any{}
+		for {
+			if f.space() < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			k, err := f.token(true)
+			if err != nil {
+				return nil, err
+			}
+			if k == nil {
+				return nil, errors.New("empty key")
+			}
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			if f.space() == len(f.s) || f.s[f.i] != ':' {
+				return nil, fmt.Errorf("want \":\" after key %q", key)
+			}
+			f.i++
+			if _, dup := m[key]; dup {
+				return nil, fmt.Errorf("duplicate key %q", key)
+			}
+			if m[key], err = f.value(); err != nil {
+				return nil, err
+			}
+			if err := f.sep('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f.token(false)
+}
+
+// sep consumes a "," or peeks at the closing bracket
+func (f *flow) sep(closing byte) error {
+	switch {
+	case f.space() == len(f.s):
+		return fmt.Errorf("missing %q", string(closing))
+	case f.s[f.i] == ',':
+		f.i++
+	case f.s[f.i] != closing:
+		return fmt.Errorf("want \",\" or %q, got %q", string(closing), f.s[f.i:])
+	}
+	return nil
+}
+
+// token reads a scalar inside a flow collection; a key also ends at ":"
+func (f *flow) token(key bool) (any, error) {
+	start := f.space()
+	if start < len(f.s) && (f.s[start] == '"' || f.s[start] == '\'') {
+		end := quoteEnd(f.s[start:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string %s", f.s[start:])
+		}
+		f.i += end
+		return scalar(f.s[start:f.i])
+	}
+	for ; f.i < len(f.s); f.i++ {
+		c := f.s[f.i]
+		if c == ',' || c == ']' || c == '}' || c == '[' || c == '{' {
+			break
+		}
+		if c == ':' && (key || f.i+1 == len(f.s) || f.s[f.i+1] == ' ') {
+			break
+		}
+	}
+	return scalar(f.s[start:f.i])
+}
@@ -0,0 +1,203 @@
+// Package stackeffect parses Forth stack-effect notation such as
+// "( a b -- a+b )" or "( addr u -- )" into a typed AST, so effects can
+// be compared and reasoned about rather than treated as opaque strings.
+package stackeffect
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is an item's kind, taken from an explicit "name:type" hint or
+// from the conventional Forth name (n, u, flag, addr, c, d, xt)
+type Type string
+
+const (
+	Any       Type = "any"
+	Int       Type = "int"  // n, +n
+	Uint      Type = "uint" // u
+	Flag      Type = "flag" // flag, f, ?
+	Addr      Type = "addr" // addr, a-addr, c-addr
+	Char      Type = "char" // c, char
+	Double    Type = "double"
+	ExecToken Type = "xt"
+)
+
+// nameTypes maps conventional item names to their Type
+var nameTypes = map[string]Type{
+	"n": Int, "+n": Int, "u": Uint, "flag": Flag, "f": Flag, "?": Flag,
+	"addr": Addr, "a-addr": Addr, "c-addr": Addr, "c": Char, "char": Char,
+	"d": Double, "ud": Double, "xt": ExecToken, "x": Any,
+}
+
+// hintTypes maps explicit hints in "name:type" items to their Type
+var hintTypes = map[string]Type{
+	"any": Any, "int": Int, "uint": Uint, "flag": Flag, "bool": Flag,
+	"addr": Addr, "char": Char, "double": Double, "xt": ExecToken,
+}
+
+// Item is one named stack item
+type Item struct {
+	Name string // As written, with superscripts rewritten as "^k"
+	Type Type
+	Pos  int // Byte offset of the item in the parsed string
+}
+
+// Effect is a parsed stack effect
+type Effect struct {
+	Inputs  []Item
+	Outputs []Item
+}
+
+// Arity returns the number of items consumed and produced
+func (e Effect) Arity() (in, out int) {
+	return len(e.Inputs), len(e.Outputs)
+}
+
+// Names returns the input and output item names
+func (e Effect) Names() (in, out []string) {
+	names := func(items []Item) []string {
+		s := make([]string, len(items))
+		for i, it := range items {
+			s[i] = it.Name
+		}
+		return s
+	}
+	return names(e.Inputs), names(e.Outputs)
+}
+
+// String renders the canonical "( a b -- c )" form
+func (e Effect) String() string {
+	in, out := e.Names()
+	parts := append([]string{"("}, in...)
+	parts = append(parts, "--")
+	parts = append(parts, out...)
+	return strings.Join(append(parts, ")"), " ")
+}
+
+// SyntaxError reports where an effect string stopped making sense
+type SyntaxError struct {
+	Src string
+	Pos int // Byte offset into Src
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("stack effect %q: %s at offset %d", e.Src, e.Msg, e.Pos)
+}
+
+// separators split inputs from outputs across dialects
+var separators = []string{"--", "->", "→", "—", "=>"}
+
+// superscripts rewrites unicode powers so "n²" and "n^2" compare equal
+var superscripts = strings.NewReplacer(
+	"⁰", "^0", "¹", "^1", "²", "^2", "³", "^3", "⁴", "^4",
+	"⁵", "^5", "⁶", "^6", "⁷", "^7", "⁸", "^8", "⁹", "^9", "ⁿ", "^n",
+)
+
+// Parse reads the common dialects: "( n -- n² )", "[ n -> n^2 ]", bare
+// "a b -- c", and arrows "→"/"=>". A trailing "\ comment" is ignored, as
+// is anything after the closing bracket. Errors are *SyntaxError.
+func Parse(s string) (Effect, error) {
+	// 1. Bounds: drop the comment and the brackets
+	end := len(s)
+	if i := strings.Index(s, "\\"); i >= 0 {
+		end = i
+	}
+	start := len(s[:end]) - len(strings.TrimLeft(s[:end], " \t\r\n"))
+	closer := ""
+	switch {
+	case strings.HasPrefix(s[start:end], "("):
+		closer = ")"
+	case strings.HasPrefix(s[start:end], "["):
+		closer = "]"
+	}
+	if closer != "" {
+		start++
+	}
+
+	// 2. The earliest separator divides inputs from outputs
+	sep, at := "", -1
+	for _, candidate := range separators {
+		if i := strings.Index(s[start:end], candidate); i >= 0 && (at < 0 || start+i < at) {
+			sep, at = candidate, start+i
+		}
+	}
+	if at < 0 {
+		return Effect{}, &SyntaxError{s, end, "no -- or -> separator"}
+	}
+	outEnd := end
+	if closer != "" {
+		i := strings.Index(s[at:end], closer)
+		if i < 0 {
+			return Effect{}, &SyntaxError{s, end, "missing " + closer}
+		}
+		outEnd = at + i
+	}
+	for _, candidate := range separators {
+		if i := strings.Index(s[at+len(sep):outEnd], candidate); i >= 0 {
+			return Effect{}, &SyntaxError{s, at + len(sep) + i, "second separator " + candidate}
+		}
+	}
+
+	// 3. Items on each side
+	inputs, err := items(s, start, at)
+	if err != nil {
+		return Effect{}, err
+	}
+	outputs, err := items(s, at+len(sep), outEnd)
+	if err != nil {
+		return Effect{}, err
+	}
+	return Effect{Inputs: inputs, Outputs: outputs}, nil
+}
+
+// items splits s[from:to] on whitespace into typed items
+func items(s string, from, to int) ([]Item, error) {
+	out := []Item{}
+	for i := from; i < to; {
+		if strings.ContainsRune(" \t\r\n", rune(s[i])) {
+			i++
+			continue
+		}
+		j := i
+		for j < to && !strings.ContainsRune(" \t\r\n", rune(s[j])) {
+			j++
+		}
+		item, err := newItem(s, s[i:j], i)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+		i = j
+	}
+	return out, nil
+}
+
+// newItem types word, honouring a "name:type" hint
+func newItem(src, word string, pos int) (Item, error) {
+	name, hint, hinted := strings.Cut(word, ":")
+	if hinted {
+		t, ok := hintTypes[strings.ToLower(hint)]
+		if !ok || name == "" {
+			return Item{}, &SyntaxError{src, pos + len(name) + 1, fmt.Sprintf("unknown type hint %q", hint)}
+		}
+		return Item{Name: superscripts.Replace(name), Type: t, Pos: pos}, nil
+	}
+	name = superscripts.Replace(name)
+	return Item{Name: name, Type: typeOf(name), Pos: pos}, nil
+}
+
+// typeOf infers a Type from a conventional name, ignoring numeric
+// suffixes and powers ("n1", "u2", "n^2")
+func typeOf(name string) Type {
+	base, _, _ := strings.Cut(strings.ToLower(name), "^")
+	base = strings.TrimRight(base, "0123456789'")
+	if t, ok := nameTypes[base]; ok {
+		return t
+	}
+	if strings.HasSuffix(base, "-flag") || strings.HasSuffix(base, "?") {
+		return Flag
+	}
+	return Any
+}
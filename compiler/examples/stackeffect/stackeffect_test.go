@@ -0,0 +1,89 @@
+package stackeffect
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src             string
+		inputs, outputs []Item
+	}{
+		{"( n -- n² )",
+			[]Item{{"n", Int, 2}},
+			[]Item{{"n^2", Int, 7}}},
+		{"( a b -- a+b )",
+			[]Item{{"a", Any, 2}, {"b", Any, 4}},
+			[]Item{{"a+b", Any, 9}}},
+		{"( n -- flag )",
+			[]Item{{"n", Int, 2}},
+			[]Item{{"flag", Flag, 7}}},
+		{"( addr u -- )",
+			[]Item{{"addr", Addr, 2}, {"u", Uint, 7}},
+			[]Item{}},
+		{"( c-addr u1 -- char ok? xt )",
+			[]Item{{"c-addr", Addr, 2}, {"u1", Uint, 9}},
+			[]Item{{"char", Char, 15}, {"ok?", Flag, 20}, {"xt", ExecToken, 24}}},
+		{"( len:uint done:bool -- d )",
+			[]Item{{"len", Uint, 2}, {"done", Flag, 11}},
+			[]Item{{"d", Double, 24}}},
+		{"[ n1 n2 -> n3 ]",
+			[]Item{{"n1", Int, 2}, {"n2", Int, 5}},
+			[]Item{{"n3", Int, 11}}},
+		{"x → is-flag", []Item{{"x", Any, 0}}, []Item{{"is-flag", Flag, 6}}},
+		{"( -- ) \\ does nothing", []Item{}, []Item{}},
+		{"  ( n => n ) trailing", []Item{{"n", Int, 4}}, []Item{{"n", Int, 9}}},
+	}
+	for _, tc := range tests {
+		e, err := Parse(tc.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(e.Inputs, tc.inputs) || !reflect.DeepEqual(e.Outputs, tc.outputs) {
+			t.Errorf("Parse(%q) = %+v -- %+v, want %+v -- %+v", tc.src, e.Inputs, e.Outputs, tc.inputs, tc.outputs)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{"( n n )", 7, "no -- or -> separator"},
+		{"( n -- n", 8, "missing )"},
+		{"[ a -- b ) \\ mismatched", 11, "missing ]"},
+		{"( a -- b -- c )", 9, "second separator --"},
+		{"( a -> b => c )", 9, "second separator =>"},
+		{"( n:float -- n )", 4, `unknown type hint "float"`},
+		{"( :int -- n )", 3, `unknown type hint "int"`},
+	}
+	for _, tc := range tests {
+		_, err := Parse(tc.src)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("Parse(%q) error = %v, want a *SyntaxError", tc.src, err)
+			continue
+		}
+		if se.Pos != tc.pos || se.Msg != tc.msg || se.Src != tc.src {
+			t.Errorf("Parse(%q) = %q at %d, want %q at %d", tc.src, se.Msg, se.Pos, tc.msg, tc.pos)
+		}
+	}
+}
+
+func TestEffectString(t *testing.T) {
+	e, err := Parse("[ a:int b -> c ]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.String(); got != "( a b -- c )" {
+		t.Errorf("String() = %q", got)
+	}
+	if in, out := e.Arity(); in != 2 || out != 1 {
+		t.Errorf("Arity() = %d, %d", in, out)
+	}
+}
@@ -0,0 +1,281 @@
+// Package verify checks Forth code against its declared stack effect
+// without running it, so verification needs no agent round trip.
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/quivent/fifth/compiler/examples/stackeffect"
+)
+
+// Effect is a dialect-independent stack effect
+type Effect struct {
+	Inputs  []string `json:"inputs"`
+	Outputs []string `json:"outputs"`
+}
+
+// String renders the canonical "( a b -- c )" form
+func (e Effect) String() string {
+	parts := append([]string{"("}, e.Inputs...)
+	parts = append(parts, "--")
+	parts = append(parts, e.Outputs...)
+	return strings.Join(append(parts, ")"), " ")
+}
+
+// ParseEffect parses the common stack-effect dialects (see
+// stackeffect.Parse), keeping only the item names
+func ParseEffect(s string) (Effect, error) {
+	e, err := stackeffect.Parse(s)
+	if err != nil {
+		return Effect{}, err
+	}
+	in, out := e.Names()
+	return Effect{Inputs: in, Outputs: out}, nil
+}
+
+// coreWords gives (inputs, outputs) for the words the verifier knows
+var coreWords = map[string][2]int{
+	"dup": {1, 2}, "drop": {1, 0}, "swap": {2, 2}, "over": {2, 3},
+	"rot": {3, 3}, "-rot": {3, 3}, "nip": {2, 1}, "tuck": {2, 3},
+	"2dup": {2, 4}, "2drop": {2, 0}, "2swap": {4, 4}, "2over": {4, 6},
+	"+": {2, 1}, "-": {2, 1}, "*": {2, 1}, "/": {2, 1}, "mod": {2, 1},
+	"/mod": {2, 2}, "*/": {3, 1}, "negate": {1, 1}, "abs": {1, 1},
+	"min": {2, 1}, "max": {2, 1}, "1+": {1, 1}, "1-": {1, 1},
+	"2*": {1, 1}, "2/": {1, 1}, "lshift": {2, 1}, "rshift": {2, 1},
+	"=": {2, 1}, "<>": {2, 1}, "<": {2, 1}, ">": {2, 1},
+	"0=": {1, 1}, "0<": {1, 1}, "0>": {1, 1}, "0<>": {1, 1},
+	"and": {2, 1}, "or": {2, 1}, "xor": {2, 1}, "invert": {1, 1},
+	"@": {1, 1}, "!": {2, 0}, "c@": {1, 1}, "c!": {2, 0}, "+!": {2, 0},
+	".": {1, 0}, "emit": {1, 0}, "cr": {0, 0}, "true": {0, 1}, "false": {0, 1},
+	">r": {1, 0}, "r>": {0, 1}, "r@": {0, 1},
+	"i": {0, 1}, "j": {0, 1}, "leave": {0, 0}, "unloop": {0, 0},
+}
+
+// Diagnostic is one way the code disagrees with its stack effect
+type Diagnostic struct {
+	Word    string `json:"word,omitempty"` // Offending word; "" for the definition as a whole
+	Index   int    `json:"index"`          // Position of Word in the checked body, from 0
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Word == "" {
+		return d.Message
+	}
+	return fmt.Sprintf("word %d %q: %s", d.Index, d.Word, d.Message)
+}
+
+// definition is a colon definition's name, declared effect and body
+type definition struct {
+	name   string
+	effect *Effect // nil when the definition declares none
+	body   []string
+}
+
+// parse strips comments and splits code into colon definitions. Words
+// outside any definition form a final anonymous one.
+func parse(code string) []definition {
+	var fields []string
+	for _, line := range strings.Split(code, "\n") {
+		lineFields := strings.Fields(line)
+		if i := slices.Index(lineFields, "\\"); i >= 0 {
+			lineFields = lineFields[:i]
+		}
+		fields = append(fields, lineFields...)
+	}
+
+	var (
+		defs []definition
+		cur  *definition
+		top  []string // Words outside definitions
+	)
+	for i := 0; i < len(fields); i++ {
+		switch w := strings.ToLower(fields[i]); w {
+		case "(":
+			// A comment directly after the name is the declared effect
+			start := i
+			for i < len(fields) && !strings.HasSuffix(fields[i], ")") {
+				i++
+			}
+			if cur != nil && cur.effect == nil && len(cur.body) == 0 {
+				end := min(i+1, len(fields))
+				if e, err := ParseEffect(strings.Join(fields[start:end], " ")); err == nil {
+					cur.effect = &e
+				}
+			}
+		case ".\"":
+			// Printed string; runs to the next word ending in a quote
+			for i++; i < len(fields) && !strings.HasSuffix(fields[i], "\""); i++ {
+			}
+		case ":":
+			defs = append(defs, definition{})
+			cur = &defs[len(defs)-1]
+			if i++; i < len(fields) {
+				cur.name = strings.ToLower(fields[i])
+			}
+		case ";":
+			cur = nil
+		default:
+			if cur != nil {
+				cur.body = append(cur.body, w)
+			} else {
+				top = append(top, w)
+			}
+		}
+	}
+	if len(top) > 0 || len(defs) == 0 {
+		defs = append(defs, definition{body: top})
+	}
+	return defs
+}
+
+// closers names the word that ends each control structure
+var closers = map[string]string{"if": "then", "do": "loop", "begin": "until"}
+
+// frame is an open control structure
+type frame struct {
+	opener  string // "if", "do" or "begin"
+	start   int    // Depth when the structure opened
+	thenEnd int    // if: depth at the end of the if-part
+	hasElse bool   // if: seen else
+	exit    int    // begin: depth leaving through while
+	hasExit bool   // begin: seen while
+}
+
+// StackEffect checks that code leaves the stack as effect declares.
+// When code holds colon definitions, the last one is checked; earlier
+// ones that declare an effect can be called from it, and recurse uses
+// the declared effect. It reports false with diagnostics when the code
+// disagrees, and an error when it cannot decide: an unparsable effect,
+// unbalanced control words, or words of unknown effect.
+func StackEffect(code, effect string) (bool, []Diagnostic, error) {
+	want, err := ParseEffect(effect)
+	if err != nil {
+		return false, nil, err
+	}
+	wantIn, wantOut := len(want.Inputs), len(want.Outputs)
+
+	// 1. Effects of the helper definitions and of recurse
+	defs := parse(code)
+	words := make(map[string][2]int, len(defs))
+	for _, d := range defs[:len(defs)-1] {
+		if d.effect != nil && d.name != "" {
+			words[d.name] = [2]int{len(d.effect.Inputs), len(d.effect.Outputs)}
+		}
+	}
+	words["recurse"] = [2]int{wantIn, wantOut}
+
+	// 2. Walk the body tracking depth relative to entry
+	var (
+		diags  []Diagnostic
+		frames []frame
+	)
+	depth := 0
+	use := func(i int, w string, in, out int) {
+		if wantIn+depth < in {
+			diags = append(diags, Diagnostic{w, i,
+				fmt.Sprintf("needs %d items, %d available", in, max(wantIn+depth, 0))})
+		}
+		depth += out - in
+	}
+	closeFrame := func(w, opener string) (*frame, error) {
+		if len(frames) == 0 || frames[len(frames)-1].opener != opener {
+			return nil, fmt.Errorf("%s without %s", w, opener)
+		}
+		f := frames[len(frames)-1]
+		frames = frames[:len(frames)-1]
+		return &f, nil
+	}
+	unbalanced := func(i int, w string, want int) {
+		if depth != want {
+			diags = append(diags, Diagnostic{w, i,
+				fmt.Sprintf("paths leave different depths (%+d vs %+d)", depth, want)})
+		}
+	}
+
+	body := defs[len(defs)-1].body
+	for i, w := range body {
+		switch w {
+		case "if":
+			use(i, w, 1, 0)
+			frames = append(frames, frame{opener: "if", start: depth})
+		case "else":
+			if len(frames) == 0 || frames[len(frames)-1].opener != "if" {
+				return false, nil, errors.New("else without if")
+			}
+			f := &frames[len(frames)-1]
+			f.thenEnd, f.hasElse = depth, true
+			depth = f.start
+		case "then":
+			f, err := closeFrame(w, "if")
+			if err != nil {
+				return false, nil, err
+			}
+			want := f.start
+			if f.hasElse {
+				want = f.thenEnd
+			}
+			unbalanced(i, w, want)
+		case "do", "?do":
+			use(i, w, 2, 0)
+			frames = append(frames, frame{opener: "do", start: depth})
+		case "loop", "+loop":
+			if w == "+loop" {
+				use(i, w, 1, 0)
+			}
+			f, err := closeFrame(w, "do")
+			if err != nil {
+				return false, nil, err
+			}
+			unbalanced(i, w, f.start)
+		case "begin":
+			frames = append(frames, frame{opener: "begin", start: depth})
+		case "while":
+			if len(frames) == 0 || frames[len(frames)-1].opener != "begin" {
+				return false, nil, errors.New("while without begin")
+			}
+			use(i, w, 1, 0)
+			f := &frames[len(frames)-1]
+			f.exit, f.hasExit = depth, true
+		case "until", "again", "repeat":
+			if w == "until" {
+				use(i, w, 1, 0)
+			}
+			f, err := closeFrame(w, "begin")
+			if err != nil {
+				return false, nil, err
+			}
+			unbalanced(i, w, f.start)
+			if f.hasExit {
+				depth = f.exit
+			}
+		default:
+			effect, known := coreWords[w]
+			if !known {
+				effect, known = words[w]
+			}
+			if !known {
+				if _, err := strconv.Atoi(w); err != nil {
+					return false, nil, fmt.Errorf("cannot verify %q locally", w)
+				}
+				effect = [2]int{0, 1}
+			}
+			use(i, w, effect[0], effect[1])
+		}
+	}
+	if len(frames) > 0 {
+		opener := frames[len(frames)-1].opener
+		return false, nil, fmt.Errorf("%s without %s", opener, closers[opener])
+	}
+
+	// 3. What is left must match the declared outputs
+	if wantIn+depth != wantOut {
+		diags = append(diags, Diagnostic{Index: len(body),
+			Message: fmt.Sprintf("leaves %d items, %s declares %d", max(wantIn+depth, 0), want, wantOut)})
+	}
+	return len(diags) == 0, diags, nil
+}
@@ -0,0 +1,103 @@
+package verify
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStackEffect(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		effect string
+		ok     bool
+		diags  []string // Diagnostic.String() of each, in order
+	}{
+		{"square", "dup *", "( n -- n² )", true, nil},
+		{"colon definition", ": sq dup * ;", "( n -- n )", true, nil},
+		{"helper with declared effect", ": sq ( n -- n ) dup * ;\n: quad sq sq ;", "( n -- n )", true, nil},
+		{"comments", "\\ square it\ndup * \\ done", "n -- n", true, nil},
+		{"if then", "dup 0 < if negate then", "( n -- |n| )", true, nil},
+		{"if else then", "2dup < if swap then drop", "( a b -- m )", true, nil},
+		{"counted loop", "0 swap 1+ 1 ?do i + loop", "( n -- sum )", true, nil},
+		{"begin while repeat", "begin dup while 1- repeat", "( n -- 0 )", true, nil},
+		{"recurse", "dup 2 < if drop 1 else dup 1- recurse * then", "( n -- n! )", true, nil},
+		{"arrow dialect", "swap", "[ a b -> b a ]", true, nil},
+
+		{"too many outputs", "dup", "( n -- n )", false,
+			[]string{"leaves 2 items, ( n -- n ) declares 1"}},
+		{"underflow", "+", "( n -- n )", false, []string{
+			`word 0 "+": needs 2 items, 1 available`,
+			"leaves 0 items, ( n -- n ) declares 1",
+		}},
+		{"unbalanced branches", "dup 0 < if negate else drop then", "( n -- n )", false, []string{
+			`word 7 "then": paths leave different depths (-1 vs +0)`,
+			"leaves 0 items, ( n -- n ) declares 1",
+		}},
+		{"underflow in a loop", "0 ?do dup loop", "( n -- n )", false, []string{
+			`word 2 "dup": needs 1 items, 0 available`,
+			`word 3 "loop": paths leave different depths (+0 vs -1)`,
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, diags, err := StackEffect(tc.code, tc.effect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range diags {
+				got = append(got, d.String())
+			}
+			if ok != tc.ok || !slices.Equal(got, tc.diags) {
+				t.Errorf("StackEffect(%q, %q) = %v, %q; want %v, %q", tc.code, tc.effect, ok, got, tc.ok, tc.diags)
+			}
+		})
+	}
+}
+
+func TestStackEffectErrors(t *testing.T) {
+	tests := []struct {
+		code, effect string
+		want         string // Error substring
+	}{
+		{"dup *", "( n n", "("},
+		{"dup frobnicate", "( n -- n )", `cannot verify "frobnicate" locally`},
+		{"then", "( n -- n )", "then without if"},
+		{"else", "( n -- n )", "else without if"},
+		{"dup if drop", "( n -- n )", "if without then"},
+		{"begin dup until loop", "( n -- n )", "loop without do"},
+		{"while", "( n -- n )", "while without begin"},
+	}
+	for _, tc := range tests {
+		ok, diags, err := StackEffect(tc.code, tc.effect)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("StackEffect(%q, %q) error = %v, want %q", tc.code, tc.effect, err, tc.want)
+		}
+		if ok || diags != nil {
+			t.Errorf("StackEffect(%q, %q) = %v, %v alongside an error", tc.code, tc.effect, ok, diags)
+		}
+	}
+}
+
+func TestParseEffect(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"( n -- n² )", "( n -- n^2 )"},
+		{"[ a b -> a+b ]", "( a b -- a+b )"},
+		{"addr u --", "( addr u -- )"},
+		{"( -- flag )", "( -- flag )"},
+	}
+	for _, tc := range tests {
+		e, err := ParseEffect(tc.in)
+		if err != nil {
+			t.Errorf("ParseEffect(%q): %v", tc.in, err)
+			continue
+		}
+		if got := e.String(); got != tc.want {
+			t.Errorf("ParseEffect(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}